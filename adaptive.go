@@ -0,0 +1,98 @@
+// FILENAME: adaptive.go
+package gothrottle
+
+import (
+	"math"
+	"sync"
+)
+
+// AdaptiveConcurrency adjusts a limiter's concurrency limit from task outcomes with
+// additive increase, multiplicative decrease (AIMD). The limit starts at MaxConcurrent.
+// Every successful task raises it by 1/limit, i.e. by one after a limit's worth of
+// successes, and every failed task multiplies it by Backoff.
+type AdaptiveConcurrency struct {
+	// MinLimit is the lowest the limit can drop to. Defaults to 1.
+	MinLimit int
+
+	// MaxLimit is the highest the limit can grow to. Zero means no upper bound.
+	MaxLimit int
+
+	// Backoff is the factor applied to the limit when a task fails, between 0 and 1.
+	// Defaults to 0.5.
+	Backoff float64
+}
+
+// aimdState holds the current adaptive concurrency limit.
+type aimdState struct {
+	mu    sync.Mutex
+	limit float64 // zero until first used
+}
+
+// minLimit returns MinLimit, or 1 if it is not set.
+func (a *AdaptiveConcurrency) minLimit() float64 {
+	if a.MinLimit < 1 {
+		return 1
+	}
+	return float64(a.MinLimit)
+}
+
+// clamp bounds limit by MinLimit and MaxLimit.
+func (a *AdaptiveConcurrency) clamp(limit float64) float64 {
+	if a.MaxLimit > 0 {
+		limit = math.Min(limit, float64(a.MaxLimit))
+	}
+	return math.Max(limit, a.minLimit())
+}
+
+// current returns the limit, initializing it from opts on first use. The caller must hold s.mu.
+func (s *aimdState) current(opts *Options) float64 {
+	if s.limit == 0 {
+		start := float64(opts.MaxConcurrent)
+		if start <= 0 {
+			start = float64(opts.Adaptive.MaxLimit)
+		}
+		s.limit = start
+	}
+	s.limit = opts.Adaptive.clamp(s.limit)
+	return s.limit
+}
+
+// maxConcurrent returns the concurrency limit to enforce for a job of the given weight.
+// Without AdaptiveConcurrency it is MaxConcurrent. A job heavier than the adaptive
+// limit may still run, but only on its own.
+func (s *aimdState) maxConcurrent(opts *Options, weight int) int {
+	if opts.Adaptive == nil {
+		return opts.MaxConcurrent
+	}
+
+	s.mu.Lock()
+	limit := int(s.current(opts))
+	s.mu.Unlock()
+
+	if weight > limit {
+		return weight
+	}
+	return limit
+}
+
+// record adjusts the limit after a task succeeded or failed.
+func (s *aimdState) record(opts *Options, success bool) {
+	if opts.Adaptive == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := s.current(opts)
+	if success {
+		limit += 1 / limit
+	} else {
+		backoff := opts.Adaptive.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = 0.5
+		}
+		limit *= backoff
+	}
+	s.limit = opts.Adaptive.clamp(limit)
+}
@@ -0,0 +1,71 @@
+// FILENAME: batch_done.go
+package gothrottle
+
+import "sync"
+
+// doneBatch collects the weight of finished jobs until the next flush when
+// Options.DoneFlushInterval is set.
+type doneBatch struct {
+	mu      sync.Mutex
+	pending map[string]int // weight to release by datastore ID
+	jobs    int            // finished jobs whose weight is pending
+	timer   Timer          // fires the next flush, nil if nothing is pending
+}
+
+// batchDone adds a finished job's weight to the pending batch and reports true, or
+// reports false if the job has to be released with RegisterDone right away.
+func (l *Limiter) batchDone(storeID string, weight int) bool {
+	opts := l.options()
+	if opts.DoneFlushInterval <= 0 || opts.StaleTimeout > 0 {
+		return false
+	}
+	if _, ok := l.datastore.(BatchRegisterer); !ok {
+		return false
+	}
+
+	b := &l.done
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending == nil {
+		b.pending = make(map[string]int)
+	}
+	b.pending[storeID] += weight
+	b.jobs++
+	if b.timer == nil {
+		b.timer = opts.clock().NewTimer(opts.DoneFlushInterval)
+		go l.flushAfter(b.timer)
+	}
+	return true
+}
+
+// flushAfter flushes the pending batch once timer fires.
+func (l *Limiter) flushAfter(timer Timer) {
+	<-timer.C()
+	l.flushDone()
+}
+
+// flushDone releases the pending weight with one BatchRegisterDone call per
+// datastore ID, then marks the batched jobs as finished.
+func (l *Limiter) flushDone() {
+	b := &l.done
+	b.mu.Lock()
+	pending, jobs := b.pending, b.jobs
+	b.pending, b.jobs, b.timer = nil, 0, nil
+	b.mu.Unlock()
+
+	batcher := l.datastore.(BatchRegisterer)
+	for storeID, weight := range pending {
+		if err := batcher.BatchRegisterDone(storeID, weight); err != nil {
+			l.storeError("batch register done", err)
+		}
+	}
+
+	l.mu.Lock()
+	l.inflight -= jobs
+	l.signalIdle()
+	l.mu.Unlock()
+
+	// Slots were freed, so queued jobs may be able to run
+	l.notify()
+}
@@ -0,0 +1,119 @@
+// FILENAME: cache.go
+package gothrottle
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache stores task results for ScheduleCached.
+type Cache interface {
+	// Get returns the value stored under key, if it exists and has not expired.
+	Get(key string) (value interface{}, ok bool)
+
+	// Set stores value under key for ttl.
+	Set(key string, value interface{}, ttl time.Duration)
+}
+
+// MemoryCache is an in-memory Cache. Expired entries are removed when they are
+// read and by a periodic sweep on Set.
+type MemoryCache struct {
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	nextSweep time.Time
+	clock     Clock
+}
+
+// cacheEntry is a cached value with its expiry time.
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return newMemoryCache(realClock{})
+}
+
+// newMemoryCache creates an empty MemoryCache whose entries expire on clock.
+func newMemoryCache(clock Clock) *MemoryCache {
+	return &MemoryCache{entries: make(map[string]cacheEntry), clock: clock}
+}
+
+// Get returns the value stored under key, if it exists and has not expired.
+func (c *MemoryCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.clock.Now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores value under key for ttl. A ttl that is not positive stores nothing.
+func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	// Sweep at most once per ttl so entries that are never read again do not pile up
+	if now.After(c.nextSweep) {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(ttl)
+	}
+	c.entries[key] = cacheEntry{value: value, expiresAt: now.Add(ttl)}
+}
+
+// Len returns the number of entries, including expired ones not yet removed.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// ScheduleCached returns the result cached under key if it is fresh, without taking
+// a slot from the limiter. Otherwise it schedules task with default priority (5) and
+// weight (1) and, if the task succeeds, caches its result for ttl. Errors are not
+// cached. Results are stored in Options.Cache, or in a MemoryCache owned by the
+// limiter if none is set.
+func (l *Limiter) ScheduleCached(key string, ttl time.Duration, task func() (interface{}, error)) (interface{}, error) {
+	cache := l.cache()
+	if result, ok := cache.Get(key); ok {
+		return result, nil
+	}
+
+	result, err := l.Schedule(task)
+	if err != nil {
+		return nil, err
+	}
+	cache.Set(key, result, ttl)
+	return result, nil
+}
+
+// cache returns Options.Cache, or the limiter's own MemoryCache if it is not set.
+func (l *Limiter) cache() Cache {
+	if cache := l.options().Cache; cache != nil {
+		return cache
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.memoryCache == nil {
+		l.memoryCache = newMemoryCache(l.options().clock())
+	}
+	return l.memoryCache
+}
@@ -0,0 +1,51 @@
+// FILENAME: chain.go
+package gothrottle
+
+import "context"
+
+// Chain runs tasks only when every one of several independent limiters allows it,
+// for example a per-user limiter and a global one. Unlike Group, the limiters keep
+// their own datastores and options and are used unchanged.
+//
+// A task acquires a slot in each limiter in order, holding the slots acquired so
+// far while it waits for the next one, and releases them in reverse order when it
+// finishes. Put the limiter that is most likely to make a task wait first, so that
+// slots of the later ones are not held idle.
+type Chain struct {
+	limiters []*Limiter
+}
+
+// NewChain creates a Chain that acquires the limiters in the given order.
+func NewChain(limiters ...*Limiter) *Chain {
+	return &Chain{limiters: limiters}
+}
+
+// Schedule runs task with default priority (5) and weight (1) in every limiter of
+// the chain and blocks until completion.
+func (c *Chain) Schedule(task func() (interface{}, error)) (interface{}, error) {
+	return c.ScheduleContextWithOptions(context.Background(), task, 5, 1)
+}
+
+// ScheduleWithOptions runs task with custom priority and weight in every limiter of
+// the chain and blocks until completion.
+func (c *Chain) ScheduleWithOptions(task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	return c.ScheduleContextWithOptions(context.Background(), task, priority, weight)
+}
+
+// ScheduleContextWithOptions runs task with custom priority and weight in every
+// limiter of the chain, giving up with ctx.Err() if ctx is done while it is still
+// waiting for a slot. If a limiter fails the job, e.g. because it was stopped, the
+// slots already acquired are released and the error is returned without running task.
+func (c *Chain) ScheduleContextWithOptions(ctx context.Context, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	return c.schedule(ctx, c.limiters, task, priority, weight)
+}
+
+// schedule acquires the first of limiters and schedules the rest of the chain inside it.
+func (c *Chain) schedule(ctx context.Context, limiters []*Limiter, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	if len(limiters) == 0 {
+		return runTask(task)
+	}
+	return limiters[0].scheduleUntilDone(ctx, func() (interface{}, error) {
+		return c.schedule(ctx, limiters[1:], task, priority, weight)
+	}, priority, weight)
+}
@@ -0,0 +1,261 @@
+// FILENAME: chained_store.go
+package gothrottle
+
+import (
+	"context"
+	"time"
+)
+
+// ChainedStore is a Datastore that grants a job only if every store in the chain grants
+// it, for example a LocalStore for a cheap per-process limit in front of a RedisStore for
+// the global limit. Every store is passed the limiter's Options; wrap a store with
+// NewOverrideStore to give it limits of its own.
+type ChainedStore struct {
+	stores []Datastore
+}
+
+// NewChainedStore creates a ChainedStore that asks stores in order. A later denial
+// releases the earlier grants but cannot roll back their MinTime, window or GCRA state,
+// so stores after the first are peeked before any is asked if they implement Peeker.
+// Put the store most likely to deny first, since it is asked without a peek.
+func NewChainedStore(stores ...Datastore) *ChainedStore {
+	return &ChainedStore{stores: stores}
+}
+
+// Request asks each store in turn and stops at the first denial or error, releasing the
+// grants of the stores before it. A denial found by peeking the later stores first is
+// returned without asking any store.
+func (cs *ChainedStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	if len(cs.stores) > 1 {
+		for _, store := range cs.stores[1:] {
+			if denied, waitTime := peekDenied(store, limiterID, weight, opts); denied {
+				return false, waitTime, nil
+			}
+		}
+	}
+
+	for i, store := range cs.stores {
+		canRun, waitTime, err = store.Request(limiterID, weight, opts)
+		if err != nil || !canRun {
+			if releaseErr := cs.release(cs.stores[:i], limiterID, weight); releaseErr != nil && err == nil {
+				err = releaseErr
+			}
+			return false, waitTime, err
+		}
+	}
+	return true, 0, nil
+}
+
+// RegisterDone releases the job from every store, returning the first error.
+func (cs *ChainedStore) RegisterDone(limiterID string, weight int) error {
+	return cs.release(cs.stores, limiterID, weight)
+}
+
+// RegisterDonePartial releases part of a running job's weight from every store, even
+// after one fails, and returns the first error. It releases nothing if one of the
+// stores does not support partial releases.
+func (cs *ChainedStore) RegisterDonePartial(limiterID string, weight int) error {
+	if !cs.supportsPartial() {
+		return ErrPartialReleaseUnsupported
+	}
+
+	var firstErr error
+	for _, store := range cs.stores {
+		if err := registerDonePartial(store, limiterID, weight); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// supportsPartial reports whether every store supports partial releases.
+func (cs *ChainedStore) supportsPartial() bool {
+	for _, store := range cs.stores {
+		if !supportsPartial(store) {
+			return false
+		}
+	}
+	return true
+}
+
+// release calls RegisterDone on each of stores, even after one fails, and returns the first error.
+func (cs *ChainedStore) release(stores []Datastore, limiterID string, weight int) error {
+	var firstErr error
+	for _, store := range stores {
+		if err := store.RegisterDone(limiterID, weight); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Peek reports the first denial among the stores without acquiring a slot in any of
+// them. It returns ErrPeekUnsupported unless every store implements Peeker.
+func (cs *ChainedStore) Peek(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	for _, store := range cs.stores {
+		peeker, ok := store.(Peeker)
+		if !ok {
+			return false, 0, ErrPeekUnsupported
+		}
+		canRun, waitTime, err = peeker.Peek(limiterID, weight, opts)
+		if err != nil || !canRun {
+			return false, waitTime, err
+		}
+	}
+	return true, 0, nil
+}
+
+// SetCooldown sets the cooldown in every store that implements CooldownSetter, even
+// after one fails, and returns the first error.
+func (cs *ChainedStore) SetCooldown(limiterID string, until time.Time) error {
+	var firstErr error
+	for _, store := range cs.stores {
+		if setter, ok := store.(CooldownSetter); ok {
+			if err := setter.SetCooldown(limiterID, until); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Reset resets every store, even after one fails, and returns the first error. It
+// returns ErrResetUnsupported without resetting any store unless every store
+// implements Resetter.
+func (cs *ChainedStore) Reset(limiterID string) error {
+	for _, store := range cs.stores {
+		if _, ok := store.(Resetter); !ok {
+			return ErrResetUnsupported
+		}
+	}
+
+	var firstErr error
+	for _, store := range cs.stores {
+		if err := store.(Resetter).Reset(limiterID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CurrentUsage returns the highest running weight reported by the stores. It returns
+// ErrUsageUnsupported unless every store implements UsageReporter.
+func (cs *ChainedStore) CurrentUsage(limiterID string) (int, error) {
+	highest := 0
+	for _, store := range cs.stores {
+		reporter, ok := store.(UsageReporter)
+		if !ok {
+			return 0, ErrUsageUnsupported
+		}
+		running, err := reporter.CurrentUsage(limiterID)
+		if err != nil {
+			return 0, err
+		}
+		if running > highest {
+			highest = running
+		}
+	}
+	return highest, nil
+}
+
+// Ping pings every store that implements HealthChecker and returns the first error.
+func (cs *ChainedStore) Ping(ctx context.Context) error {
+	for _, store := range cs.stores {
+		if checker, ok := store.(HealthChecker); ok {
+			if err := checker.Ping(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Disconnect disconnects every store, returning the first error.
+func (cs *ChainedStore) Disconnect() error {
+	var firstErr error
+	for _, store := range cs.stores {
+		if err := store.Disconnect(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// overrideStore is the Datastore returned by NewOverrideStore.
+type overrideStore struct {
+	Datastore
+	override func(opts Options) Options
+}
+
+// NewOverrideStore wraps store so that it enforces the options returned by override,
+// which is called with the limiter's options on every request. Within a ChainedStore
+// this gives each store its own limits:
+//
+//	store := gothrottle.NewChainedStore(
+//		gothrottle.NewOverrideStore(gothrottle.NewLocalStore(), func(opts gothrottle.Options) gothrottle.Options {
+//			opts.MaxConcurrent = 5
+//			return opts
+//		}),
+//		redisStore,
+//	)
+func NewOverrideStore(store Datastore, override func(opts Options) Options) Datastore {
+	return &overrideStore{Datastore: store, override: override}
+}
+
+// Request asks the wrapped store with the overridden options.
+func (s *overrideStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	return s.Datastore.Request(limiterID, weight, s.override(opts))
+}
+
+// Peek peeks the wrapped store with the overridden options if it implements Peeker.
+func (s *overrideStore) Peek(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	peeker, ok := s.Datastore.(Peeker)
+	if !ok {
+		return false, 0, ErrPeekUnsupported
+	}
+	return peeker.Peek(limiterID, weight, s.override(opts))
+}
+
+// RegisterDonePartial releases part of a running job's weight from the wrapped store.
+func (s *overrideStore) RegisterDonePartial(limiterID string, weight int) error {
+	return registerDonePartial(s.Datastore, limiterID, weight)
+}
+
+// supportsPartial reports whether the wrapped store supports partial releases.
+func (s *overrideStore) supportsPartial() bool {
+	return supportsPartial(s.Datastore)
+}
+
+// SetCooldown sets the cooldown in the wrapped store if it implements CooldownSetter.
+func (s *overrideStore) SetCooldown(limiterID string, until time.Time) error {
+	if setter, ok := s.Datastore.(CooldownSetter); ok {
+		return setter.SetCooldown(limiterID, until)
+	}
+	return nil
+}
+
+// Reset resets the wrapped store if it implements Resetter.
+func (s *overrideStore) Reset(limiterID string) error {
+	resetter, ok := s.Datastore.(Resetter)
+	if !ok {
+		return ErrResetUnsupported
+	}
+	return resetter.Reset(limiterID)
+}
+
+// CurrentUsage reports the wrapped store's usage if it implements UsageReporter.
+func (s *overrideStore) CurrentUsage(limiterID string) (int, error) {
+	reporter, ok := s.Datastore.(UsageReporter)
+	if !ok {
+		return 0, ErrUsageUnsupported
+	}
+	return reporter.CurrentUsage(limiterID)
+}
+
+// Ping pings the wrapped store if it implements HealthChecker.
+func (s *overrideStore) Ping(ctx context.Context) error {
+	if checker, ok := s.Datastore.(HealthChecker); ok {
+		return checker.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// FILENAME: circuit.go
+package gothrottle
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops a limiter from running jobs while the resource behind them is
+// failing, so that the rate limit budget is not spent on doomed calls. After
+// FailureThreshold consecutive task errors the circuit opens and jobs fail with
+// ErrCircuitOpen without taking a slot. Once OpenDuration has passed the circuit is
+// half-open: up to HalfOpenProbes jobs run as probes, and the circuit closes once
+// they all succeed or opens again as soon as one fails.
+type CircuitBreaker struct {
+	// FailureThreshold is how many consecutive task errors open the circuit. Defaults to 5.
+	FailureThreshold int
+
+	// OpenDuration is how long the circuit stays open before probing. Defaults to 10s.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is how many jobs probe a half-open circuit. Defaults to 1.
+	HalfOpenProbes int
+}
+
+// failureThreshold returns FailureThreshold, or 5 if it is not set.
+func (b *CircuitBreaker) failureThreshold() int {
+	if b.FailureThreshold <= 0 {
+		return 5
+	}
+	return b.FailureThreshold
+}
+
+// openDuration returns OpenDuration, or 10s if it is not set.
+func (b *CircuitBreaker) openDuration() time.Duration {
+	if b.OpenDuration <= 0 {
+		return 10 * time.Second
+	}
+	return b.OpenDuration
+}
+
+// halfOpenProbes returns HalfOpenProbes, or 1 if it is not set.
+func (b *CircuitBreaker) halfOpenProbes() int {
+	if b.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return b.HalfOpenProbes
+}
+
+// circuitPhase is the state of a circuit breaker.
+type circuitPhase int
+
+const (
+	circuitClosed circuitPhase = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitState holds a limiter's circuit breaker state.
+type circuitState struct {
+	mu       sync.Mutex
+	phase    circuitPhase
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the circuit last opened
+	probes   int       // probes started while half-open
+	passed   int       // probes that succeeded while half-open
+}
+
+// open reports whether the circuit is open and not yet due for probing, so that a
+// new job can be turned away before it is queued.
+func (s *circuitState) open(opts *Options) bool {
+	if opts.CircuitBreaker == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phase == circuitOpen && opts.Clock.Now().Sub(s.openedAt) < opts.CircuitBreaker.openDuration()
+}
+
+// admit reports whether job may start, marking it as a probe if the circuit is half-open.
+func (s *circuitState) admit(opts *Options, job *Job) bool {
+	if opts.CircuitBreaker == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.phase == circuitOpen {
+		if opts.Clock.Now().Sub(s.openedAt) < opts.CircuitBreaker.openDuration() {
+			return false
+		}
+		s.phase, s.probes, s.passed = circuitHalfOpen, 0, 0
+	}
+	if s.phase == circuitHalfOpen {
+		if s.probes >= opts.CircuitBreaker.halfOpenProbes() {
+			return false
+		}
+		s.probes++
+		job.probe = true
+	}
+	return true
+}
+
+// unadmit hands back the probe taken by job when it could not start after all.
+func (s *circuitState) unadmit(job *Job) {
+	if !job.probe {
+		return
+	}
+	job.probe = false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phase == circuitHalfOpen && s.probes > 0 {
+		s.probes--
+	}
+}
+
+// record updates the circuit after job's task succeeded or failed.
+func (s *circuitState) record(opts *Options, job *Job, success bool) {
+	if opts.CircuitBreaker == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.phase {
+	case circuitClosed:
+		if success {
+			s.failures = 0
+			return
+		}
+		s.failures++
+		if s.failures >= opts.CircuitBreaker.failureThreshold() {
+			s.trip(opts)
+		}
+	case circuitHalfOpen:
+		// Only probes tell whether the resource has recovered
+		if !job.probe {
+			return
+		}
+		if !success {
+			s.trip(opts)
+			return
+		}
+		s.passed++
+		if s.passed >= opts.CircuitBreaker.halfOpenProbes() {
+			s.phase, s.failures = circuitClosed, 0
+			opts.Logger.Infof("gothrottle: limiter %q circuit closed", opts.ID)
+		}
+	}
+}
+
+// trip opens the circuit. The caller must hold s.mu.
+func (s *circuitState) trip(opts *Options) {
+	s.phase, s.openedAt = circuitOpen, opts.Clock.Now()
+	opts.Logger.Warnf("gothrottle: limiter %q circuit opened for %v", opts.ID, opts.CircuitBreaker.openDuration())
+}
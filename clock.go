@@ -0,0 +1,58 @@
+// FILENAME: clock.go
+package gothrottle
+
+import "time"
+
+// Clock is the source of time for the scheduler, LocalStore, DoneFlushInterval,
+// ScheduleWithRetry backoffs and the limiter's own result cache. Tests can set
+// Options.Clock to a fake implementation, such as clocktest.FakeClock, to control
+// MinTime spacing and retries without sleeping. RedisStore and PostgresStore use
+// the system clock, because their state is shared with other instances.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for d to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a Timer that sends the current time on its channel after d.
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a Ticker that sends the current time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a Clock's counterpart of time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the timer already
+	// expired or was stopped.
+	Stop() bool
+	// Reset changes the timer to expire after d. It returns true if the timer had
+	// been active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a Clock's counterpart of time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// realClock is the default Clock, backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+// realTimer adapts time.Timer to Timer.
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// realTicker adapts time.Ticker to Ticker.
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
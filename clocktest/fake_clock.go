@@ -0,0 +1,187 @@
+// FILENAME: fake_clock.go
+
+// Package clocktest provides a fake gothrottle.Clock for deterministic tests of
+// MinTime spacing, retries and other time-dependent behavior.
+//
+// Time only moves when the test calls Advance. A typical test schedules jobs on a
+// limiter created with Options.Clock set to a FakeClock, waits with BlockUntil until
+// the scheduler is sleeping on a timer, and then advances the clock past it:
+//
+//	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+//	limiter, _ := gothrottle.NewLimiter(gothrottle.Options{MinTime: time.Second, Clock: clock})
+//	h1, h2 := limiter.Submit(task), limiter.Submit(task)
+//	h1.Wait()
+//	clock.BlockUntil(1)        // the scheduler waits for MinTime
+//	clock.Advance(time.Second) // h2 may now start
+//	h2.Wait()
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+// FakeClock is a gothrottle.Clock whose time only moves when Advance is called.
+// It is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the fake current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock has advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a timer that fires once the clock has advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) gothrottle.Timer {
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	c.schedule(t, d)
+	c.mu.Unlock()
+	return t
+}
+
+// NewTicker creates a ticker that fires every time the clock has advanced by d.
+// It panics if d is not positive, like time.NewTicker.
+func (c *FakeClock) NewTicker(d time.Duration) gothrottle.Ticker {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.mu.Lock()
+	c.schedule(t, d)
+	c.mu.Unlock()
+	return fakeTicker{t}
+}
+
+// Advance moves the clock forward by d and fires every timer and ticker that is due,
+// in the order of their deadlines.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		next := c.nextDue(end)
+		if next == nil {
+			break
+		}
+		c.now = next.when
+		c.remove(next)
+		next.fire(c.now)
+		if next.period > 0 {
+			c.add(next, next.when.Add(next.period))
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil blocks until at least n timers and tickers are waiting to fire,
+// e.g. until the scheduler is sleeping before a retry.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// schedule arms t to fire after d. A timer with no time left fires right away.
+// The caller must hold c.mu.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	if d <= 0 && t.period == 0 {
+		t.fire(c.now)
+		return
+	}
+	c.add(t, c.now.Add(d))
+}
+
+// add registers t to fire at when. The caller must hold c.mu.
+func (c *FakeClock) add(t *fakeTimer, when time.Time) {
+	t.when = when
+	c.waiters = append(c.waiters, t)
+	c.cond.Broadcast()
+}
+
+// remove unregisters t and reports whether it was waiting. The caller must hold c.mu.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// nextDue returns the waiting timer with the earliest deadline no later than end.
+// The caller must hold c.mu.
+func (c *FakeClock) nextDue(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.waiters {
+		if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// fakeTimer is a timer, or a ticker if period is set, of a FakeClock.
+type fakeTimer struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	when   time.Time     // next deadline, guarded by clock.mu
+	period time.Duration // zero for a timer
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+// fire delivers now without blocking. Like a time.Ticker, a ticker whose previous
+// tick has not been received drops the new one.
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+// Stop stops the timer or ticker. It reports whether a timer was still waiting to fire.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+// Reset rearms the timer to fire after d. It reports whether it was still waiting to fire.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// fakeTicker adapts a periodic fakeTimer to gothrottle.Ticker.
+type fakeTicker struct{ *fakeTimer }
+
+// Stop turns off the ticker.
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
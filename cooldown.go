@@ -0,0 +1,45 @@
+// FILENAME: cooldown.go
+package gothrottle
+
+import "time"
+
+// SetCooldown stops the limiter from starting jobs until d has elapsed, e.g. to honor
+// a Retry-After header after an upstream API answered 429 Too Many Requests. Queued
+// jobs stay queued and new ones are queued behind them; jobs already running are not
+// affected. It replaces any cooldown in effect, so a zero or negative d lifts it.
+// TryAcquire and CheckAvailable report no slot while the cooldown lasts.
+//
+// If the datastore implements CooldownSetter, as LocalStore and RedisStore do, the
+// cooldown is also recorded there, so that every limiter sharing the ID pauses, not
+// just this one. Jobs of ScheduleKeyed keys are only paused in this process. A
+// datastore error is reported like other datastore errors and leaves the cooldown
+// in effect locally.
+func (l *Limiter) SetCooldown(d time.Duration) {
+	opts := l.options()
+	var until time.Time
+	if d > 0 {
+		until = opts.Clock.Now().Add(d)
+		l.cooldownUntil.Store(until.UnixNano())
+	} else {
+		l.cooldownUntil.Store(0)
+	}
+
+	if setter, ok := l.datastore.(CooldownSetter); ok {
+		if err := setter.SetCooldown(opts.ID, until); err != nil {
+			l.storeError("set cooldown", err)
+		}
+	}
+	l.notify()
+}
+
+// cooldown returns how long the cooldown set by SetCooldown lasts, or zero.
+func (l *Limiter) cooldown() time.Duration {
+	until := l.cooldownUntil.Load()
+	if until == 0 {
+		return 0
+	}
+	if remaining := time.Unix(0, until).Sub(l.options().Clock.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
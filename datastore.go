@@ -1,12 +1,18 @@
 // FILENAME: datastore.go
 package gothrottle
 
-import "time"
+import (
+	"context"
+	"math/rand"
+	"time"
+)
 
 // Datastore defines the interface for state management.
 type Datastore interface {
 	// Request checks if a job can run according to the limiter's rules.
 	// It must return whether the job can run now, and if not, a suggested wait time.
+	// MinTime is measured from the last granted start and only a grant moves it, so
+	// a denied request must leave that start untouched and return the exact time left.
 	Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error)
 
 	// RegisterDone informs the store that a job has finished.
@@ -15,3 +21,132 @@ type Datastore interface {
 	// Disconnect cleans up any connections.
 	Disconnect() error
 }
+
+// HealthChecker is implemented by datastores that can check whether their backend is
+// reachable. Limiter.HealthCheck and Limiter.Healthy use it when the limiter's datastore implements it.
+type HealthChecker interface {
+	// Ping returns an error if the datastore cannot currently serve requests.
+	Ping(ctx context.Context) error
+}
+
+// Peeker is implemented by datastores that can evaluate a request without acquiring
+// a slot. Limiter.CheckAvailable requires the limiter's datastore to implement it.
+type Peeker interface {
+	// Peek reports what Request would return for the same arguments, without
+	// incrementing the running count or recording a start.
+	Peek(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error)
+}
+
+// peekDenied reports whether store, if it implements Peeker, would deny a job, and
+// the wait it suggests. A store that cannot peek, or fails to, is assumed to grant
+// the job, leaving the decision and any error to Request.
+func peekDenied(store Datastore, limiterID string, weight int, opts Options) (denied bool, waitTime time.Duration) {
+	peeker, ok := store.(Peeker)
+	if !ok {
+		return false, 0
+	}
+	canRun, waitTime, err := peeker.Peek(limiterID, weight, opts)
+	if err != nil || canRun {
+		return false, 0
+	}
+	return true, waitTime
+}
+
+// BatchRegisterer is implemented by datastores that can release the weight of several
+// finished jobs of a limiter in one call. The Limiter uses it when
+// Options.DoneFlushInterval is set.
+type BatchRegisterer interface {
+	// BatchRegisterDone informs the store that jobs with a combined weight of
+	// totalWeight have finished.
+	BatchRegisterDone(limiterID string, totalWeight int) error
+}
+
+// PartialRegisterer is implemented by datastores that can release part of a running
+// job's weight. JobHandle.Release requires the limiter's datastore to implement it:
+// a datastore that tracks each job's slot, like etcdstore, cannot take a partial
+// release through RegisterDone without losing track of the rest of the weight.
+type PartialRegisterer interface {
+	// RegisterDonePartial releases weight of a job that keeps running with the rest,
+	// clamping the running count at zero like RegisterDone.
+	RegisterDonePartial(limiterID string, weight int) error
+}
+
+// partialChecker is implemented by datastores wrapping other datastores, which
+// only support partial releases if the wrapped ones do.
+type partialChecker interface {
+	supportsPartial() bool
+}
+
+// supportsPartial reports whether store can release part of a running job's weight.
+func supportsPartial(store Datastore) bool {
+	if checker, ok := store.(partialChecker); ok {
+		return checker.supportsPartial()
+	}
+	_, ok := store.(PartialRegisterer)
+	return ok
+}
+
+// registerDonePartial releases part of a running job's weight from store, or returns
+// ErrPartialReleaseUnsupported without releasing anything.
+func registerDonePartial(store Datastore, limiterID string, weight int) error {
+	if !supportsPartial(store) {
+		return ErrPartialReleaseUnsupported
+	}
+	return store.(PartialRegisterer).RegisterDonePartial(limiterID, weight)
+}
+
+// UsageReporter is implemented by datastores that can report how much weight a
+// limiter has running. Limiter.Stats uses it to report StoreRunning, which counts
+// the jobs of every instance sharing the limiter ID.
+type UsageReporter interface {
+	// CurrentUsage returns the weight the store counts as running for the limiter,
+	// or zero if it has no state for it.
+	CurrentUsage(limiterID string) (running int, err error)
+}
+
+// Resetter is implemented by datastores that can discard the state of a limiter, e.g.
+// to recover from running weight leaked by a crashed instance. Limiter.Reset requires
+// the limiter's datastore to implement it.
+type Resetter interface {
+	// Reset deletes the limiter's state, so that it starts over with no running jobs,
+	// no recorded starts and no cooldown.
+	Reset(limiterID string) error
+}
+
+// CooldownSetter is implemented by datastores that can deny every job of a limiter
+// until a point in time. Limiter.SetCooldown uses it when the limiter's datastore
+// implements it, so that a cooldown applies to every instance sharing the limiter ID.
+type CooldownSetter interface {
+	// SetCooldown makes Request and Peek deny every job of the limiter until until,
+	// suggesting the time left as the wait. A zero until lifts the cooldown.
+	SetCooldown(limiterID string, until time.Time) error
+}
+
+// StateSnapshot is a copy of a limiter's datastore state, as returned by
+// LocalStore.Snapshot and RedisStore.Snapshot for debugging and dashboards.
+type StateSnapshot struct {
+	// Running is the weight of the jobs running for the limiter ID.
+	Running int
+	// LastStart is when the last job was granted, or the zero time if none was.
+	LastStart time.Time
+}
+
+// jitter returns a random duration in [0, max), or zero if max is not positive.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max))) // #nosec G404 - jitter does not need a cryptographic source
+}
+
+// spread moves d by a random amount in [-max, max], never below min.
+func spread(d, max, min time.Duration) time.Duration {
+	if max <= 0 {
+		return d
+	}
+	d += time.Duration(rand.Int63n(2*int64(max)+1)) - max // #nosec G404 - jitter does not need a cryptographic source
+	if d < min {
+		return min
+	}
+	return d
+}
@@ -10,6 +10,71 @@ var (
 	// ErrMissingID is returned when a limiter ID is required but not provided.
 	ErrMissingID = errors.New("limiter ID is required")
 
+	// ErrInvalidOptions is returned by Options.Validate, NewLimiter and UpdateOptions for
+	// out-of-range or conflicting options.
+	ErrInvalidOptions = errors.New("invalid options")
+
 	// ErrInvalidWeight is returned when a job weight is invalid.
 	ErrInvalidWeight = errors.New("job weight must be positive")
+
+	// ErrInvalidPriority is returned when a job priority is outside MinPriority and MaxPriority.
+	ErrInvalidPriority = errors.New("job priority out of range")
+
+	// ErrWeightExceedsLimit is returned when a job weight exceeds MaxConcurrent and could never run.
+	ErrWeightExceedsLimit = errors.New("job weight exceeds max concurrent limit")
+
+	// ErrGroupDatastore is returned when a child limiter of a Group is given its own datastore.
+	ErrGroupDatastore = errors.New("group limiters share the group's datastore")
+
+	// ErrQueueFull is returned when the queue is at HighWater and the strategy is StrategyReject.
+	ErrQueueFull = errors.New("queue is full")
+
+	// ErrDropped is returned to a queued job evicted to make room under StrategyDropOldest.
+	ErrDropped = errors.New("job dropped from full queue")
+
+	// ErrQueueTimeout is returned to a job that was queued longer than Options.MaxQueueTime.
+	ErrQueueTimeout = errors.New("job exceeded max queue time")
+
+	// ErrCircuitOpen is returned for a job turned away while Options.CircuitBreaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrTaskPanicked is returned for a job whose task panicked. The error also carries the
+	// recovered value and the stack trace.
+	ErrTaskPanicked = errors.New("task panicked")
+
+	// ErrJobNotRunning is returned by JobHandle.Release for a job that has not started or has finished.
+	ErrJobNotRunning = errors.New("job is not running")
+
+	// ErrCanceled is returned by JobHandle.Wait after the job was cancelled with JobHandle.Cancel.
+	ErrCanceled = errors.New("job canceled")
+
+	// ErrInvalidTier is returned when a tier in Options.Tiers has no name, a duplicate name or a share below 1.
+	ErrInvalidTier = errors.New("tier needs a unique name and a positive share")
+
+	// ErrUnknownTier is returned when a job is submitted to a tier missing from Options.Tiers.
+	ErrUnknownTier = errors.New("unknown tier")
+
+	// ErrAccountingMismatch is returned by RegisterDone with Options.StrictAccounting when more
+	// weight is released than is running, e.g. after a double RegisterDone or a weight mismatch.
+	ErrAccountingMismatch = errors.New("released weight exceeds running weight")
+
+	// ErrPeekUnsupported is returned by Limiter.CheckAvailable when the datastore does not implement Peeker.
+	ErrPeekUnsupported = errors.New("datastore does not support peeking")
+
+	// ErrPartialReleaseUnsupported is returned by JobHandle.Release when the datastore does not
+	// implement PartialRegisterer.
+	ErrPartialReleaseUnsupported = errors.New("datastore does not support partial releases")
+
+	// ErrUsageUnsupported is returned by ChainedStore.CurrentUsage when one of its stores does
+	// not implement UsageReporter.
+	ErrUsageUnsupported = errors.New("datastore does not support usage reporting")
+
+	// ErrResetUnsupported is returned by Limiter.Reset when the datastore does not implement Resetter.
+	ErrResetUnsupported = errors.New("datastore does not support resetting")
+
+	// ErrAtCapacity is returned by Limiter.NextAvailable when a job has to wait for running jobs to finish.
+	ErrAtCapacity = errors.New("limiter is at capacity")
+
+	// ErrImmutableOption is returned when attempting to change the limiter ID, datastore or clock at runtime.
+	ErrImmutableOption = errors.New("limiter ID, datastore and clock cannot be changed")
 )
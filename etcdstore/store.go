@@ -0,0 +1,279 @@
+// FILENAME: store.go
+
+// Package etcdstore provides an etcd-backed gothrottle Datastore whose slots are
+// attached to leases, so the weight held by a crashed instance is reclaimed
+// automatically when its leases expire.
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DefaultLeaseTTL is the lease TTL used when New is given a non-positive TTL.
+const DefaultLeaseTTL = 10 * time.Second
+
+// maxTxnAttempts bounds how often Request retries after losing a race with
+// another instance updating the same limiter.
+const maxTxnAttempts = 5
+
+// slot is a granted slot held by this store.
+type slot struct {
+	leaseID clientv3.LeaseID
+	weight  int
+	cancel  context.CancelFunc // stops the lease keep-alive
+}
+
+// Store is an etcd-based implementation of gothrottle.Datastore. Every granted slot
+// is a key under "gothrottle/<limiterID>/slots/" attached to its own lease, kept
+// alive while the job runs and revoked by RegisterDone. WindowLimit is not supported
+// and is ignored. Store does not implement gothrottle.PartialRegisterer, because a
+// slot cannot be matched to its job, so JobHandle.Release is not supported.
+type Store struct {
+	client   *clientv3.Client
+	leaseTTL int64 // seconds
+
+	mu     sync.Mutex // guards slots and closed
+	slots  map[string][]slot
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New creates a Store using client. leaseTTL is how long a crashed instance's slots
+// survive before they are reclaimed; it is rounded up to whole seconds and defaults
+// to DefaultLeaseTTL if not positive. The client is owned by the caller and is not
+// closed by Disconnect.
+func New(client *clientv3.Client, leaseTTL time.Duration) (*Store, error) {
+	if client == nil {
+		return nil, fmt.Errorf("etcd store requires a client")
+	}
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultLeaseTTL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Store{
+		client:   client,
+		leaseTTL: int64((leaseTTL + time.Second - 1) / time.Second),
+		slots:    make(map[string][]slot),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// slotPrefix returns the key prefix of the slots of a limiter.
+func slotPrefix(limiterID string) string {
+	return "gothrottle/" + limiterID + "/slots/"
+}
+
+// lastStartKey returns the key holding the last start time of a limiter.
+func lastStartKey(limiterID string) string {
+	return "gothrottle/" + limiterID + "/last_start"
+}
+
+// isClosed reports whether Disconnect has been called.
+func (s *Store) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Request checks if a job can run according to the limiter's rules.
+func (s *Store) Request(limiterID string, weight int, opts gothrottle.Options) (canRun bool, waitTime time.Duration, err error) {
+	if s.isClosed() {
+		return false, 0, gothrottle.ErrStoreClosed
+	}
+
+	// A job heavier than the limit would be denied forever
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, gothrottle.ErrWeightExceedsLimit
+	}
+
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		canRun, waitTime, retry, err := s.tryRequest(limiterID, weight, opts)
+		if !retry {
+			return canRun, waitTime, err
+		}
+	}
+
+	// Lost every race; let the scheduler try again shortly
+	return false, 0, nil
+}
+
+// tryRequest makes one attempt to acquire a slot. It returns retry=true if another
+// instance changed the limiter's state between the read and the transaction.
+func (s *Store) tryRequest(limiterID string, weight int, opts gothrottle.Options) (canRun bool, waitTime time.Duration, retry bool, err error) {
+	prefix := "gothrottle/" + limiterID + "/"
+	resp, err := s.client.Get(s.ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return false, 0, false, fmt.Errorf("etcd get error: %w", err)
+	}
+
+	running := 0
+	var lastStartMs int64
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		switch {
+		case key == lastStartKey(limiterID):
+			lastStartMs, _ = strconv.ParseInt(string(kv.Value), 10, 64)
+		case strings.HasPrefix(key, slotPrefix(limiterID)):
+			w, _ := strconv.Atoi(string(kv.Value))
+			running += w
+		}
+	}
+
+	// Check max concurrent limit
+	if opts.MaxConcurrent > 0 && running+weight > opts.MaxConcurrent {
+		return false, 0, false, nil
+	}
+
+	// Check min time between jobs
+	nowMs := time.Now().UnixMilli()
+	if elapsed := time.Duration(nowMs-lastStartMs) * time.Millisecond; opts.MinTime > 0 && elapsed < opts.MinTime {
+		return false, opts.MinTime - elapsed + jitter(opts.Jitter), false, nil
+	}
+
+	lease, err := s.client.Grant(s.ctx, s.leaseTTL)
+	if err != nil {
+		return false, 0, false, fmt.Errorf("etcd lease grant error: %w", err)
+	}
+
+	// Only commit if no key of this limiter changed since it was read. Slots
+	// expiring in the meantime only free capacity, so deletions are harmless.
+	slotKey := fmt.Sprintf("%s%x", slotPrefix(limiterID), lease.ID)
+	txn, err := s.client.Txn(s.ctx).
+		If(clientv3.Compare(clientv3.ModRevision(prefix), "<", resp.Header.Revision+1).WithPrefix()).
+		Then(
+			clientv3.OpPut(slotKey, strconv.Itoa(weight), clientv3.WithLease(lease.ID)),
+			clientv3.OpPut(lastStartKey(limiterID), strconv.FormatInt(nowMs, 10)),
+		).
+		Commit()
+	if err != nil || !txn.Succeeded {
+		_, _ = s.client.Revoke(s.ctx, lease.ID) // Best effort, the lease expires anyway
+		if err != nil {
+			return false, 0, false, fmt.Errorf("etcd txn error: %w", err)
+		}
+		return false, 0, true, nil
+	}
+
+	// Keep the slot alive for as long as the job runs
+	keepAliveCtx, cancel := context.WithCancel(s.ctx)
+	keepAlive, err := s.client.KeepAlive(keepAliveCtx, lease.ID)
+	if err != nil {
+		cancel()
+		_, _ = s.client.Revoke(s.ctx, lease.ID)
+		return false, 0, false, fmt.Errorf("etcd lease keep-alive error: %w", err)
+	}
+	go func() {
+		for range keepAlive { // Drain responses until the keep-alive stops
+		}
+	}()
+
+	s.mu.Lock()
+	s.slots[limiterID] = append(s.slots[limiterID], slot{leaseID: lease.ID, weight: weight, cancel: cancel})
+	s.mu.Unlock()
+
+	return true, 0, false, nil
+}
+
+// RegisterDone informs the store that a job has finished, releasing one of the
+// limiter's slots of the given weight held by this store.
+func (s *Store) RegisterDone(limiterID string, weight int) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return gothrottle.ErrStoreClosed
+	}
+
+	var released *slot
+	held := s.slots[limiterID]
+	for i := range held {
+		if held[i].weight == weight {
+			sl := held[i]
+			released = &sl
+			s.slots[limiterID] = append(held[:i], held[i+1:]...)
+			break
+		}
+	}
+	if len(s.slots[limiterID]) == 0 {
+		delete(s.slots, limiterID)
+	}
+	s.mu.Unlock()
+
+	if released == nil {
+		return nil // Nothing to do
+	}
+
+	released.cancel()
+	if _, err := s.client.Revoke(s.ctx, released.leaseID); err != nil {
+		return fmt.Errorf("etcd lease revoke error: %w", err)
+	}
+	return nil
+}
+
+// CurrentUsage returns the combined weight of the limiter's slots held by every
+// instance.
+func (s *Store) CurrentUsage(limiterID string) (int, error) {
+	if s.isClosed() {
+		return 0, gothrottle.ErrStoreClosed
+	}
+
+	resp, err := s.client.Get(s.ctx, slotPrefix(limiterID), clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("etcd get error: %w", err)
+	}
+	running := 0
+	for _, kv := range resp.Kvs {
+		w, _ := strconv.Atoi(string(kv.Value))
+		running += w
+	}
+	return running, nil
+}
+
+// Disconnect stops keeping the store's slots alive and revokes their leases.
+// The client is owned by the caller and is not closed.
+func (s *Store) Disconnect() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	slots := s.slots
+	s.slots = nil
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var firstErr error
+	for _, held := range slots {
+		for _, sl := range held {
+			sl.cancel()
+			if _, err := s.client.Revoke(ctx, sl.leaseID); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("etcd lease revoke error: %w", err)
+			}
+		}
+	}
+	s.cancel()
+
+	return firstErr
+}
+
+// jitter returns a random duration in [0, max), or zero if max is not positive.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max))) // #nosec G404 - jitter does not need a cryptographic source
+}
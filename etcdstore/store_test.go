@@ -0,0 +1,141 @@
+// FILENAME: store_test.go
+package etcdstore
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// freeURL returns a local URL with a port that is currently free.
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+// newTestClient starts an embedded etcd server and returns a client connected to it.
+func newTestClient(t *testing.T) *clientv3.Client {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server did not start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{server.Clients[0].Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestStore_Basic(t *testing.T) {
+	client := newTestClient(t)
+	store, err := New(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Disconnect() }()
+
+	opts := gothrottle.Options{MaxConcurrent: 2}
+	for i := 0; i < 2; i++ {
+		canRun, _, err := store.Request("api", 1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !canRun {
+			t.Fatalf("Request %d should be allowed", i+1)
+		}
+	}
+
+	canRun, _, err := store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request over MaxConcurrent should be denied")
+	}
+
+	if err := store.RegisterDone("api", 1); err != nil {
+		t.Fatal(err)
+	}
+	canRun, _, err = store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("Request after RegisterDone should be allowed")
+	}
+}
+
+func TestStore_ReclaimsCrashedSlots(t *testing.T) {
+	client := newTestClient(t)
+	opts := gothrottle.Options{MaxConcurrent: 1}
+
+	crashed, err := New(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun, _, err := crashed.Request("api", 1, opts); err != nil || !canRun {
+		t.Fatalf("First request should be allowed, got canRun=%v err=%v", canRun, err)
+	}
+	// Simulate a crash: stop keeping the lease alive without revoking it
+	crashed.cancel()
+
+	survivor, err := New(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = survivor.Disconnect() }()
+
+	if canRun, _, _ := survivor.Request("api", 1, opts); canRun {
+		t.Fatal("Slot of the crashed instance should still be held")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		canRun, _, err := survivor.Request("api", 1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canRun {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Slot of the crashed instance was not reclaimed after its lease expired")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
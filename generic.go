@@ -0,0 +1,69 @@
+// FILENAME: generic.go
+package gothrottle
+
+import "sync"
+
+// Schedule submits a typed job to l with default priority (5) and weight (1) and
+// blocks until completion. It returns the zero value of T on error, so callers
+// never need to type-assert an interface{} result.
+func Schedule[T any](l *Limiter, task func() (T, error)) (T, error) {
+	result, err := l.Schedule(func() (interface{}, error) {
+		return task()
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	// A nil result boxes to a nil interface{}, which asserts to the zero value
+	typed, _ := result.(T)
+	return typed, nil
+}
+
+// WrapTyped returns a function that runs fn through l via Schedule, preserving its
+// typed result.
+func WrapTyped[R any](l *Limiter, fn func() (R, error)) func() (R, error) {
+	return func() (R, error) {
+		return Schedule(l, fn)
+	}
+}
+
+// WrapFunc1 returns a function with the same signature as fn that runs each call
+// through l via Schedule, e.g. to throttle a lookup by ID.
+func WrapFunc1[A, R any](l *Limiter, fn func(A) (R, error)) func(A) (R, error) {
+	return func(arg A) (R, error) {
+		return Schedule(l, func() (R, error) {
+			return fn(arg)
+		})
+	}
+}
+
+// Process runs worker on every item received from in, each as a job of l with default
+// priority (5) and weight (1), and returns a channel carrying the error of every item
+// that failed, including jobs the limiter turned away. The channel is closed once in
+// is closed and every item has finished, so it must be drained to completion. Items
+// are submitted as soon as they arrive; set Options.HighWater with StrategyBlock for
+// backpressure on the sender of in.
+func Process[T any](l *Limiter, in <-chan T, worker func(T) error) <-chan error {
+	errs := make(chan error)
+	go func() {
+		var wg sync.WaitGroup
+		for item := range in {
+			item := item
+			handle := l.Submit(func() (interface{}, error) {
+				return nil, worker(item)
+			})
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := handle.Wait(); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+	}()
+	return errs
+}
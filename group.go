@@ -0,0 +1,154 @@
+// FILENAME: group.go
+package gothrottle
+
+import (
+	"context"
+	"time"
+)
+
+// Group enforces an aggregate limit across several child limiters sharing one datastore.
+//
+// Each child job must be granted by both the child's own limits and the group's limits.
+// State is kept under two keys in the group's datastore:
+//
+//	<group ID>              aggregate state checked against the group's Options
+//	<group ID>/<child ID>   per-child state checked against the child's Options
+//
+// With RedisStore these become "gothrottle:{<group ID>}" and "gothrottle:{<group ID>/<child ID>}".
+type Group struct {
+	opts      Options
+	datastore Datastore
+}
+
+// NewGroup creates a new Group. The group ID is required because it prefixes every child key.
+// MaxConcurrent and MinTime in opts apply to the aggregate of all child limiters.
+func NewGroup(opts Options) (*Group, error) {
+	if opts.ID == "" {
+		return nil, ErrMissingID
+	}
+
+	// Default to LocalStore if no datastore is provided
+	datastore := opts.Datastore
+	if datastore == nil {
+		datastore = NewLocalStore()
+	}
+
+	return &Group{
+		opts:      opts,
+		datastore: datastore,
+	}, nil
+}
+
+// NewLimiter creates a child limiter that is subject to both opts and the group's limits.
+// The child uses the group's datastore, so opts.Datastore must be nil.
+func (g *Group) NewLimiter(opts Options) (*Limiter, error) {
+	if opts.ID == "" {
+		return nil, ErrMissingID
+	}
+	if opts.Datastore != nil {
+		return nil, ErrGroupDatastore
+	}
+
+	opts.Datastore = &groupStore{group: g}
+	return NewLimiter(opts)
+}
+
+// Close disconnects the group's datastore. Child limiters must be stopped first.
+func (g *Group) Close() error {
+	return g.datastore.Disconnect()
+}
+
+// childKey returns the datastore key of a child limiter.
+func (g *Group) childKey(limiterID string) string {
+	return g.opts.ID + "/" + limiterID
+}
+
+// groupStore is the Datastore used by a Group's child limiters.
+type groupStore struct {
+	group *Group
+}
+
+// Request grants a job only if both the child and the group have capacity.
+// Releasing a child grant does not roll back the child's last start, window or
+// GCRA state, so if the shared datastore implements Peeker the group is peeked
+// first and a saturated group denies the job without touching the child. If the
+// group still denies the job after the child granted it, e.g. because another
+// instance took the last slot in between, the child grant is released.
+func (gs *groupStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	g := gs.group
+	key := g.childKey(limiterID)
+
+	if denied, waitTime := peekDenied(g.datastore, g.opts.ID, weight, g.opts); denied {
+		return false, waitTime, nil
+	}
+
+	canRun, waitTime, err = g.datastore.Request(key, weight, opts)
+	if err != nil || !canRun {
+		return canRun, waitTime, err
+	}
+
+	canRun, waitTime, err = g.datastore.Request(g.opts.ID, weight, g.opts)
+	if err != nil || !canRun {
+		if releaseErr := g.datastore.RegisterDone(key, weight); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		return false, waitTime, err
+	}
+
+	return true, 0, nil
+}
+
+// RegisterDone releases the job from both the group and the child.
+func (gs *groupStore) RegisterDone(limiterID string, weight int) error {
+	g := gs.group
+
+	groupErr := g.datastore.RegisterDone(g.opts.ID, weight)
+	childErr := g.datastore.RegisterDone(g.childKey(limiterID), weight)
+	if groupErr != nil {
+		return groupErr
+	}
+	return childErr
+}
+
+// RegisterDonePartial releases part of a running job's weight from both the group and the child.
+func (gs *groupStore) RegisterDonePartial(limiterID string, weight int) error {
+	g := gs.group
+	if !supportsPartial(g.datastore) {
+		return ErrPartialReleaseUnsupported
+	}
+
+	groupErr := registerDonePartial(g.datastore, g.opts.ID, weight)
+	childErr := registerDonePartial(g.datastore, g.childKey(limiterID), weight)
+	if groupErr != nil {
+		return groupErr
+	}
+	return childErr
+}
+
+// CurrentUsage reports the child's running weight if the shared datastore implements
+// UsageReporter.
+func (gs *groupStore) CurrentUsage(limiterID string) (int, error) {
+	reporter, ok := gs.group.datastore.(UsageReporter)
+	if !ok {
+		return 0, ErrUsageUnsupported
+	}
+	return reporter.CurrentUsage(gs.group.childKey(limiterID))
+}
+
+// Ping pings the shared datastore if it implements HealthChecker.
+func (gs *groupStore) Ping(ctx context.Context) error {
+	if checker, ok := gs.group.datastore.(HealthChecker); ok {
+		return checker.Ping(ctx)
+	}
+	return nil
+}
+
+// supportsPartial reports whether the shared datastore supports partial releases.
+func (gs *groupStore) supportsPartial() bool {
+	return supportsPartial(gs.group.datastore)
+}
+
+// Disconnect is a no-op; the shared datastore is closed by Group.Close.
+func (gs *groupStore) Disconnect() error {
+	return nil
+}
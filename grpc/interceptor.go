@@ -0,0 +1,75 @@
+// FILENAME: interceptor.go
+
+// Package grpc provides gRPC interceptors that run calls through a gothrottle
+// limiter. It is a separate module, so that only users of these interceptors
+// depend on google.golang.org/grpc.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/AFZidan/gothrottle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns a server interceptor that runs each unary handler
+// as a job of the limiter with default priority (5) and weight (1), bound to the
+// incoming context. Calls the limiter turns away fail with codes.ResourceExhausted:
+// those rejected at HighWater with StrategyReject or StrategyDropOldest, queued
+// longer than MaxQueueTime, or whose deadline passes while they are queued. A call
+// cancelled by the client while queued fails with codes.Canceled, and any other
+// limiter error, e.g. after Stop, with codes.Unavailable. Errors returned by the
+// handler are passed through unchanged.
+func UnaryServerInterceptor(l *gothrottle.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return schedule(ctx, l, func() (interface{}, error) {
+			return handler(ctx, req)
+		})
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor that sends each unary call as
+// a job of the limiter with default priority (5) and weight (1), so that outbound
+// calls are rate limited. Calls the limiter turns away fail with the same codes as
+// with UnaryServerInterceptor; errors from the call itself are passed through unchanged.
+func UnaryClientInterceptor(l *gothrottle.Limiter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		_, err := schedule(ctx, l, func() (interface{}, error) {
+			return nil, invoker(ctx, method, req, reply, cc, opts...)
+		})
+		return err
+	}
+}
+
+// schedule runs call through the limiter and converts errors of the limiter, as
+// opposed to errors returned by call, to gRPC status errors. Once call has started it
+// is waited for even if ctx is done, so that the interceptor never returns while the
+// call still holds its slot.
+func schedule(ctx context.Context, l *gothrottle.Limiter, call func() (interface{}, error)) (interface{}, error) {
+	var started atomic.Bool
+	result, err := l.ScheduleUntilDone(ctx, func() (interface{}, error) {
+		started.Store(true)
+		return call()
+	}, 5, 1)
+	if err == nil || started.Load() {
+		return result, err
+	}
+	return nil, limiterStatus(err)
+}
+
+// limiterStatus converts an error of the limiter to a gRPC status error.
+func limiterStatus(err error) error {
+	code := codes.Unavailable
+	switch {
+	case errors.Is(err, gothrottle.ErrQueueFull), errors.Is(err, gothrottle.ErrDropped),
+		errors.Is(err, gothrottle.ErrQueueTimeout), errors.Is(err, context.DeadlineExceeded):
+		code = codes.ResourceExhausted
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,164 @@
+// FILENAME: interceptor_test.go
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	throttlegrpc "github.com/AFZidan/gothrottle/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		HighWater:     1,
+		Strategy:      gothrottle.StrategyReject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	interceptor := throttlegrpc.UnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	release := make(chan struct{})
+	blocking := func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-release
+		return req, nil
+	}
+	call := func(ctx context.Context, handler grpc.UnaryHandler) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := interceptor(ctx, "req", info, handler)
+			done <- err
+		}()
+		return done
+	}
+
+	running := call(context.Background(), blocking)
+	waitFor(t, func() bool { return limiter.Stats().Running == 1 })
+
+	// A call whose deadline passes while it is queued is turned away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := <-call(ctx, blocking); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted after the deadline, got %v", err)
+	}
+
+	// A call cancelled by the client while queued reports it
+	ctx, cancel = context.WithCancel(context.Background())
+	abandoned := call(ctx, blocking)
+	waitFor(t, func() bool { return limiter.Stats().Queued == 1 })
+	cancel()
+	if err := <-abandoned; status.Code(err) != codes.Canceled {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+
+	// With the queue at HighWater, the next call is rejected
+	queued := call(context.Background(), blocking)
+	waitFor(t, func() bool { return limiter.Stats().Queued == 1 })
+	if err := <-call(context.Background(), blocking); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted with a full queue, got %v", err)
+	}
+
+	close(release)
+	for _, done := range []<-chan error{running, queued} {
+		if err := <-done; err != nil {
+			t.Errorf("Expected call to succeed, got %v", err)
+		}
+	}
+
+	// Handler errors are passed through unchanged
+	handlerErr := errors.New("handler failed")
+	failing := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, handlerErr }
+	if err := <-call(context.Background(), failing); err != handlerErr {
+		t.Errorf("Expected the handler's error, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptor_WaitsForStartedHandler(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	interceptor := throttlegrpc.UnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return req, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := interceptor(ctx, "req", info, handler)
+		done <- err
+	}()
+	<-started
+
+	// The interceptor keeps waiting for the handler, which still holds its slot
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the interceptor to wait for the running handler, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the handler's result, got %v", err)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MinTime: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := throttlegrpc.UnaryClientInterceptor(limiter)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := interceptor(context.Background(), "/test.Service/Method", "req", nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected calls to be spaced by MinTime, took %v", elapsed)
+	}
+
+	// Calls fail with Unavailable once the limiter is stopped
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := interceptor(context.Background(), "/test.Service/Method", "req", nil, nil, invoker); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable after Stop, got %v", err)
+	}
+}
+
+// waitFor waits up to a second for cond to hold.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,160 @@
+// FILENAME: handle.go
+package gothrottle
+
+import (
+	"context"
+	"sync"
+)
+
+// JobHandle is a reference to a job submitted without blocking.
+type JobHandle struct {
+	job     *Job
+	limiter *Limiter
+	once    sync.Once
+	result  interface{}
+	err     error
+}
+
+// Future is an alias of JobHandle returned by ScheduleAsync.
+type Future = JobHandle
+
+// ScheduleAsync enqueues a job with default priority (5) and weight (1) and returns
+// a Future for collecting its result later, or cancelling the job while it is still
+// queued. It is equivalent to Submit.
+func (l *Limiter) ScheduleAsync(task func() (interface{}, error)) *Future {
+	return l.Submit(task)
+}
+
+// Submit enqueues a job with default priority (5) and weight (1) and returns immediately.
+func (l *Limiter) Submit(task func() (interface{}, error)) *JobHandle {
+	return l.SubmitWithOptions(task, 5, 1)
+}
+
+// SubmitWithOptions enqueues a job with custom priority and weight and returns immediately.
+// Submission errors, such as a stopped limiter, are reported by Wait.
+func (l *Limiter) SubmitWithOptions(task func() (interface{}, error), priority, weight int) *JobHandle {
+	job := newJob(context.Background(), task, priority, weight)
+	if err := l.enqueue(weight, job); err != nil {
+		job.complete(nil, err)
+	}
+	return &JobHandle{job: job, limiter: l}
+}
+
+// Go enqueues a job with default priority (5) and weight (1) and returns immediately,
+// for work such as background telemetry whose result nobody waits for. The result is
+// discarded; an error, including a failure to enqueue, is logged as a warning and
+// passed to Options.OnGoError. Under StrategyBlock, Go still waits for room in a
+// queue at HighWater.
+func (l *Limiter) Go(task func() (interface{}, error)) {
+	job := newJob(context.Background(), task, 5, 1)
+	job.onError = l.goError
+	if err := l.enqueue(1, job); err != nil {
+		job.complete(nil, err)
+	}
+}
+
+// ScheduleCallback enqueues a job with default priority (5) and weight (1) and returns
+// immediately, calling cb with the job's result or error once it is done, without
+// keeping a goroutine per job. cb also runs for jobs that never start, e.g. with
+// ErrStoreClosed, on the caller's goroutine if the job could not be enqueued and
+// otherwise on the scheduler's or the job's goroutine, so it must not block. Under
+// StrategyBlock, ScheduleCallback still waits for room in a queue at HighWater.
+func (l *Limiter) ScheduleCallback(task func() (interface{}, error), cb func(result interface{}, err error)) {
+	job := newJob(context.Background(), task, 5, 1)
+	job.onResult = cb
+	if err := l.enqueue(1, job); err != nil {
+		job.complete(nil, err)
+	}
+}
+
+// goError reports the error of a job started with Go.
+func (l *Limiter) goError(err error) {
+	opts := l.options()
+	opts.Logger.Warnf("gothrottle: limiter %q fire-and-forget job failed: %v", opts.ID, err)
+	if opts.OnGoError != nil {
+		opts.OnGoError(err)
+	}
+}
+
+// Done returns a channel that is closed when the job has finished.
+func (h *JobHandle) Done() <-chan struct{} {
+	return h.job.done
+}
+
+// Cancel removes the job from the queue if it has not started yet, freeing its
+// place for other jobs, and returns true. Wait then returns ErrCanceled.
+// It returns false if the job has already started or finished.
+func (h *JobHandle) Cancel() bool {
+	return h.limiter.cancel(h.job, ErrCanceled)
+}
+
+// Release gives back weight of the job while it keeps running, e.g. after a heavy job
+// was cut short or finished some of its sub-tasks, so that queued jobs can use it.
+// When the job finishes only the weight it still holds is released. Release returns
+// ErrJobNotRunning if the job has not started or has finished,
+// ErrAccountingMismatch if weight exceeds the weight the job still holds, and
+// ErrPartialReleaseUnsupported if the datastore does not implement PartialRegisterer
+// or Options.StaleTimeout is set.
+func (h *JobHandle) Release(weight int) error {
+	if weight <= 0 {
+		return ErrInvalidWeight
+	}
+	if !supportsPartial(h.limiter.datastore) || h.limiter.options().StaleTimeout > 0 {
+		return ErrPartialReleaseUnsupported
+	}
+	if err := h.job.release(weight); err != nil {
+		return err
+	}
+	return h.limiter.releasePartial(h.job, weight)
+}
+
+// Wait blocks until the job has finished and returns its result.
+// It may be called multiple times and from multiple goroutines.
+func (h *JobHandle) Wait() (interface{}, error) {
+	h.once.Do(func() {
+		select {
+		case h.result = <-h.job.resultChan:
+		case h.err = <-h.job.errorChan:
+		}
+	})
+	return h.result, h.err
+}
+
+// ScheduleDedup coalesces concurrent calls with the same key, singleflight style, so
+// that task runs once and every caller gets its result or error. It is equivalent to
+// ScheduleWithKey and shares its keys.
+func (l *Limiter) ScheduleDedup(key string, task func() (interface{}, error)) (interface{}, error) {
+	return l.ScheduleWithKey(key, task)
+}
+
+// ScheduleWithKey schedules a job with default priority (5) and weight (1) unless a job
+// with the same key is already queued or running, in which case it waits for that job
+// and returns its result instead of running task. This suits cache warming and other
+// work that concurrent callers would otherwise duplicate.
+func (l *Limiter) ScheduleWithKey(key string, task func() (interface{}, error)) (interface{}, error) {
+	l.keyedMu.Lock()
+	handle, attached := l.keyed[key]
+	if !attached {
+		if l.keyed == nil {
+			l.keyed = make(map[string]*JobHandle)
+		}
+		handle = &JobHandle{job: newJob(context.Background(), task, 5, 1), limiter: l}
+		l.keyed[key] = handle
+
+		// Forget the key before the result is delivered, so that a later caller
+		// runs task again instead of getting a stale result
+		handle.job.onComplete = func() {
+			l.keyedMu.Lock()
+			delete(l.keyed, key)
+			l.keyedMu.Unlock()
+		}
+	}
+	l.keyedMu.Unlock()
+
+	if !attached {
+		if err := l.enqueue(1, handle.job); err != nil {
+			handle.job.complete(nil, err)
+		}
+	}
+	return handle.Wait()
+}
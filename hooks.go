@@ -0,0 +1,54 @@
+// FILENAME: hooks.go
+package gothrottle
+
+import "time"
+
+// Hooks are callbacks for the lifecycle events of a limiter's jobs, for custom metrics
+// or logging without a full Collector. Any of them may be nil. They run on the
+// submitter's, the scheduler's or the job's goroutine, so they must be safe for
+// concurrent use and must not block.
+type Hooks struct {
+	// OnQueued is called when a job is pushed to the queue. Jobs a pure-semaphore
+	// limiter starts right away without queueing are not reported.
+	OnQueued func(priority, weight int)
+
+	// OnStart is called when a job's task is about to run.
+	OnStart func(priority, weight int)
+
+	// OnDone is called when a job's task has returned, with how long it ran and the
+	// error it returned, if any.
+	OnDone func(duration time.Duration, err error)
+
+	// OnReject is called each time the datastore denies a queued job, with the wait
+	// the datastore suggested, or zero if it has to wait for running jobs to finish.
+	// The job stays queued and is retried.
+	OnReject func(waitTime time.Duration, priority, weight int)
+}
+
+// queued reports a job pushed to the queue.
+func (h *Hooks) queued(job *Job) {
+	if h != nil && h.OnQueued != nil {
+		h.OnQueued(job.Priority, job.Weight)
+	}
+}
+
+// started reports a job about to run.
+func (h *Hooks) started(job *Job) {
+	if h != nil && h.OnStart != nil {
+		h.OnStart(job.Priority, job.Weight)
+	}
+}
+
+// done reports a job whose task has returned.
+func (h *Hooks) done(duration time.Duration, err error) {
+	if h != nil && h.OnDone != nil {
+		h.OnDone(duration, err)
+	}
+}
+
+// rejected reports a job denied by the datastore.
+func (h *Hooks) rejected(job *Job, waitTime time.Duration) {
+	if h != nil && h.OnReject != nil {
+		h.OnReject(waitTime, job.Priority, job.Weight)
+	}
+}
@@ -0,0 +1,182 @@
+// FILENAME: http.go
+package gothrottle
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WrapHandler returns a handler that serves each request through the limiter with
+// default priority (5) and weight (1). Requests the queue turns away, under
+// HighWater with StrategyReject or StrategyDropOldest or after MaxQueueTime, get
+// 429 Too Many Requests with a Retry-After header. A client that disconnects while
+// its request is queued is removed from the queue.
+func (l *Limiter) WrapHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served := false
+		_, err := l.scheduleUntilDone(r.Context(), func() (interface{}, error) {
+			served = true
+			next.ServeHTTP(w, r)
+			return nil, nil
+		}, 5, 1)
+
+		// Once next has run, the response is its own, even if it panicked
+		if err == nil || served {
+			return
+		}
+
+		switch {
+		case errors.Is(err, ErrQueueFull), errors.Is(err, ErrDropped), errors.Is(err, ErrQueueTimeout):
+			w.Header().Set("Retry-After", l.retryAfter())
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		case r.Context().Err() != nil:
+			// The client has gone away, so there is no one to respond to
+		default:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// Middleware returns net/http middleware that sheds load instead of queueing it:
+// while the limiter is saturated, i.e. jobs are queued or the datastore reports that
+// no slot is free, requests get 503 Service Unavailable with a Retry-After header
+// derived from the datastore's suggested wait. Other requests are served by the
+// handler returned by WrapHandler, which releases the slot when next returns and
+// stops waiting when the request's context is done.
+//
+// Saturation is checked with CheckAvailable, so requests are only turned away early
+// if the datastore implements Peeker; otherwise they are always scheduled.
+func Middleware(l *Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := l.WrapHandler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if saturated, wait := l.saturated(); saturated {
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// saturated reports whether a new job would have to wait, and if so how long the
+// datastore suggests waiting. Errors, including ErrPeekUnsupported, count as not
+// saturated and are left for scheduling to report.
+func (l *Limiter) saturated() (bool, time.Duration) {
+	l.mu.RLock()
+	queued := l.queue.Len()
+	l.mu.RUnlock()
+	if queued > 0 {
+		return true, 0
+	}
+
+	canRun, wait, err := l.CheckAvailable()
+	if err != nil {
+		return false, 0
+	}
+	return !canRun, wait
+}
+
+// throttledTransport is the http.RoundTripper returned by NewThrottledTransport.
+type throttledTransport struct {
+	rt      http.RoundTripper
+	limiter *Limiter
+}
+
+// NewThrottledTransport returns an http.RoundTripper that sends each request through
+// rt as a job of the limiter with default priority (5) and weight (1), so that
+//
+//	client := &http.Client{Transport: gothrottle.NewThrottledTransport(nil, limiter)}
+//
+// is rate limited. rt defaults to http.DefaultTransport if nil. A request whose
+// context is done while it is queued fails with the context's error. The job ends
+// when the response headers arrive, so reading the body does not hold a slot.
+func NewThrottledTransport(rt http.RoundTripper, l *Limiter) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &throttledTransport{rt: rt, limiter: l}
+}
+
+// RoundTrip schedules the request through the limiter.
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	result, err := t.limiter.scheduleUntilDone(req.Context(), func() (interface{}, error) {
+		return t.rt.RoundTrip(req)
+	}, 5, 1)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*http.Response), nil
+}
+
+// CooldownFromResponse applies the rate limit an upstream API reports in resp's headers
+// by calling SetCooldown, so that the limiter backs off when the API pushes back
+// instead of relying on a static MinTime alone. It honors Retry-After, in seconds or
+// as an HTTP date, and otherwise X-RateLimit-Reset once X-RateLimit-Remaining reaches
+// 0, with the reset given in seconds from now or as a Unix timestamp. A cooldown
+// already in effect is only ever extended. It returns the cooldown the headers ask
+// for, or zero if they ask for none.
+//
+//	resp, err := client.Do(req)
+//	if err == nil {
+//		limiter.CooldownFromResponse(resp)
+//	}
+func (l *Limiter) CooldownFromResponse(resp *http.Response) time.Duration {
+	now := l.options().Clock.Now()
+	wait := headerCooldown(resp.Header, now)
+	if wait <= 0 {
+		return 0
+	}
+	if wait > l.cooldown() {
+		l.SetCooldown(wait)
+	}
+	return wait
+}
+
+// headerCooldown returns how long the rate limit headers in h ask the client to wait.
+func headerCooldown(h http.Header, now time.Time) time.Duration {
+	if value := h.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return at.Sub(now)
+		}
+	}
+
+	if h.Get("X-RateLimit-Remaining") != "0" {
+		return 0
+	}
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	// Values this large can only be timestamps
+	if reset > unixResetThreshold {
+		return time.Unix(reset, 0).Sub(now)
+	}
+	return time.Duration(reset) * time.Second
+}
+
+// unixResetThreshold separates X-RateLimit-Reset values given in seconds from now from
+// Unix timestamps. It is about 31 years in seconds, in September 2001 as a timestamp.
+const unixResetThreshold = 1e9
+
+// retryAfter returns a Retry-After header value in whole seconds, derived from the
+// datastore's suggested wait if it implements Peeker, and at least one second.
+func (l *Limiter) retryAfter() string {
+	_, wait, _ := l.CheckAvailable()
+	return retryAfterSeconds(wait)
+}
+
+// retryAfterSeconds formats wait as a Retry-After header value in whole seconds,
+// rounded up and at least one second.
+func retryAfterSeconds(wait time.Duration) string {
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,115 @@
+// FILENAME: throttle.go
+
+// Package ioutil throttles the bandwidth of an io.Reader or io.Writer with a
+// gothrottle.Limiter.
+//
+// Every Read or Write of n bytes is scheduled as a job of weight ceil(n/bytesPerUnit),
+// so one unit of weight stands for bytesPerUnit bytes. To cap throughput, use a
+// limiter with AlgorithmGCRA, where each unit of weight takes MinTime:
+//
+//	bytes per second = bytesPerUnit / MinTime (in seconds)
+//
+// e.g. bytesPerUnit 1024 and MinTime 10ms give 100 KiB/s. A positive MaxConcurrent
+// or LocalMaxConcurrent also caps the weight of a single job, so reads and writes are
+// split into chunks of at most that many units.
+package ioutil
+
+import (
+	"io"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+// ThrottledReader is an io.Reader whose reads are paced by a Limiter.
+type ThrottledReader struct {
+	r            io.Reader
+	limiter      *gothrottle.Limiter
+	bytesPerUnit int
+}
+
+// NewThrottledReader wraps r so that every bytesPerUnit bytes read take one unit of
+// weight from limiter. A bytesPerUnit below 1 counts every byte as one unit.
+func NewThrottledReader(r io.Reader, limiter *gothrottle.Limiter, bytesPerUnit int) *ThrottledReader {
+	return &ThrottledReader{r: r, limiter: limiter, bytesPerUnit: normalizeUnit(bytesPerUnit)}
+}
+
+// Read reads up to one chunk into p and then waits until the limiter grants the
+// bytes read, so a short read only pays for what it returned.
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	if limit := maxChunk(t.limiter, t.bytesPerUnit); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := acquire(t.limiter, n, t.bytesPerUnit); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ThrottledWriter is an io.Writer whose writes are paced by a Limiter.
+type ThrottledWriter struct {
+	w            io.Writer
+	limiter      *gothrottle.Limiter
+	bytesPerUnit int
+}
+
+// NewThrottledWriter wraps w so that every bytesPerUnit bytes written take one unit
+// of weight from limiter. A bytesPerUnit below 1 counts every byte as one unit.
+func NewThrottledWriter(w io.Writer, limiter *gothrottle.Limiter, bytesPerUnit int) *ThrottledWriter {
+	return &ThrottledWriter{w: w, limiter: limiter, bytesPerUnit: normalizeUnit(bytesPerUnit)}
+}
+
+// Write writes p in chunks, waiting for the limiter to grant each chunk before
+// writing it.
+func (t *ThrottledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if limit := maxChunk(t.limiter, t.bytesPerUnit); limit > 0 && len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+
+		if err := acquire(t.limiter, len(chunk), t.bytesPerUnit); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// normalizeUnit returns bytesPerUnit, or 1 if it is not positive.
+func normalizeUnit(bytesPerUnit int) int {
+	if bytesPerUnit < 1 {
+		return 1
+	}
+	return bytesPerUnit
+}
+
+// maxChunk returns the largest number of bytes a single job may cover, or zero if
+// the limiter does not cap job weight.
+func maxChunk(limiter *gothrottle.Limiter, bytesPerUnit int) int {
+	opts := limiter.Options()
+	units := opts.MaxConcurrent
+	if opts.LocalMaxConcurrent > 0 && (units <= 0 || opts.LocalMaxConcurrent < units) {
+		units = opts.LocalMaxConcurrent
+	}
+	if units <= 0 {
+		return 0
+	}
+	return units * bytesPerUnit
+}
+
+// acquire blocks until limiter grants a job covering n bytes.
+func acquire(limiter *gothrottle.Limiter, n, bytesPerUnit int) error {
+	weight := (n + bytesPerUnit - 1) / bytesPerUnit
+	_, err := limiter.ScheduleWithOptions(func() (interface{}, error) { return nil, nil }, 5, weight)
+	return err
+}
@@ -3,6 +3,13 @@ package gothrottle
 
 import (
 	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Job represents a function to be executed by the Limiter.
@@ -14,17 +21,143 @@ type Job struct {
 	// Internal fields for returning results
 	resultChan chan interface{}
 	errorChan  chan error
+	done       chan struct{}
 	index      int
+
+	// effectivePriority is the priority used for ordering, including any aging boost
+	effectivePriority int
+
+	// seq is the submission order, used to keep equal-priority jobs FIFO
+	seq uint64
+
+	// key separates the job's datastore state from other keys, see ScheduleKeyed
+	key string
+
+	// tier is the name of the Options.Tiers entry the job runs in, empty for the first
+	tier string
+
+	// notBefore is the earliest time the job may start, see Limiter.ScheduleAt
+	notBefore time.Time
+
+	// storeRetries counts datastore errors seen while requesting a slot for this job
+	storeRetries int
+
+	// probe marks a job started to probe a half-open circuit breaker
+	probe bool
+
+	// releaseMu guards running and released, see JobHandle.Release
+	releaseMu sync.Mutex
+	running   bool
+	released  int
+
+	// onError, if set, is called with the job's error, see Limiter.Go
+	onError func(err error)
+
+	// onComplete, if set, is called before the job's outcome is delivered, see
+	// Limiter.ScheduleWithKey
+	onComplete func()
+
+	// onResult, if set, is called with the job's outcome once it is done, see
+	// Limiter.ScheduleCallback
+	onResult func(result interface{}, err error)
+
+	// Internal fields for tracing the time spent queued
+	ctx        context.Context
+	enqueuedAt time.Time
+	waitSpan   trace.Span
 }
 
+// newJob creates a job with its result channels.
+func newJob(ctx context.Context, task func() (interface{}, error), priority, weight int) *Job {
+	return &Job{
+		Task:       task,
+		Priority:   priority,
+		Weight:     weight,
+		ctx:        ctx,
+		resultChan: make(chan interface{}, 1),
+		errorChan:  make(chan error, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// complete delivers the outcome of a job and marks it as done.
+// It must be called exactly once per job.
+func (job *Job) complete(result interface{}, err error) {
+	if job.onComplete != nil {
+		job.onComplete()
+	}
+	if err != nil {
+		if job.onError != nil {
+			job.onError(err)
+		}
+		select {
+		case job.errorChan <- err:
+		default:
+		}
+	} else {
+		select {
+		case job.resultChan <- result:
+		default:
+		}
+	}
+	close(job.done)
+
+	if job.onResult != nil {
+		job.onResult(result, err)
+	}
+}
+
+// start marks the job as running, allowing part of its weight to be released.
+func (job *Job) start() {
+	job.releaseMu.Lock()
+	job.running = true
+	job.releaseMu.Unlock()
+}
+
+// release records that weight of the running job was released early.
+func (job *Job) release(weight int) error {
+	job.releaseMu.Lock()
+	defer job.releaseMu.Unlock()
+
+	if !job.running {
+		return ErrJobNotRunning
+	}
+	if held := job.Weight - job.released; weight > held {
+		return fmt.Errorf("%w: releasing weight %d of a job holding %d", ErrAccountingMismatch, weight, held)
+	}
+	job.released += weight
+	return nil
+}
+
+// stop marks the job as no longer running and returns the weight it still holds.
+func (job *Job) stop() int {
+	job.releaseMu.Lock()
+	defer job.releaseMu.Unlock()
+
+	job.running = false
+	return job.Weight - job.released
+}
+
+// jobSeq is the source of monotonically increasing job sequence numbers.
+var jobSeq uint64
+
 // PriorityQueue implements heap.Interface and holds Jobs.
 type PriorityQueue []*Job
 
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
+	return runsBefore(pq[i], pq[j])
+}
+
+// runsBefore reports whether job a should run before job b.
+func runsBefore(a, b *Job) bool {
 	// Higher priority values have higher priority (max heap)
-	return pq[i].Priority > pq[j].Priority
+	if a.effectivePriority != b.effectivePriority {
+		return a.effectivePriority > b.effectivePriority
+	}
+	// Equal priorities run in submission order
+	return a.seq < b.seq
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
@@ -59,6 +192,13 @@ func NewPriorityQueue() *PriorityQueue {
 
 // PushJob adds a job to the priority queue.
 func (pq *PriorityQueue) PushJob(job *Job) {
+	if job.effectivePriority < job.Priority {
+		job.effectivePriority = job.Priority
+	}
+	// Requeued jobs keep their original place in line
+	if job.seq == 0 {
+		job.seq = atomic.AddUint64(&jobSeq, 1)
+	}
 	heap.Push(pq, job)
 }
 
@@ -70,6 +210,185 @@ func (pq *PriorityQueue) PopJob() *Job {
 	return heap.Pop(pq).(*Job)
 }
 
+// popFirst removes and returns the highest priority job for which eligible returns
+// true, or nil if there is none. Unlike PopJob it scans the whole queue.
+func (pq *PriorityQueue) popFirst(eligible func(job *Job) bool) *Job {
+	var first *Job
+	for _, job := range *pq {
+		if eligible(job) && (first == nil || runsBefore(job, first)) {
+			first = job
+		}
+	}
+	if first != nil {
+		heap.Remove(pq, first.index)
+	}
+	return first
+}
+
+// RemoveJob removes a job from the queue if it is still queued.
+// It returns true if the job was removed.
+func (pq *PriorityQueue) RemoveJob(job *Job) bool {
+	if job.index < 0 || job.index >= pq.Len() || (*pq)[job.index] != job {
+		return false
+	}
+	heap.Remove(pq, job.index)
+	return true
+}
+
+// RemoveLowest removes and returns the lowest priority job, the oldest one among
+// equal priorities. It returns nil if the queue is empty.
+func (pq *PriorityQueue) RemoveLowest() *Job {
+	if pq.Len() == 0 {
+		return nil
+	}
+	lowest := (*pq)[0]
+	for _, job := range *pq {
+		if job.effectivePriority < lowest.effectivePriority ||
+			(job.effectivePriority == lowest.effectivePriority && job.seq < lowest.seq) {
+			lowest = job
+		}
+	}
+	heap.Remove(pq, lowest.index)
+	return lowest
+}
+
+// removeExpired removes and returns the jobs enqueued at or before cutoff. It also
+// returns the enqueue time of the oldest job left in the queue, or the zero time.
+func (pq *PriorityQueue) removeExpired(cutoff time.Time) (expired []*Job, oldest time.Time) {
+	kept := (*pq)[:0]
+	for _, job := range *pq {
+		if !job.enqueuedAt.After(cutoff) {
+			job.index = -1
+			expired = append(expired, job)
+			continue
+		}
+		if oldest.IsZero() || job.enqueuedAt.Before(oldest) {
+			oldest = job.enqueuedAt
+		}
+		job.index = len(kept)
+		kept = append(kept, job)
+	}
+	if len(expired) > 0 {
+		for i := len(kept); i < len(*pq); i++ {
+			(*pq)[i] = nil // avoid memory leak
+		}
+		*pq = kept
+		heap.Init(pq)
+	}
+	return expired, oldest
+}
+
+// Age raises the effective priority of each queued job by one for every interval
+// it has waited since it was enqueued, then restores the heap ordering. A job
+// enqueued after now, e.g. one submitted with ScheduleAt, has not waited at all.
+func (pq *PriorityQueue) Age(now time.Time, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for _, job := range *pq {
+		job.effectivePriority = job.Priority
+		if !job.enqueuedAt.IsZero() && now.After(job.enqueuedAt) {
+			job.effectivePriority += int(now.Sub(job.enqueuedAt) / interval)
+		}
+	}
+	heap.Init(pq)
+}
+
+// delayQueue implements heap.Interface and holds the jobs submitted with
+// Limiter.ScheduleAt that are not due yet, earliest not-before time first.
+type delayQueue []*Job
+
+func (dq delayQueue) Len() int { return len(dq) }
+
+func (dq delayQueue) Less(i, j int) bool {
+	if !dq[i].notBefore.Equal(dq[j].notBefore) {
+		return dq[i].notBefore.Before(dq[j].notBefore)
+	}
+	return dq[i].seq < dq[j].seq
+}
+
+func (dq delayQueue) Swap(i, j int) {
+	dq[i], dq[j] = dq[j], dq[i]
+	dq[i].index = i
+	dq[j].index = j
+}
+
+func (dq *delayQueue) Push(x interface{}) {
+	item := x.(*Job)
+	item.index = len(*dq)
+	*dq = append(*dq, item)
+}
+
+func (dq *delayQueue) Pop() interface{} {
+	old := *dq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil  // avoid memory leak
+	item.index = -1 // for safety
+	*dq = old[0 : n-1]
+	return item
+}
+
+// pushJob adds a job that is not due yet.
+func (dq *delayQueue) pushJob(job *Job) {
+	if job.seq == 0 {
+		job.seq = atomic.AddUint64(&jobSeq, 1)
+	}
+	heap.Push(dq, job)
+}
+
+// popJob removes and returns the job due first, or nil if the queue is empty.
+func (dq *delayQueue) popJob() *Job {
+	if dq.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(dq).(*Job)
+}
+
+// popDue removes and returns the jobs whose not-before time is at or before now.
+func (dq *delayQueue) popDue(now time.Time) []*Job {
+	var due []*Job
+	for dq.Len() > 0 && !(*dq)[0].notBefore.After(now) {
+		due = append(due, heap.Pop(dq).(*Job))
+	}
+	return due
+}
+
+// next returns the earliest not-before time of a queued job, or the zero time if
+// the queue is empty.
+func (dq delayQueue) next() time.Time {
+	if len(dq) == 0 {
+		return time.Time{}
+	}
+	return dq[0].notBefore
+}
+
+// removeJob removes a job from the queue if it is still queued.
+// It returns true if the job was removed.
+func (dq *delayQueue) removeJob(job *Job) bool {
+	if job.index < 0 || job.index >= dq.Len() || (*dq)[job.index] != job {
+		return false
+	}
+	heap.Remove(dq, job.index)
+	return true
+}
+
+// removeLast removes and returns the job due last, the newest one among equal
+// not-before times. It returns nil if the queue is empty.
+func (dq *delayQueue) removeLast() *Job {
+	if dq.Len() == 0 {
+		return nil
+	}
+	last := (*dq)[0]
+	for _, job := range *dq {
+		if job.notBefore.After(last.notBefore) || (job.notBefore.Equal(last.notBefore) && job.seq > last.seq) {
+			last = job
+		}
+	}
+	heap.Remove(dq, last.index)
+	return last
+}
+
 // IsEmpty returns true if the queue is empty.
 func (pq *PriorityQueue) IsEmpty() bool {
 	return pq.Len() == 0
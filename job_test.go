@@ -0,0 +1,31 @@
+package gothrottle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityQueue_AgeFutureEnqueue(t *testing.T) {
+	now := time.Unix(1000, 0)
+	pq := NewPriorityQueue()
+
+	// A job not due for an hour, as ScheduleAt enqueues it, and a lower priority
+	// job that has just been enqueued
+	delayed := &Job{Priority: 5, enqueuedAt: now.Add(time.Hour)}
+	low := &Job{Priority: 1, enqueuedAt: now}
+	pq.PushJob(delayed)
+	pq.PushJob(low)
+
+	pq.Age(now, time.Second)
+	if delayed.effectivePriority != 5 {
+		t.Errorf("Expected no aging boost for a job enqueued in the future, got priority %d", delayed.effectivePriority)
+	}
+
+	// It still sorts above lower priority work and is not dropped first
+	if lowest := pq.RemoveLowest(); lowest != low {
+		t.Errorf("Expected the priority 1 job to be the lowest, got priority %d", lowest.Priority)
+	}
+	if next := pq.PopJob(); next != delayed {
+		t.Error("Expected the delayed job to be left in the queue")
+	}
+}
@@ -2,28 +2,110 @@
 package gothrottle
 
 import (
+	"context"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the instrumentation name used for spans created by the Limiter.
+const tracerName = "github.com/AFZidan/gothrottle"
+
+// defaultPollInterval is the PollInterval used when Options.PollInterval is zero.
+const defaultPollInterval = 10 * time.Millisecond
+
+// healthCheckTimeout bounds the datastore Ping made by Healthy.
+const healthCheckTimeout = time.Second
+
 // Limiter manages job scheduling and rate limiting.
 type Limiter struct {
-	opts      Options
+	opts      atomic.Pointer[Options] // replaced as a whole by UpdateOptions
 	datastore Datastore
 	queue     *PriorityQueue
 	mu        sync.RWMutex
 	running   bool
 	stopCh    chan struct{}
+	notifyCh  chan struct{}
 	wg        sync.WaitGroup
+	stats     limiterStats
+	tiers     tierScheduler // guarded by mu
+	bands     bandScheduler // guarded by mu
+	aimd      aimdState
+	breaker   circuitState
+	done      doneBatch
+
+	// keyed holds the jobs submitted with ScheduleWithKey that have not finished,
+	// by key. Guarded by keyedMu rather than mu, because a job leaves it as it
+	// completes, which may happen while mu is held.
+	keyedMu sync.Mutex
+	keyed   map[string]*JobHandle
+	// memoryCache holds ScheduleCached results when Options.Cache is not set.
+	// Guarded by mu.
+	memoryCache *MemoryCache
+	// keyedSeen is set once a job is submitted with ScheduleKeyed, after which a
+	// denied job no longer holds up jobs with other keys. Guarded by mu.
+	keyedSeen bool
+	// agedAt is when the queue was last aged under PriorityAging. Guarded by mu.
+	agedAt time.Time
+	// delayed holds the jobs submitted with ScheduleAt until they are due and move
+	// to queue. Guarded by mu.
+	delayed delayQueue
+
+	// localWeight is the weight of jobs and TryAcquire slots running in this
+	// process, checked against LocalMaxConcurrent.
+	localWeight atomic.Int64
+
+	// tasks is the number of tasks executing in this process, checked against
+	// MaxDispatchGoroutines.
+	tasks atomic.Int64
+
+	// cooldownUntil is when the cooldown set by SetCooldown ends, in Unix
+	// nanoseconds of the limiter's clock, or zero if there is none.
+	cooldownUntil atomic.Int64
+
+	// inflight counts jobs taken off the queue that have not finished yet,
+	// including the datastore RegisterDone call, batched or not. Guarded by mu.
+	inflight int
+	// idleCh is closed and replaced whenever the limiter becomes idle. Guarded by mu.
+	idleCh chan struct{}
+	// busy is set when a job is queued or started directly and cleared once the
+	// limiter is idle again, so that idleSubs hear of each transition once. Guarded by mu.
+	busy bool
+	// idleSubs are the channels returned by IdleNotify. Guarded by mu.
+	idleSubs []chan struct{}
+	// roomCh, if not nil, is closed when a job leaves the queue to wake submitters
+	// blocked at HighWater. Guarded by mu.
+	roomCh chan struct{}
+	// reserved is the queued job holding a reservation under Options.ReservePriority,
+	// if any. It may have left the queue since, e.g. when it was cancelled. Guarded by mu.
+	reserved *Job
+
+	// manual is set for limiters created with newManualLimiter, whose queued jobs
+	// are only dispatched by tick. tickMu serializes tick calls.
+	manual bool
+	tickMu sync.Mutex
 }
 
 // NewLimiter creates a new Limiter instance.
 func NewLimiter(opts Options) (*Limiter, error) {
+	return newLimiter(opts, false)
+}
+
+// newLimiter creates a Limiter, whose scheduler only runs on tick if manual is set.
+func newLimiter(opts Options, manual bool) (*Limiter, error) {
 	// Validate options
 	if opts.Datastore != nil && opts.ID == "" {
 		return nil, ErrMissingID
 	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 
 	// Default to LocalStore if no datastore is provided
 	datastore := opts.Datastore
@@ -34,13 +116,23 @@ func NewLimiter(opts Options) (*Limiter, error) {
 		}
 	}
 
+	// Default to a no-op logger and the system clock
+	if opts.Logger == nil {
+		opts.Logger = noopLogger{}
+	}
+	opts.Clock = opts.clock()
+
 	limiter := &Limiter{
-		opts:      opts,
 		datastore: datastore,
 		queue:     NewPriorityQueue(),
 		stopCh:    make(chan struct{}),
+		notifyCh:  make(chan struct{}, 1),
+		idleCh:    make(chan struct{}),
+		manual:    manual,
 	}
 
+	limiter.opts.Store(&opts)
+
 	// Start the scheduler
 	limiter.start()
 
@@ -54,28 +146,103 @@ func (l *Limiter) Schedule(task func() (interface{}, error)) (interface{}, error
 
 // ScheduleWithOptions submits a job with custom priority and weight.
 func (l *Limiter) ScheduleWithOptions(task func() (interface{}, error), priority, weight int) (interface{}, error) {
-	if weight <= 0 {
-		return nil, ErrInvalidWeight
+	return l.ScheduleContextWithOptions(context.Background(), task, priority, weight)
+}
+
+// ScheduleContext submits a job bound to ctx and blocks until completion.
+// If ctx carries a span, the time spent queued and the task execution are
+// recorded as child spans named "gothrottle.wait" and "gothrottle.execute".
+func (l *Limiter) ScheduleContext(ctx context.Context, task func() (interface{}, error)) (interface{}, error) {
+	return l.ScheduleContextWithOptions(ctx, task, 5, 1) // Default priority 5, weight 1
+}
+
+// ScheduleContextWithOptions submits a job bound to ctx with custom priority and weight.
+// If ctx is done before the job starts, the job is removed from the queue and ctx.Err() is returned.
+func (l *Limiter) ScheduleContextWithOptions(ctx context.Context, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	job := newJob(ctx, task, priority, weight)
+
+	// A job that cannot be cancelled may run right here if a slot is free;
+	// otherwise it has to wait in the queue
+	if ctx.Done() != nil || !l.dispatchDirect(job) {
+		if err := l.enqueue(weight, job); err != nil {
+			return nil, err
+		}
 	}
 
-	job := &Job{
-		Task:       task,
-		Priority:   priority,
-		Weight:     weight,
-		resultChan: make(chan interface{}, 1),
-		errorChan:  make(chan error, 1),
+	// Wait for job completion
+	select {
+	case result := <-job.resultChan:
+		return result, nil
+	case err := <-job.errorChan:
+		return nil, err
+	case <-ctx.Done():
+		l.cancel(job, ctx.Err())
+		return nil, ctx.Err()
 	}
+}
 
-	// Add job to queue
-	l.mu.Lock()
-	if !l.running {
-		l.mu.Unlock()
-		return nil, ErrStoreClosed
+// ScheduleCtx is like ScheduleContext, except that ctx is also passed to the task so
+// that the task itself can observe cancellation, e.g. by passing ctx on to
+// db.QueryContext or http.NewRequestWithContext.
+func (l *Limiter) ScheduleCtx(ctx context.Context, task func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	return l.ScheduleCtxWithOptions(ctx, task, 5, 1) // Default priority 5, weight 1
+}
+
+// ScheduleCtxWithOptions is like ScheduleContextWithOptions, except that ctx is also
+// passed to the task.
+func (l *Limiter) ScheduleCtxWithOptions(ctx context.Context, task func(ctx context.Context) (interface{}, error), priority, weight int) (interface{}, error) {
+	return l.ScheduleContextWithOptions(ctx, func() (interface{}, error) {
+		return task(ctx)
+	}, priority, weight)
+}
+
+// ScheduleUntilDone is like ScheduleContextWithOptions, except that once the job has
+// started it waits for the task to return even if ctx is done, so that the task can
+// safely use resources owned by the caller, such as a request being served, and the
+// caller never returns while the task still holds its slot. If ctx is done while the
+// job is queued, the job is removed from the queue and ctx.Err() is returned.
+func (l *Limiter) ScheduleUntilDone(ctx context.Context, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	return l.scheduleUntilDone(ctx, task, priority, weight)
+}
+
+// scheduleUntilDone implements ScheduleUntilDone, for WrapHandler, Chain and
+// NewThrottledTransport.
+func (l *Limiter) scheduleUntilDone(ctx context.Context, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	job := newJob(ctx, task, priority, weight)
+	if err := l.enqueue(weight, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		if l.cancel(job, ctx.Err()) {
+			return nil, ctx.Err()
+		}
+		<-job.done
+	}
+
+	select {
+	case result := <-job.resultChan:
+		return result, nil
+	case err := <-job.errorChan:
+		return nil, err
+	}
+}
+
+// ScheduleAt submits a job with default priority (5) and weight (1) that must not
+// start before when, and blocks until completion. Until when the job waits apart
+// from the queue, ordered by time, though it counts as queued towards HighWater and
+// Stats; from then on it is queued and throttled like any other job.
+// MaxQueueTime, PriorityAging and OnQueueWait count its time queued from when, on
+// Options.Clock.
+func (l *Limiter) ScheduleAt(when time.Time, task func() (interface{}, error)) (interface{}, error) {
+	job := newJob(context.Background(), task, 5, 1)
+	job.notBefore = when
+	if err := l.enqueue(1, job); err != nil {
+		return nil, err
 	}
-	l.queue.PushJob(job)
-	l.mu.Unlock()
 
-	// Wait for job completion
 	select {
 	case result := <-job.resultChan:
 		return result, nil
@@ -84,6 +251,410 @@ func (l *Limiter) ScheduleWithOptions(task func() (interface{}, error), priority
 	}
 }
 
+// ScheduleKeyed submits a job with custom priority and weight that is rate limited
+// separately for each key, e.g. per user or tenant, and blocks until completion.
+// The datastore tracks each key under its own limiter ID, "<ID>:<key>", with the
+// limiter's Options, so one Limiter can stand in for thousands of per-key limiters.
+// Jobs denied for one key do not hold up jobs of other keys. An empty key is the
+// same as ScheduleWithOptions.
+func (l *Limiter) ScheduleKeyed(key string, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	job := newJob(context.Background(), task, priority, weight)
+	job.key = key
+	if err := l.enqueue(weight, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-job.resultChan:
+		return result, nil
+	case err := <-job.errorChan:
+		return nil, err
+	}
+}
+
+// cancel removes a job from the queue and completes it with err if it has not
+// started yet. It returns true if the job was removed.
+func (l *Limiter) cancel(job *Job, err error) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.queue.RemoveJob(job) && !l.delayed.removeJob(job) {
+		return false
+	}
+	l.reject(job, err)
+	l.signalRoom()
+	l.signalIdle()
+	return true
+}
+
+// enqueue validates jobs sharing the same weight and adds them to the queue
+// under a single lock acquisition.
+func (l *Limiter) enqueue(weight int, jobs ...*Job) error {
+	if weight <= 0 {
+		l.stats.rejected.Add(uint64(len(jobs)))
+		return ErrInvalidWeight
+	}
+
+	// Add jobs to queue
+	l.mu.Lock()
+	if !l.running {
+		l.mu.Unlock()
+		l.stats.rejected.Add(uint64(len(jobs)))
+		return ErrStoreClosed
+	}
+	opts := l.options()
+	if l.breaker.open(opts) {
+		l.mu.Unlock()
+		l.stats.rejected.Add(uint64(len(jobs)))
+		return ErrCircuitOpen
+	}
+	if !opts.weightFits(weight) {
+		l.mu.Unlock()
+		l.stats.rejected.Add(uint64(len(jobs)))
+		return ErrWeightExceedsLimit
+	}
+	for _, job := range jobs {
+		if !opts.priorityInRange(job.Priority) {
+			l.mu.Unlock()
+			l.stats.rejected.Add(uint64(len(jobs)))
+			return ErrInvalidPriority
+		}
+		if job.tier != "" && !opts.hasTier(job.tier) {
+			l.mu.Unlock()
+			l.stats.rejected.Add(uint64(len(jobs)))
+			return ErrUnknownTier
+		}
+		if job.key != "" {
+			l.keyedSeen = true
+		}
+	}
+	// Reject the whole batch rather than part of it
+	if opts.HighWater > 0 && opts.Strategy == StrategyReject && l.queuedLen()+len(jobs) > opts.HighWater {
+		l.mu.Unlock()
+		l.stats.rejected.Add(uint64(len(jobs)))
+		return ErrQueueFull
+	}
+	for i, job := range jobs {
+		if err := l.makeRoom(job); err != nil {
+			l.mu.Unlock()
+			l.stats.rejected.Add(uint64(len(jobs) - i))
+			return err
+		}
+		l.startWait(job)
+		// MaxQueueTime, aging and the reported wait count from the not-before time
+		if job.enqueuedAt.Before(job.notBefore) {
+			job.enqueuedAt = job.notBefore
+			l.delayed.pushJob(job)
+		} else {
+			l.queue.PushJob(job)
+		}
+		l.busy = true
+		opts.Logger.Debugf("gothrottle: limiter %q queued job (priority %d, weight %d)", opts.ID, job.Priority, job.Weight)
+	}
+	l.mu.Unlock()
+	for _, job := range jobs {
+		opts.Hooks.queued(job)
+	}
+	l.notify()
+
+	return nil
+}
+
+// makeRoom applies the HighWater strategy until there is room in the queue for job.
+// The caller must hold l.mu; it is released while blocking and held again on return.
+func (l *Limiter) makeRoom(job *Job) error {
+	for {
+		opts := l.options()
+		if opts.HighWater <= 0 || l.queuedLen() < opts.HighWater {
+			return nil
+		}
+
+		switch opts.Strategy {
+		case StrategyReject:
+			return ErrQueueFull
+		case StrategyDropOldest:
+			// Jobs that are not due yet are dropped last, latest first
+			dropped := l.queue.RemoveLowest()
+			if dropped == nil {
+				dropped = l.delayed.removeLast()
+			}
+			opts.Logger.Warnf("gothrottle: limiter %q dropped job: %v", opts.ID, ErrDropped)
+			l.reject(dropped, ErrDropped)
+		default:
+			if l.roomCh == nil {
+				l.roomCh = make(chan struct{})
+			}
+			roomCh := l.roomCh
+			l.mu.Unlock()
+
+			select {
+			case <-roomCh:
+			case <-job.ctx.Done():
+				l.mu.Lock()
+				return job.ctx.Err()
+			case <-l.stopCh:
+				l.mu.Lock()
+				return ErrStoreClosed
+			}
+
+			l.mu.Lock()
+			if !l.running {
+				return ErrStoreClosed
+			}
+		}
+	}
+}
+
+// BatchSchedule submits many jobs with the same priority and weight under a single
+// queue lock and blocks until all of them complete. Results and errors are aligned
+// with tasks by index.
+func (l *Limiter) BatchSchedule(tasks []func() (interface{}, error), priority, weight int) ([]interface{}, []error) {
+	results := make([]interface{}, len(tasks))
+	errs := make([]error, len(tasks))
+
+	jobs := make([]*Job, len(tasks))
+	for i, task := range tasks {
+		jobs[i] = newJob(context.Background(), task, priority, weight)
+	}
+
+	if err := l.enqueue(weight, jobs...); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	// Wait for all jobs to complete
+	for i, job := range jobs {
+		select {
+		case results[i] = <-job.resultChan:
+		case errs[i] = <-job.errorChan:
+		}
+	}
+
+	return results, errs
+}
+
+// TryAcquire reserves a slot of the given weight without submitting a task, using the
+// same MaxConcurrent and MinTime rules as scheduled jobs. It does not wait: if no slot is
+// available it returns false. On success the returned release function must be called
+// once the slot is no longer needed; calling it more than once has no effect.
+// TryAcquire bypasses the queue, so it does not respect the priority of queued jobs.
+func (l *Limiter) TryAcquire(weight int) (acquired bool, release func(), err error) {
+	if weight <= 0 {
+		return false, nil, ErrInvalidWeight
+	}
+
+	l.mu.RLock()
+	if !l.running {
+		l.mu.RUnlock()
+		return false, nil, ErrStoreClosed
+	}
+	opts := *l.options()
+	l.mu.RUnlock()
+
+	if l.cooldown() > 0 || !l.reserveLocal(weight, opts.LocalMaxConcurrent) {
+		return false, nil, nil
+	}
+
+	canRun, _, err := l.datastore.Request(opts.ID, weight, opts)
+	if err != nil {
+		l.releaseLocal(weight)
+		return false, nil, err
+	}
+	if !canRun {
+		l.releaseLocal(weight)
+		return false, nil, nil
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if err := l.datastore.RegisterDone(opts.ID, weight); err != nil {
+				l.storeError("register done", err)
+			}
+			l.releaseLocal(weight)
+			l.notify()
+		})
+	}
+
+	return true, release, nil
+}
+
+// CheckAvailable reports whether a job of weight 1 could start now, and if not how
+// long the datastore suggests waiting, without acquiring a slot. Jobs already queued
+// are dispatched first, so a scheduled job may still have to wait behind them.
+// It returns ErrPeekUnsupported if the datastore does not implement Peeker.
+func (l *Limiter) CheckAvailable() (canRun bool, waitTime time.Duration, err error) {
+	return l.peek(1)
+}
+
+// NextAvailable returns how long a job of the given weight would have to wait before
+// the datastore lets it start, without acquiring a slot, e.g. to tell a user when to
+// retry. It returns zero if the job could start now, and ErrAtCapacity if it has to
+// wait for running jobs to finish, which takes an unpredictable time. Like
+// CheckAvailable, it ignores queued jobs and requires the datastore to implement Peeker.
+func (l *Limiter) NextAvailable(weight int) (time.Duration, error) {
+	if weight <= 0 {
+		return 0, ErrInvalidWeight
+	}
+
+	canRun, waitTime, err := l.peek(weight)
+	switch {
+	case err != nil:
+		return 0, err
+	case canRun:
+		return 0, nil
+	case waitTime <= 0:
+		return 0, ErrAtCapacity
+	}
+	return waitTime, nil
+}
+
+// peek evaluates a job of the given weight against the cooldown, LocalMaxConcurrent,
+// MaxDispatchGoroutines, the adaptive limit and the datastore, without acquiring a slot.
+func (l *Limiter) peek(weight int) (canRun bool, waitTime time.Duration, err error) {
+	l.mu.RLock()
+	if !l.running {
+		l.mu.RUnlock()
+		return false, 0, ErrStoreClosed
+	}
+	opts := *l.options()
+	l.mu.RUnlock()
+
+	peeker, ok := l.datastore.(Peeker)
+	if !ok {
+		return false, 0, ErrPeekUnsupported
+	}
+	if !opts.weightFits(weight) {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	if wait := l.cooldown(); wait > 0 {
+		return false, wait, nil
+	}
+
+	if opts.LocalMaxConcurrent > 0 && int(l.localWeight.Load())+weight > opts.LocalMaxConcurrent {
+		return false, 0, nil
+	}
+	if opts.MaxDispatchGoroutines > 0 && int(l.tasks.Load()) >= opts.MaxDispatchGoroutines {
+		return false, 0, nil
+	}
+
+	storeOpts := opts
+	storeOpts.MaxConcurrent = l.aimd.maxConcurrent(&opts, weight)
+	return peeker.Peek(opts.ID, weight, storeOpts)
+}
+
+// Reset discards the limiter's datastore state, e.g. after a crashed instance left
+// the running count inflated, so that every instance sharing the ID starts over with
+// no running jobs, no MinTime or window history and no cooldown stored in the
+// datastore. Only this instance's own SetCooldown is lifted; other instances that set
+// one stay paused until it ends. Jobs still running release their weight as usual
+// when they finish, which the datastore clamps at zero, or which fails with
+// ErrAccountingMismatch under Options.StrictAccounting with LocalStore. State of
+// ScheduleKeyed keys is kept. It returns ErrResetUnsupported if the
+// datastore does not implement Resetter.
+func (l *Limiter) Reset() error {
+	l.mu.RLock()
+	if !l.running {
+		l.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	opts := l.options()
+	l.mu.RUnlock()
+
+	resetter, ok := l.datastore.(Resetter)
+	if !ok {
+		return ErrResetUnsupported
+	}
+	if err := resetter.Reset(opts.ID); err != nil {
+		return err
+	}
+	l.cooldownUntil.Store(0)
+
+	// Freed slots may let queued jobs run
+	l.notify()
+	return nil
+}
+
+// IdleNotify returns a channel that receives a value each time the limiter goes from
+// busy to idle, i.e. its queue empties and every started job has finished and
+// released its slot. Every call returns a new channel, so several subscribers are
+// notified independently. A subscriber that has not received the previous value
+// misses the next one rather than blocking the limiter. Subscribing does not report
+// the current state; use WaitUntilIdle to wait for work that is already running.
+// The channel is closed once Stop has drained the limiter, or right away if it is
+// already stopped.
+func (l *Limiter) IdleNotify() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.running {
+		close(ch)
+		return ch
+	}
+	l.idleSubs = append(l.idleSubs, ch)
+	return ch
+}
+
+// closeIdleSubs closes the channels returned by IdleNotify.
+func (l *Limiter) closeIdleSubs() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, sub := range l.idleSubs {
+		close(sub)
+	}
+	l.idleSubs = nil
+}
+
+// WaitUntilIdle blocks until the queue is empty and every started job has finished
+// and released its slot in the datastore. It returns ctx.Err() if ctx is done first.
+// Slots reserved with TryAcquire are not waited for.
+func (l *Limiter) WaitUntilIdle(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inflight == 0 && l.queuedLen() == 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		idleCh := l.idleCh
+		l.mu.Unlock()
+
+		select {
+		case <-idleCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// HealthCheck returns ErrStoreClosed if the limiter is stopped and otherwise, if its
+// datastore implements HealthChecker, the result of pinging the datastore with ctx.
+func (l *Limiter) HealthCheck(ctx context.Context) error {
+	l.mu.RLock()
+	running := l.running
+	l.mu.RUnlock()
+	if !running {
+		return ErrStoreClosed
+	}
+
+	checker, ok := l.datastore.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return checker.Ping(ctx)
+}
+
+// Healthy reports whether HealthCheck succeeds within healthCheckTimeout.
+// It suits load balancer and readiness probes.
+func (l *Limiter) Healthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	return l.HealthCheck(ctx) == nil
+}
+
 // Wrap creates a wrapper function that applies rate limiting to any function.
 func (l *Limiter) Wrap(fn func() (interface{}, error)) func() (interface{}, error) {
 	return func() (interface{}, error) {
@@ -91,6 +662,51 @@ func (l *Limiter) Wrap(fn func() (interface{}, error)) func() (interface{}, erro
 	}
 }
 
+// Options returns a copy of the limiter's current options.
+func (l *Limiter) Options() Options {
+	return *l.options()
+}
+
+// options returns the current options snapshot. The snapshot must not be modified.
+func (l *Limiter) options() *Options {
+	return l.opts.Load()
+}
+
+// UpdateOptions replaces the limiter's options at runtime without losing queued jobs.
+// The new limits take effect on the next scheduling pass. An empty ID or nil Datastore
+// or Clock leaves those settings unchanged; any other value different from the current
+// one returns ErrImmutableOption, and options that fail Validate are rejected. All other
+// fields are replaced, so start from Options() to change a single setting.
+func (l *Limiter) UpdateOptions(opts Options) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.options()
+	if opts.ID != "" && opts.ID != current.ID {
+		return ErrImmutableOption
+	}
+	if opts.Datastore != nil && opts.Datastore != l.datastore {
+		return ErrImmutableOption
+	}
+	if opts.Clock != nil && opts.Clock != current.Clock {
+		return ErrImmutableOption
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	opts.ID = current.ID
+	opts.Datastore = current.Datastore
+	opts.Clock = current.Clock
+	if opts.Logger == nil {
+		opts.Logger = noopLogger{}
+	}
+	l.opts.Store(&opts)
+	l.notify()
+
+	return nil
+}
+
 // start begins the scheduler goroutine.
 func (l *Limiter) start() {
 	l.mu.Lock()
@@ -106,7 +722,17 @@ func (l *Limiter) start() {
 }
 
 // Stop stops the limiter and waits for all jobs to complete.
+// Queued jobs that have not started fail with ErrStoreClosed. It is safe to call
+// Stop repeatedly and concurrently: only the first call waits and disconnects the
+// datastore, later ones return nil at once.
 func (l *Limiter) Stop() error {
+	return l.StopContext(context.Background())
+}
+
+// StopContext stops the limiter like Stop, but gives up waiting for running jobs
+// when ctx is done and returns ctx.Err(). The limiter stays stopped; tasks still
+// running are not interrupted, and the datastore is disconnected once they finish.
+func (l *Limiter) StopContext(ctx context.Context) error {
 	l.mu.Lock()
 	if !l.running {
 		l.mu.Unlock()
@@ -116,19 +742,49 @@ func (l *Limiter) Stop() error {
 	close(l.stopCh)
 	l.mu.Unlock()
 
-	// Wait for scheduler to finish
-	l.wg.Wait()
+	opts := l.options()
+	opts.Logger.Infof("gothrottle: limiter %q stopping", opts.ID)
+
+	// Wait for the scheduler to drain the queue and for running jobs to finish
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		_ = l.WaitUntilIdle(context.Background()) // Never fails without a deadline
+		l.closeIdleSubs()
+		close(done)
+	}()
 
-	// Disconnect datastore
-	return l.datastore.Disconnect()
+	select {
+	case <-done:
+		// Disconnect datastore
+		return l.datastore.Disconnect()
+	case <-ctx.Done():
+		go func() {
+			<-done
+			if err := l.datastore.Disconnect(); err != nil {
+				l.storeError("disconnect", err)
+			}
+		}()
+		return ctx.Err()
+	}
 }
 
 // scheduler is the main scheduling loop that runs in a background goroutine.
+// It sleeps until a job is queued, a running job completes, or the wait time
+// suggested by the datastore has elapsed.
 func (l *Limiter) scheduler() {
 	defer l.wg.Done()
 
-	ticker := time.NewTicker(10 * time.Millisecond) // Small polling interval
-	defer ticker.Stop()
+	// Only tick dispatches jobs of a manual limiter
+	if l.manual {
+		<-l.stopCh
+		l.processRemainingJobs()
+		return
+	}
+
+	timer := l.options().Clock.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C()
 
 	for {
 		select {
@@ -136,97 +792,596 @@ func (l *Limiter) scheduler() {
 			// Process remaining jobs before stopping
 			l.processRemainingJobs()
 			return
-		case <-ticker.C:
-			l.processJobs()
+		case <-l.notifyCh:
+		case <-timer.C():
+		}
+
+		if retry := l.processJobs(); retry > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(retry)
 		}
 	}
 }
 
-// processJobs checks for pending jobs and executes them if allowed.
-func (l *Limiter) processJobs() {
+// notify wakes up the scheduler without blocking.
+func (l *Limiter) notify() {
+	select {
+	case l.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// processJobs dispatches queued jobs for as long as the datastore allows them to run.
+// Once a job is denied, only jobs with a different datastore ID, i.e. submitted with
+// ScheduleKeyed, are tried in the same pass. It returns how long to wait before
+// retrying the first denied job, expiring the next job under MaxQueueTime or ending
+// a cooldown, whichever comes first, or zero if the scheduler should wait for the
+// next notification.
+func (l *Limiter) processJobs() time.Duration {
+	var blocked map[string]bool
+	next := l.expireQueued()
+	if wait := l.cooldown(); wait > 0 {
+		if next == 0 || wait < next {
+			next = wait
+		}
+		return next
+	}
+	for {
+		retry, handled, denied := l.dispatchNext(blocked)
+		if handled {
+			continue
+		}
+		if retry > 0 && (next == 0 || retry < next) {
+			next = retry
+		}
+		if denied == "" {
+			if due := l.nextDue(); due > 0 && (next == 0 || due < next) {
+				next = due
+			}
+			return next
+		}
+		if blocked == nil {
+			blocked = make(map[string]bool)
+		}
+		blocked[denied] = true
+	}
+}
+
+// nextDue returns how long until the earliest not-before time of a job submitted
+// with ScheduleAt that is not due yet, or zero if there is none.
+func (l *Limiter) nextDue() time.Duration {
 	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	next := l.delayed.next()
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(l.options().Clock.Now())
+}
+
+// queuedLen returns the number of queued jobs, including those submitted with
+// ScheduleAt that are not due yet. The caller must hold l.mu.
+func (l *Limiter) queuedLen() int {
+	return l.queue.Len() + l.delayed.Len()
+}
+
+// expireQueued fails the queued jobs that have waited longer than MaxQueueTime with
+// ErrQueueTimeout. It returns how long until the next queued job expires, or zero.
+func (l *Limiter) expireQueued() time.Duration {
+	opts := l.options()
+	if opts.MaxQueueTime <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	now := opts.Clock.Now()
+	expired, oldest := l.queue.removeExpired(now.Add(-opts.MaxQueueTime))
+	if len(expired) > 0 {
+		l.signalRoom()
+		l.signalIdle()
+	}
+	l.mu.Unlock()
+
+	for _, job := range expired {
+		opts.Logger.Warnf("gothrottle: limiter %q dropped job: %v", opts.ID, ErrQueueTimeout)
+		l.reject(job, ErrQueueTimeout)
+	}
+
+	if oldest.IsZero() {
+		return 0
+	}
+	return oldest.Add(opts.MaxQueueTime).Sub(now)
+}
+
+// dispatchNext takes the next job off the queue, skipping jobs whose datastore ID
+// is in blocked, and executes it if allowed. It returns true if the job was started,
+// dropped or failed. It returns false with a retry delay when the job was denied, or
+// false with zero when there is no job to try. If other queued jobs may use another
+// datastore ID, denied is the ID of the denied job.
+func (l *Limiter) dispatchNext(blocked map[string]bool) (retry time.Duration, handled bool, denied string) {
+	l.mu.Lock()
+	opts := *l.options()
+
+	// Move jobs submitted with ScheduleAt to the queue once they are due
+	for _, job := range l.delayed.popDue(opts.Clock.Now()) {
+		l.queue.PushJob(job)
+	}
 	if l.queue.IsEmpty() || !l.running {
-		l.mu.RUnlock()
-		return
+		l.mu.Unlock()
+		return 0, false, ""
+	}
+
+	keyed := l.keyedSeen
+
+	// Let long-waiting jobs catch up with newer, higher priority ones. Boosts come in
+	// whole intervals, so re-aging the queue once per interval is enough.
+	if opts.PriorityAging > 0 {
+		if now := opts.Clock.Now(); now.Sub(l.agedAt) >= opts.PriorityAging || now.Before(l.agedAt) {
+			l.queue.Age(now, opts.PriorityAging)
+			l.agedAt = now
+		}
 	}
 
-	// Peek at the next job without removing it
-	job := l.queue.PopJob()
+	// Take the job holding a reservation, or else the next job, off the queue
+	var eligible func(job *Job) bool
+	if len(blocked) > 0 {
+		eligible = func(job *Job) bool { return !blocked[opts.storeID(job)] }
+	}
+	job := l.takeReserved(&opts, eligible)
+	switch {
+	case job != nil:
+	case len(opts.Tiers) > 0:
+		job = l.tiers.pop(l.queue, opts.Tiers, eligible)
+	case len(opts.PriorityShares) > 0:
+		job = l.bands.pop(l.queue, eligible)
+	case eligible != nil:
+		job = l.queue.popFirst(eligible)
+	default:
+		job = l.queue.PopJob()
+	}
 	if job == nil {
-		l.mu.RUnlock()
-		return
+		l.mu.Unlock()
+		return 0, false, ""
+	}
+	l.inflight++
+	l.mu.Unlock()
+
+	// Drop jobs whose caller has already gone away
+	if job.ctx.Err() != nil {
+		opts.Logger.Warnf("gothrottle: limiter %q dropped job: %v", opts.ID, job.ctx.Err())
+		l.reject(job, job.ctx.Err())
+		l.finish()
+		return 0, true, ""
+	}
+
+	// Drop jobs that have waited too long to still be useful
+	if opts.MaxQueueTime > 0 && opts.Clock.Now().Sub(job.enqueuedAt) > opts.MaxQueueTime {
+		opts.Logger.Warnf("gothrottle: limiter %q dropped job: %v", opts.ID, ErrQueueTimeout)
+		l.reject(job, ErrQueueTimeout)
+		l.finish()
+		return 0, true, ""
+	}
+
+	// Fail jobs that can never fit, e.g. after MaxConcurrent was lowered
+	if !opts.weightFits(job.Weight) {
+		l.reject(job, ErrWeightExceedsLimit)
+		l.finish()
+		return 0, true, ""
+	}
+
+	// Enforce the per-process caps before asking the datastore. A local job
+	// completing will wake the scheduler, so no retry delay is needed.
+	if !l.reserveJob(job, &opts) {
+		l.requeue(job)
+		l.reserve(job, &opts)
+		return 0, false, ""
+	}
+
+	// Fail jobs while the circuit breaker is open, without taking a slot
+	if !l.breaker.admit(&opts, job) {
+		l.releaseJob(job)
+		l.reject(job, ErrCircuitOpen)
+		l.finish()
+		return 0, true, ""
 	}
-	l.mu.RUnlock()
 
 	// Check if job can run
-	canRun, waitTime, err := l.datastore.Request(l.opts.ID, job.Weight, l.opts)
+	storeID := opts.storeID(job)
+	storeOpts := opts
+	storeOpts.MaxConcurrent = l.aimd.maxConcurrent(&opts, job.Weight)
+	canRun, waitTime, err := l.datastore.Request(storeID, job.Weight, storeOpts)
 	if err != nil {
-		job.errorChan <- fmt.Errorf("datastore error: %w", err)
-		return
+		l.breaker.unadmit(job)
+		l.releaseJob(job)
+		l.storeError("request", err)
+
+		// Give transient datastore failures a chance to clear
+		if job.storeRetries < opts.DatastoreMaxRetries {
+			job.storeRetries++
+			l.requeue(job)
+
+			if opts.DatastoreRetryBackoff > 0 {
+				return opts.DatastoreRetryBackoff, false, ""
+			}
+			return opts.pollInterval(), false, ""
+		}
+
+		l.reject(job, fmt.Errorf("datastore error: %w", err))
+		l.finish()
+		return 0, true, ""
 	}
 
 	if !canRun {
 		// Put job back in queue
-		l.mu.Lock()
-		l.queue.PushJob(job)
-		l.mu.Unlock()
+		l.breaker.unadmit(job)
+		l.releaseJob(job)
+		l.requeue(job)
+		l.reserve(job, &opts)
+		opts.Hooks.rejected(job, waitTime)
 
-		// Sleep if wait time is suggested
+		// Retry after the suggested wait time, spread by MinTimeJitter. Without one,
+		// a local completion will wake the scheduler, but slots freed by other
+		// instances sharing the datastore can only be noticed by polling.
 		if waitTime > 0 {
-			time.Sleep(waitTime)
+			waitTime = spread(waitTime, opts.MinTimeJitter, opts.pollInterval())
+		} else {
+			waitTime = opts.pollInterval()
 		}
-		return
+		opts.Logger.Debugf("gothrottle: limiter %q denied job (priority %d, weight %d), retrying in %v", storeID, job.Priority, job.Weight, waitTime)
+		if keyed {
+			return waitTime, false, storeID
+		}
+		return waitTime, false, ""
 	}
 
 	// Execute job asynchronously
+	opts.Logger.Debugf("gothrottle: limiter %q started job (priority %d, weight %d) after %v", opts.ID, job.Priority, job.Weight, opts.Clock.Now().Sub(job.enqueuedAt))
+	l.endWait(job)
+	l.stats.running.Add(1)
+	l.mu.Lock()
+	if len(opts.Tiers) > 0 {
+		l.tiers.charge(job, opts.Tiers)
+	} else if len(opts.PriorityShares) > 0 {
+		l.bands.charge(job, opts.PriorityShares)
+	}
+	l.signalRoom()
+	l.mu.Unlock()
 	go l.executeJob(job)
+	return 0, true, ""
+}
+
+// takeReserved removes the job holding a reservation under ReservePriority from the
+// queue and returns it, or returns nil if there is none, it has left the queue or it
+// is not eligible. The caller must hold l.mu and gives the job a new reservation if
+// it is denied again.
+func (l *Limiter) takeReserved(opts *Options, eligible func(job *Job) bool) *Job {
+	job := l.reserved
+	if job == nil || opts.ReservePriority == 0 || (eligible != nil && !eligible(job)) {
+		return nil
+	}
+	l.reserved = nil
+	if !l.queue.RemoveJob(job) {
+		return nil
+	}
+	return job
+}
+
+// reserve gives a denied job that was put back in the queue a reservation, if it may
+// hold one and no other job does.
+func (l *Limiter) reserve(job *Job, opts *Options) {
+	if !opts.reserves(job) {
+		return
+	}
+	l.mu.Lock()
+	if l.reserved == nil {
+		l.reserved = job
+	}
+	l.mu.Unlock()
+}
+
+// dispatchDirect runs job in the calling goroutine, bypassing the queue and the
+// scheduler, if the limiter acts as a pure semaphore (see Options.semaphore),
+// nothing is queued and the datastore grants a slot right away. A datastore error
+// counts towards DatastoreMaxRetries and fails the job once they are used up.
+// It returns false if the job has to be enqueued instead.
+func (l *Limiter) dispatchDirect(job *Job) bool {
+	l.mu.Lock()
+	opts := *l.options()
+	if !l.running || l.manual || !l.queue.IsEmpty() || !opts.semaphore() || l.cooldown() > 0 || opts.CircuitBreaker != nil ||
+		job.Weight <= 0 || !opts.weightFits(job.Weight) || !opts.priorityInRange(job.Priority) {
+		l.mu.Unlock()
+		return false
+	}
+	l.inflight++
+	l.mu.Unlock()
+
+	granted := false
+	if l.reserveJob(job, &opts) {
+		storeOpts := opts
+		storeOpts.MaxConcurrent = l.aimd.maxConcurrent(&opts, job.Weight)
+		canRun, _, err := l.datastore.Request(opts.storeID(job), job.Weight, storeOpts)
+		if err != nil {
+			l.storeError("request", err)
+			if job.storeRetries >= opts.DatastoreMaxRetries {
+				l.releaseJob(job)
+				l.reject(job, fmt.Errorf("datastore error: %w", err))
+				l.finish()
+				return true
+			}
+			// The scheduler retries the job once it is queued
+			job.storeRetries++
+		}
+		granted = err == nil && canRun
+		if !granted {
+			l.releaseJob(job)
+		}
+	}
+	if !granted {
+		l.mu.Lock()
+		l.inflight--
+		l.signalIdle()
+		l.mu.Unlock()
+		return false
+	}
+
+	l.mu.Lock()
+	l.busy = true
+	l.mu.Unlock()
+
+	l.startWait(job)
+	opts.Logger.Debugf("gothrottle: limiter %q started job (priority %d, weight %d) without queueing", opts.ID, job.Priority, job.Weight)
+	l.endWait(job)
+	l.stats.running.Add(1)
+	l.executeJob(job)
+	return true
+}
+
+// reserveJob reserves job's weight under LocalMaxConcurrent and a task under
+// MaxDispatchGoroutines, or neither if either is at its limit.
+func (l *Limiter) reserveJob(job *Job, opts *Options) bool {
+	if !l.reserveLocal(job.Weight, opts.LocalMaxConcurrent) {
+		return false
+	}
+	if !reserveUpTo(&l.tasks, 1, opts.MaxDispatchGoroutines) {
+		l.releaseLocal(job.Weight)
+		return false
+	}
+	return true
+}
+
+// releaseJob releases what reserveJob reserved for a job that did not start.
+func (l *Limiter) releaseJob(job *Job) {
+	l.releaseLocal(job.Weight)
+	l.tasks.Add(-1)
+}
+
+// reserveLocal adds weight to the weight running in this process if it stays
+// within limit, or unconditionally if limit is not positive.
+func (l *Limiter) reserveLocal(weight, limit int) bool {
+	return reserveUpTo(&l.localWeight, weight, limit)
+}
+
+// reserveUpTo adds n to counter if it stays within limit, or unconditionally if
+// limit is not positive.
+func reserveUpTo(counter *atomic.Int64, n, limit int) bool {
+	for {
+		current := counter.Load()
+		if limit > 0 && int(current)+n > limit {
+			return false
+		}
+		if counter.CompareAndSwap(current, current+int64(n)) {
+			return true
+		}
+	}
+}
+
+// releaseLocal removes weight reserved with reserveLocal.
+func (l *Limiter) releaseLocal(weight int) {
+	l.localWeight.Add(-int64(weight))
+}
+
+// releasePartial gives back weight of a running job early, see JobHandle.Release.
+func (l *Limiter) releasePartial(job *Job, weight int) error {
+	err := registerDonePartial(l.datastore, l.options().storeID(job), weight)
+	l.releaseLocal(weight)
+
+	// Slots were freed, so queued jobs may be able to run
+	l.notify()
+	return err
+}
+
+// requeue puts a job taken off the queue by dispatchNext back in the queue.
+func (l *Limiter) requeue(job *Job) {
+	l.mu.Lock()
+	l.queue.PushJob(job)
+	l.inflight--
+	l.mu.Unlock()
+}
+
+// finish marks a job taken off the queue by dispatchNext as finished.
+func (l *Limiter) finish() {
+	l.mu.Lock()
+	l.inflight--
+	l.signalRoom()
+	l.signalIdle()
+	l.mu.Unlock()
+}
+
+// signalRoom wakes submitters blocked at HighWater. The caller must hold l.mu.
+func (l *Limiter) signalRoom() {
+	if l.roomCh != nil {
+		close(l.roomCh)
+		l.roomCh = nil
+	}
+}
+
+// signalIdle wakes WaitUntilIdle callers if no job is queued or in flight, and
+// notifies IdleNotify subscribers if the limiter was busy until now.
+// The caller must hold l.mu.
+func (l *Limiter) signalIdle() {
+	if l.inflight == 0 && l.queuedLen() == 0 {
+		close(l.idleCh)
+		l.idleCh = make(chan struct{})
+
+		if l.busy {
+			l.busy = false
+			for _, sub := range l.idleSubs {
+				select {
+				case sub <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// reject completes a job that will never run with err.
+func (l *Limiter) reject(job *Job, err error) {
+	l.endWait(job)
+	l.stats.rejected.Add(1)
+	job.complete(nil, err)
+}
+
+// storeError logs a datastore failure and reports it to the OnStoreError callback.
+func (l *Limiter) storeError(op string, err error) {
+	opts := l.options()
+	err = fmt.Errorf("datastore %s error: %w", op, err)
+	opts.Logger.Warnf("gothrottle: limiter %q: %v", opts.ID, err)
+	if opts.OnStoreError != nil {
+		opts.OnStoreError(err)
+	}
+}
+
+// startWait records the enqueue time of a job and opens its queue-wait span.
+func (l *Limiter) startWait(job *Job) {
+	job.enqueuedAt = l.options().Clock.Now()
+	tracer := trace.SpanFromContext(job.ctx).TracerProvider().Tracer(tracerName)
+	_, job.waitSpan = tracer.Start(job.ctx, "gothrottle.wait", trace.WithAttributes(jobAttributes(job)...))
+}
+
+// endWait closes the queue-wait span of a job and reports the time it spent queued.
+func (l *Limiter) endWait(job *Job) {
+	if job.waitSpan == nil {
+		return
+	}
+	opts := l.options()
+	wait := opts.Clock.Now().Sub(job.enqueuedAt)
+	job.waitSpan.End()
+	job.waitSpan = nil
+
+	if onQueueWait := opts.OnQueueWait; onQueueWait != nil {
+		onQueueWait(wait, job.Priority, job.Weight)
+	}
+}
+
+// jobAttributes returns the span attributes describing a job.
+func jobAttributes(job *Job) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("gothrottle.priority", job.Priority),
+		attribute.Int("gothrottle.weight", job.Weight),
+	}
 }
 
 // executeJob runs a job and handles its completion.
 func (l *Limiter) executeJob(job *Job) {
+	job.start()
 	defer func() {
+		// Weight released early with JobHandle.Release is no longer held
+		weight := job.stop()
+		l.tasks.Add(-1)
+
+		// Leave the completion to the next batch flush if batching is enabled,
+		// but let jobs held back by MaxDispatchGoroutines run now
+		if l.batchDone(l.options().storeID(job), weight) {
+			l.releaseLocal(weight)
+			l.notify()
+			return
+		}
+
 		// Register job completion
-		if err := l.datastore.RegisterDone(l.opts.ID, job.Weight); err != nil {
-			// Log error but don't fail the job
-			// In a real implementation, you might want to use a logger here
-			_ = err
+		if weight > 0 {
+			if err := l.datastore.RegisterDone(l.options().storeID(job), weight); err != nil {
+				// Report error but don't fail the job
+				l.storeError("register done", err)
+			}
 		}
+		l.releaseLocal(weight)
+
+		// A slot was freed, so queued jobs may be able to run
+		l.finish()
+		l.notify()
 	}()
 
-	// Execute the job
-	result, err := job.Task()
+	tracer := trace.SpanFromContext(job.ctx).TracerProvider().Tracer(tracerName)
+	_, span := tracer.Start(job.ctx, "gothrottle.execute", trace.WithAttributes(jobAttributes(job)...))
+	defer span.End()
 
-	// Send result back
+	// Execute the job
+	opts := l.options()
+	opts.Hooks.started(job)
+	startedAt := opts.Clock.Now()
+	result, err := runTask(job.Task)
+	opts.Hooks.done(opts.Clock.Now().Sub(startedAt), err)
+	l.aimd.record(l.options(), err == nil)
+	l.breaker.record(l.options(), job, err == nil)
+	l.stats.running.Add(-1)
 	if err != nil {
-		select {
-		case job.errorChan <- err:
-		default:
-		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		l.stats.failed.Add(1)
 	} else {
-		select {
-		case job.resultChan <- result:
-		default:
-		}
+		l.stats.completed.Add(1)
 	}
+
+	// Send result back
+	job.complete(result, err)
+}
+
+// runTask executes a task, converting a panic into an ErrTaskPanicked error carrying
+// the recovered value and the stack trace.
+func runTask(task func() (interface{}, error)) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = fmt.Errorf("%w: %v\n%s", ErrTaskPanicked, r, debug.Stack())
+		}
+	}()
+	return task()
 }
 
 // processRemainingJobs processes any remaining jobs when stopping.
 func (l *Limiter) processRemainingJobs() {
+	dropped := 0
 	for {
-		l.mu.RLock()
-		if l.queue.IsEmpty() {
-			l.mu.RUnlock()
-			break
-		}
-
+		l.mu.Lock()
 		job := l.queue.PopJob()
-		l.mu.RUnlock()
+		if job == nil {
+			// Jobs that are not due yet are dropped too
+			job = l.delayed.popJob()
+		}
+		l.mu.Unlock()
 
 		if job == nil {
 			break
 		}
 
 		// Cancel remaining jobs
-		job.errorChan <- ErrStoreClosed
+		opts := l.options()
+		opts.Logger.Warnf("gothrottle: limiter %q dropped job: %v", opts.ID, ErrStoreClosed)
+		l.reject(job, ErrStoreClosed)
+		dropped++
 	}
+
+	l.mu.Lock()
+	l.signalIdle()
+	l.mu.Unlock()
+
+	opts := l.options()
+	opts.Logger.Infof("gothrottle: limiter %q stopped, %d queued jobs dropped", opts.ID, dropped)
 }
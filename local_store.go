@@ -2,100 +2,373 @@
 package gothrottle
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// LocalStore is an in-memory implementation of Datastore.
+// localShards is the number of stripes the state of a LocalStore is split into.
+const localShards = 32
+
+// LocalStore is an in-memory implementation of Datastore. Its state is striped
+// by limiter ID, so limiters with different IDs rarely contend for a lock.
 type LocalStore struct {
-	mu     sync.RWMutex
-	state  map[string]*LocalState
-	closed bool
+	shards [localShards]localShard
+	closed atomic.Bool
+
+	// stopCh stops the idle sweeper started by NewLocalStoreWithTTL, if any
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// localShard holds the state of the limiter IDs hashed to it. Its state is nil
+// once the store is disconnected.
+type localShard struct {
+	mu    sync.RWMutex
+	state map[string]*LocalState
 }
 
 // LocalState holds the state for a single limiter.
 type LocalState struct {
 	running   int
 	lastStart time.Time
+	tat       time.Time   // theoretical arrival time of the next job under AlgorithmGCRA
+	strict    bool        // StrictAccounting of the last Request
+	blocked   time.Time   // end of the cooldown set with SetCooldown, if any
+	clock     Clock       // Options.Clock of the last Request, nil before the first
+	starts    []time.Time // start times within the trailing window, oldest first
 }
 
 // NewLocalStore creates a new LocalStore instance.
 func NewLocalStore() *LocalStore {
-	return &LocalStore{
-		state: make(map[string]*LocalState),
+	ls := &LocalStore{}
+	for i := range ls.shards {
+		ls.shards[i].state = make(map[string]*LocalState)
+	}
+	return ls
+}
+
+// shard returns the stripe holding the state of limiterID, by FNV-1a hash.
+func (ls *LocalStore) shard(limiterID string) *localShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(limiterID); i++ {
+		h ^= uint32(limiterID[i])
+		h *= 16777619
+	}
+	return &ls.shards[h%localShards]
+}
+
+// NewLocalStoreWithTTL creates a LocalStore that forgets the state of a limiter once
+// it has no running jobs and its last job started more than idle ago, so that
+// per-user or other short-lived limiter IDs do not accumulate forever. idle must
+// exceed the MinTime and WindowDuration of the limiters using the store, otherwise
+// they are enforced from scratch after eviction. A background goroutine checks for
+// idle limiters every idle/2 of wall-clock time until Disconnect is called, while
+// idleness is measured on the Options.Clock of each limiter's last request.
+func NewLocalStoreWithTTL(idle time.Duration) *LocalStore {
+	ls := NewLocalStore()
+	if idle > 0 {
+		ls.stopCh = make(chan struct{})
+		go ls.sweep(idle)
+	}
+	return ls
+}
+
+// sweep periodically evicts limiters that have been idle for longer than idle.
+func (ls *LocalStore) sweep(idle time.Duration) {
+	interval := idle / 2
+	if interval <= 0 {
+		interval = idle
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ls.stopCh:
+			return
+		case <-ticker.C:
+			ls.evictIdle(idle)
+		}
+	}
+}
+
+// evictIdle removes the state of limiters without running jobs whose last job
+// started, and whose cooldown ended, more than idle ago on the limiter's clock.
+func (ls *LocalStore) evictIdle(idle time.Duration) {
+	for i := range ls.shards {
+		shard := &ls.shards[i]
+		shard.mu.Lock()
+		for id, state := range shard.state {
+			clock := state.clock
+			if clock == nil {
+				clock = realClock{}
+			}
+			cutoff := clock.Now().Add(-idle)
+			if state.running == 0 && state.lastStart.Before(cutoff) && state.blocked.Before(cutoff) {
+				delete(shard.state, id)
+			}
+		}
+		shard.mu.Unlock()
 	}
 }
 
 // Request checks if a job can run according to the limiter's rules.
 func (ls *LocalStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
+	shard := ls.shard(limiterID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	if ls.closed {
+	if shard.state == nil {
 		return false, 0, ErrStoreClosed
 	}
 
-	state, exists := ls.state[limiterID]
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	state, exists := shard.state[limiterID]
 	if !exists {
 		state = &LocalState{
 			running:   0,
 			lastStart: time.Time{},
 		}
-		ls.state[limiterID] = state
+		shard.state[limiterID] = state
+	}
+
+	state.clock = opts.clock()
+	now := state.clock.Now()
+	state.strict = opts.StrictAccounting
+
+	windowed := opts.WindowLimit > 0 && opts.WindowDuration > 0
+	if windowed {
+		state.starts = state.starts[expiredStarts(state.starts, now, opts.WindowDuration):]
+	}
+
+	canRun, waitTime, tat := admit(state, weight, &opts, now)
+	if !canRun {
+		return false, waitTime, nil
+	}
+
+	// Job can run - update state
+	state.running += weight
+	state.lastStart = now
+	if opts.gcra() {
+		state.tat = tat
+	}
+	if windowed {
+		state.starts = append(state.starts, now)
+	}
+
+	return true, 0, nil
+}
+
+// Peek reports whether a job of the given weight could run now, and if not how long
+// to wait, without acquiring a slot or otherwise changing the limiter's state.
+func (ls *LocalStore) Peek(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	shard := ls.shard(limiterID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	if shard.state == nil {
+		return false, 0, ErrStoreClosed
+	}
+
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	state, exists := shard.state[limiterID]
+	if !exists {
+		return true, 0, nil
+	}
+
+	now := opts.clock().Now()
+	live := *state
+	if opts.WindowLimit > 0 && opts.WindowDuration > 0 {
+		live.starts = state.starts[expiredStarts(state.starts, now, opts.WindowDuration):]
+	}
+
+	canRun, waitTime, _ = admit(&live, weight, &opts, now)
+	return canRun, waitTime, nil
+}
+
+// CurrentUsage returns the weight running for the limiter.
+func (ls *LocalStore) CurrentUsage(limiterID string) (int, error) {
+	shard := ls.shard(limiterID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	if shard.state == nil {
+		return 0, ErrStoreClosed
+	}
+	if state, exists := shard.state[limiterID]; exists {
+		return state.running, nil
+	}
+	return 0, nil
+}
+
+// SetCooldown denies every job of the limiter until until. A zero until lifts the cooldown.
+func (ls *LocalStore) SetCooldown(limiterID string, until time.Time) error {
+	shard := ls.shard(limiterID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.state == nil {
+		return ErrStoreClosed
+	}
+
+	state, exists := shard.state[limiterID]
+	if !exists {
+		state = &LocalState{}
+		shard.state[limiterID] = state
+	}
+	state.blocked = until
+	return nil
+}
+
+// Snapshot returns a copy of the running weight and last start of every limiter ID
+// in the store, or nil once the store is disconnected. Each shard is copied under
+// its read lock, so the snapshot is consistent per ID but not across IDs.
+func (ls *LocalStore) Snapshot() map[string]StateSnapshot {
+	if ls.closed.Load() {
+		return nil
+	}
+
+	states := make(map[string]StateSnapshot)
+	for i := range ls.shards {
+		shard := &ls.shards[i]
+		shard.mu.RLock()
+		for id, state := range shard.state {
+			states[id] = StateSnapshot{Running: state.running, LastStart: state.lastStart}
+		}
+		shard.mu.RUnlock()
+	}
+	return states
+}
+
+// Ping returns ErrStoreClosed once the store is disconnected and nil otherwise.
+func (ls *LocalStore) Ping(ctx context.Context) error {
+	if ls.closed.Load() {
+		return ErrStoreClosed
+	}
+	return nil
+}
+
+// Reset deletes the state of the limiter.
+func (ls *LocalStore) Reset(limiterID string) error {
+	shard := ls.shard(limiterID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.state == nil {
+		return ErrStoreClosed
 	}
 
-	now := time.Now()
+	delete(shard.state, limiterID)
+	return nil
+}
+
+// admit applies the limiter's rules to a job of the given weight without changing
+// state, whose window starts must already exclude expired ones. On a grant it returns
+// the GCRA theoretical arrival time to store if opts uses AlgorithmGCRA.
+func admit(state *LocalState, weight int, opts *Options, now time.Time) (canRun bool, waitTime time.Duration, tat time.Time) {
+	// Deny everything during a cooldown
+	if now.Before(state.blocked) {
+		return false, state.blocked.Sub(now), tat
+	}
 
 	// Check max concurrent limit
 	if opts.MaxConcurrent > 0 && state.running+weight > opts.MaxConcurrent {
-		return false, 0, nil
+		return false, 0, tat
+	}
+
+	// Check the GCRA theoretical arrival time
+	if opts.gcra() {
+		tat = state.tat
+		if tat.Before(now) {
+			tat = now
+		}
+		if allowAt := tat.Add(-opts.burstTolerance(weight)); now.Before(allowAt) {
+			return false, allowAt.Sub(now) + jitter(opts.Jitter), tat
+		}
+		tat = tat.Add(time.Duration(weight) * opts.MinTime)
 	}
 
 	// Check min time between jobs
-	if opts.MinTime > 0 && !state.lastStart.IsZero() {
+	if opts.MinTime > 0 && !opts.gcra() && !state.lastStart.IsZero() {
 		elapsed := now.Sub(state.lastStart)
 		if elapsed < opts.MinTime {
-			waitTime = opts.MinTime - elapsed
-			return false, waitTime, nil
+			return false, opts.MinTime - elapsed + jitter(opts.Jitter), tat
 		}
 	}
 
-	// Job can run - update state
-	state.running += weight
-	state.lastStart = now
+	// Check sliding window limit
+	if opts.WindowLimit > 0 && opts.WindowDuration > 0 && len(state.starts) >= opts.WindowLimit {
+		return false, opts.WindowDuration - now.Sub(state.starts[0]) + jitter(opts.Jitter), tat
+	}
 
-	return true, 0, nil
+	return true, 0, tat
+}
+
+// expiredStarts returns how many of the oldest starts fell out of the trailing window.
+func expiredStarts(starts []time.Time, now time.Time, window time.Duration) int {
+	expired := 0
+	for expired < len(starts) && now.Sub(starts[expired]) >= window {
+		expired++
+	}
+	return expired
 }
 
 // RegisterDone informs the store that a job has finished.
 func (ls *LocalStore) RegisterDone(limiterID string, weight int) error {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
+	shard := ls.shard(limiterID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	if ls.closed {
+	if shard.state == nil {
 		return ErrStoreClosed
 	}
 
-	state, exists := ls.state[limiterID]
+	state, exists := shard.state[limiterID]
 	if !exists {
 		return nil // Nothing to do
 	}
 
+	running := state.running
 	state.running -= weight
 	if state.running < 0 {
 		state.running = 0
+		if state.strict {
+			return fmt.Errorf("%w: limiter %q released weight %d with %d running", ErrAccountingMismatch, limiterID, weight, running)
+		}
 	}
 
 	return nil
 }
 
+// RegisterDonePartial releases part of the weight of a job that keeps running.
+// LocalStore only counts running weight, so this is the same as RegisterDone.
+func (ls *LocalStore) RegisterDonePartial(limiterID string, weight int) error {
+	return ls.RegisterDone(limiterID, weight)
+}
+
 // Disconnect cleans up any connections.
 func (ls *LocalStore) Disconnect() error {
-	ls.mu.Lock()
-	defer ls.mu.Unlock()
-
-	ls.closed = true
-	ls.state = nil
+	ls.stopOnce.Do(func() {
+		if ls.stopCh != nil {
+			close(ls.stopCh)
+		}
+	})
+	ls.closed.Store(true)
+	for i := range ls.shards {
+		shard := &ls.shards[i]
+		shard.mu.Lock()
+		shard.state = nil
+		shard.mu.Unlock()
+	}
 
 	return nil
 }
@@ -0,0 +1,43 @@
+// FILENAME: logger.go
+package gothrottle
+
+import "log"
+
+// Logger is the interface used by the Limiter to report diagnostics.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// noopLogger discards all log messages.
+type noopLogger struct{}
+
+func (noopLogger) Debugf(format string, args ...interface{}) {}
+func (noopLogger) Infof(format string, args ...interface{})  {}
+func (noopLogger) Warnf(format string, args ...interface{})  {}
+func (noopLogger) Errorf(format string, args ...interface{}) {}
+
+// stdLogger adapts a standard library *log.Logger to Logger.
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger returns a Logger writing to l with a level prefix on each message.
+// If l is nil, the standard library's default logger is used.
+func NewStdLogger(l *log.Logger) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return stdLogger{logger: l}
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) { s.logf("DEBUG", format, args...) }
+func (s stdLogger) Infof(format string, args ...interface{})  { s.logf("INFO", format, args...) }
+func (s stdLogger) Warnf(format string, args ...interface{})  { s.logf("WARN", format, args...) }
+func (s stdLogger) Errorf(format string, args ...interface{}) { s.logf("ERROR", format, args...) }
+
+func (s stdLogger) logf(level, format string, args ...interface{}) {
+	s.logger.Printf(level+" "+format, args...)
+}
@@ -0,0 +1,26 @@
+// FILENAME: manual.go
+package gothrottle
+
+import "time"
+
+// newManualLimiter creates a Limiter whose queued jobs only start when tick is
+// called, for the package's own tests that assert the exact dispatch order. Jobs
+// are always queued, even by Schedule on an idle semaphore, and nothing is retried
+// on a timer: a job denied by the datastore waits for the next tick. Stop fails
+// queued jobs as usual.
+func newManualLimiter(opts Options) (*Limiter, error) {
+	return newLimiter(opts, true)
+}
+
+// tick runs one scheduling pass of a limiter created with newManualLimiter: it
+// starts queued jobs in priority order for as long as the datastore allows. Like
+// processJobs, it returns how long the scheduler would wait before the next pass:
+// the datastore's wait time, PollInterval for a job denied by MaxConcurrent, or
+// zero if nothing was denied. tick returns once the jobs have been started, not
+// when they finish. It must not be called on other limiters, whose scheduler runs
+// on its own.
+func (l *Limiter) tick() time.Duration {
+	l.tickMu.Lock()
+	defer l.tickMu.Unlock()
+	return l.processJobs()
+}
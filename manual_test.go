@@ -0,0 +1,110 @@
+package gothrottle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManualLimiter_TickPriorityOrder(t *testing.T) {
+	limiter, err := newManualLimiter(Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var order []int
+	var mu sync.Mutex
+	handles := make(map[int]*JobHandle)
+	for _, priority := range []int{1, 10, 5} {
+		priority := priority
+		handles[priority] = limiter.SubmitWithOptions(func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			return nil, nil
+		}, priority, 1)
+	}
+
+	// Nothing starts until the test ticks
+	select {
+	case <-handles[10].Done():
+		t.Fatal("Expected no job to start before tick")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// A tick starts the next job once the previous one has released its slot, and
+	// may start several if they finish quickly, so tick until each one is done
+	for _, priority := range []int{10, 5, 1} {
+		for done := false; !done; {
+			limiter.tick()
+			select {
+			case <-handles[priority].Done():
+				done = true
+			case <-time.After(time.Millisecond):
+			}
+		}
+		if _, err := handles[priority].Wait(); err != nil {
+			t.Fatalf("Job with priority %d failed: %v", priority, err)
+		}
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(order) != "[10 5 1]" {
+		t.Errorf("Expected jobs to run in priority order [10 5 1], got %v", order)
+	}
+}
+
+func TestManualLimiter_TickMaxConcurrent(t *testing.T) {
+	limiter, err := newManualLimiter(Options{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(2)
+	var handles []*JobHandle
+	for i := 0; i < 3; i++ {
+		handles = append(handles, limiter.Submit(func() (interface{}, error) {
+			started.Done()
+			<-release
+			return nil, nil
+		}))
+	}
+
+	if retry := limiter.tick(); retry != defaultPollInterval {
+		t.Errorf("Expected the third job to be denied with retry %v, got %v", defaultPollInterval, retry)
+	}
+	started.Wait()
+	if stats := limiter.Stats(); stats.Queued != 1 {
+		t.Errorf("Expected 1 queued job after the first tick, got %d", stats.Queued)
+	}
+
+	// The queued job only starts on a tick after a slot is released
+	started.Add(1)
+	close(release)
+	for _, handle := range handles[:2] {
+		if _, err := handle.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for done := false; !done; {
+		limiter.tick()
+		select {
+		case <-handles[2].Done():
+			done = true
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
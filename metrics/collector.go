@@ -0,0 +1,83 @@
+// FILENAME: collector.go
+
+// Package metrics exports gothrottle limiter statistics to Prometheus.
+package metrics
+
+import (
+	"sync"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queuedDesc = prometheus.NewDesc(
+		"gothrottle_queued_jobs",
+		"Number of jobs waiting to start.",
+		[]string{"limiter"}, nil,
+	)
+	runningDesc = prometheus.NewDesc(
+		"gothrottle_running_jobs",
+		"Number of jobs currently executing.",
+		[]string{"limiter"}, nil,
+	)
+	completedDesc = prometheus.NewDesc(
+		"gothrottle_completed_jobs_total",
+		"Total number of jobs whose task returned without error.",
+		[]string{"limiter"}, nil,
+	)
+	failedDesc = prometheus.NewDesc(
+		"gothrottle_failed_jobs_total",
+		"Total number of jobs whose task returned an error or panicked.",
+		[]string{"limiter"}, nil,
+	)
+	rejectedDesc = prometheus.NewDesc(
+		"gothrottle_rejected_jobs_total",
+		"Total number of jobs that never ran.",
+		[]string{"limiter"}, nil,
+	)
+)
+
+// Collector is a prometheus.Collector reporting the Stats of one or more limiters,
+// labelled by limiter ID.
+type Collector struct {
+	mu       sync.RWMutex
+	limiters []*gothrottle.Limiter
+}
+
+// NewCollector creates a Collector for the given limiters.
+func NewCollector(limiters ...*gothrottle.Limiter) *Collector {
+	return &Collector{limiters: limiters}
+}
+
+// Add starts reporting the given limiters.
+func (c *Collector) Add(limiters ...*gothrottle.Limiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limiters = append(c.limiters, limiters...)
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queuedDesc
+	ch <- runningDesc
+	ch <- completedDesc
+	ch <- failedDesc
+	ch <- rejectedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, l := range c.limiters {
+		stats := l.Stats()
+		ch <- prometheus.MustNewConstMetric(queuedDesc, prometheus.GaugeValue, float64(stats.Queued), stats.ID)
+		ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, float64(stats.Running), stats.ID)
+		ch <- prometheus.MustNewConstMetric(completedDesc, prometheus.CounterValue, float64(stats.Completed), stats.ID)
+		ch <- prometheus.MustNewConstMetric(failedDesc, prometheus.CounterValue, float64(stats.Failed), stats.ID)
+		ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(stats.Rejected), stats.ID)
+	}
+}
@@ -0,0 +1,57 @@
+// FILENAME: collector_test.go
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	api, err := gothrottle.NewLimiter(gothrottle.Options{ID: "api", Datastore: gothrottle.NewLocalStore(), MaxConcurrent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = api.Stop() }() // Ignore error in test cleanup
+
+	db, err := gothrottle.NewLimiter(gothrottle.Options{ID: "db", Datastore: gothrottle.NewLocalStore(), MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Stop() }() // Ignore error in test cleanup
+
+	for i := 0; i < 3; i++ {
+		if _, err := api.Schedule(func() (interface{}, error) { return nil, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = db.Schedule(func() (interface{}, error) { return nil, errors.New("boom") })
+	_, _ = db.ScheduleWithOptions(func() (interface{}, error) { return nil, nil }, 5, 2)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics.NewCollector(api, db))
+
+	expected := `
+# HELP gothrottle_completed_jobs_total Total number of jobs whose task returned without error.
+# TYPE gothrottle_completed_jobs_total counter
+gothrottle_completed_jobs_total{limiter="api"} 3
+gothrottle_completed_jobs_total{limiter="db"} 0
+# HELP gothrottle_failed_jobs_total Total number of jobs whose task returned an error or panicked.
+# TYPE gothrottle_failed_jobs_total counter
+gothrottle_failed_jobs_total{limiter="api"} 0
+gothrottle_failed_jobs_total{limiter="db"} 1
+# HELP gothrottle_rejected_jobs_total Total number of jobs that never ran.
+# TYPE gothrottle_rejected_jobs_total counter
+gothrottle_rejected_jobs_total{limiter="api"} 0
+gothrottle_rejected_jobs_total{limiter="db"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"gothrottle_completed_jobs_total", "gothrottle_failed_jobs_total", "gothrottle_rejected_jobs_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,7 +1,10 @@
 // FILENAME: options.go
 package gothrottle
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Options holds the configuration for a Limiter.
 type Options struct {
@@ -9,5 +12,336 @@ type Options struct {
 	MaxConcurrent int           // Max number of jobs running at once.
 	MinTime       time.Duration // Minimum time between jobs.
 	Datastore     Datastore     // Optional datastore for clustering. Defaults to local if nil.
-	// Future fields like HighWater, Strategy, etc. can be added here.
+	Logger        Logger        // Optional logger for diagnostics. Defaults to a no-op logger if nil.
+	PriorityAging time.Duration // If set, a queued job gains +1 priority per interval waited to prevent starvation. Disabled if zero.
+	MinPriority   int           // Lowest accepted job priority. Bounds are checked only if MinPriority or MaxPriority is non-zero.
+	MaxPriority   int           // Highest accepted job priority. No upper bound if zero.
+	HighWater     int           // Max number of queued jobs. Unlimited if zero.
+	Strategy      Strategy      // What to do when the queue is at HighWater. Defaults to StrategyBlock.
+	MaxQueueTime  time.Duration // Jobs queued longer than this fail with ErrQueueTimeout instead of running. Unlimited if zero.
+
+	// ReservePriority, if non-zero, lets a denied job heavier than 1 with at least this
+	// priority reserve capacity: it is tried before every other job until it starts, so
+	// lighter jobs, even of higher priority, wait until enough weight is free for it.
+	// This keeps heavy jobs from starving under a stream of light ones, at the cost of
+	// leaving capacity idle while running jobs drain. Only one job holds a reservation
+	// at a time, and it only holds within this process.
+	ReservePriority int
+
+	// LocalMaxConcurrent caps the weight running in this process, checked before the datastore
+	// is asked, so one instance can't win every slot of a shared limiter. Unlimited if zero.
+	LocalMaxConcurrent int
+
+	// MaxDispatchGoroutines caps the number of tasks executing at once in this process,
+	// whatever their weight and independently of the datastore, so that a limiter without
+	// MaxConcurrent can't start a goroutine for every job in a flood. Unlimited if zero.
+	MaxDispatchGoroutines int
+
+	// WindowLimit is the maximum number of jobs that may start within any trailing
+	// WindowDuration, allowing bursts unlike MinTime. Disabled unless both are set.
+	WindowLimit    int
+	WindowDuration time.Duration
+
+	// Jitter adds a random delay in [0, Jitter) to the wait time returned for MinTime and
+	// window denials, so instances sharing a limiter don't all retry at the same instant.
+	Jitter time.Duration
+
+	// MinTimeJitter moves the time the scheduler sleeps after a denial with a suggested wait
+	// by a random amount in [-MinTimeJitter, MinTimeJitter], so instances sharing a limiter
+	// don't wake in lockstep. Waking early only costs a denied retry; the datastore still
+	// enforces MinTime. Unlike Jitter it needs no datastore support.
+	MinTimeJitter time.Duration
+
+	// PollInterval is how long the scheduler waits before retrying a job the datastore
+	// denied without a suggested wait, e.g. at MaxConcurrent. Local completions wake the
+	// scheduler at once, but slots freed by other instances sharing the datastore are
+	// only noticed by polling. A longer interval saves CPU and datastore round trips, a
+	// shorter one starts jobs sooner. It is also the shortest wait MinTimeJitter can
+	// shorten a suggested wait to. Defaults to 10ms if zero.
+	PollInterval time.Duration
+
+	// DatastoreMaxRetries is how many times a job is requeued after a datastore Request error
+	// before it fails. Defaults to 0, which fails the job on the first error.
+	DatastoreMaxRetries int
+	// DatastoreRetryBackoff is how long to wait before retrying after a datastore Request error.
+	// Defaults to PollInterval if zero.
+	DatastoreRetryBackoff time.Duration
+
+	// DoneFlushInterval, if set, makes the limiter release finished jobs in the datastore
+	// in batches, with one BatchRegisterDone call per limiter ID every interval, to cut
+	// round trips under high throughput. Until the flush the datastore counts finished
+	// jobs as running, so up to an interval's worth of completions hold their slots a
+	// little longer. Ignored unless the datastore implements BatchRegisterer, and with
+	// StaleTimeout, whose slots have to be released one by one.
+	DoneFlushInterval time.Duration
+
+	// Adaptive, if set, adjusts the concurrency limit between its bounds from task
+	// outcomes, starting at MaxConcurrent. See AdaptiveConcurrency.
+	Adaptive *AdaptiveConcurrency
+
+	// CircuitBreaker, if set, fails jobs with ErrCircuitOpen instead of running them
+	// after consecutive task errors. See CircuitBreaker.
+	CircuitBreaker *CircuitBreaker
+
+	// Algorithm selects how MinTime is enforced. Defaults to AlgorithmMinTime.
+	Algorithm Algorithm
+	// Burst is how many weight units AlgorithmGCRA lets start at once after an idle period.
+	// Defaults to 1, which allows no burst.
+	Burst int
+
+	// Tiers, if set, splits the limiter's capacity between classes of jobs by share
+	// instead of strict priority. See Tier.
+	Tiers []Tier
+
+	// PriorityShares, if set, replaces strict priority with weighted fair queuing
+	// across priority levels: when jobs of several priorities are queued, each level
+	// is granted weight in proportion to its share, so higher priorities go first
+	// more often but lower ones are not starved. Levels without an entry have a
+	// share of 1. Within a level jobs run in submission order. It cannot be combined
+	// with Tiers.
+	PriorityShares map[int]int
+
+	// Cache stores results for ScheduleCached. Defaults to a MemoryCache owned by the limiter.
+	Cache Cache
+
+	// StrictAccounting makes LocalStore's RegisterDone return ErrAccountingMismatch instead of
+	// silently clamping at zero when more weight is released than is running. The limiter
+	// reports it through Logger and OnStoreError like any other RegisterDone error.
+	StrictAccounting bool
+
+	// KeyTTL is how long RedisStore keeps a limiter's state after the last granted job.
+	// It must exceed MinTime, otherwise the state can expire between jobs and MinTime is ignored.
+	// Defaults to DefaultKeyTTL (30s) if zero, or twice MinTime if that is longer.
+	KeyTTL time.Duration
+	// StaleTimeout makes RedisStore release a slot that has been held this long, assuming
+	// the instance that acquired it crashed before RegisterDone. It must exceed the longest
+	// job, and only helps if it is shorter than KeyTTL. JobHandle.Release is not supported
+	// with it. Defaults to 0, which disables it.
+	StaleTimeout time.Duration
+
+	// Clock is the source of time for the scheduler, LocalStore, batched RegisterDone,
+	// retry backoffs and the limiter's own result cache, see Clock. Defaults to the
+	// system clock. Set it to a fake clock, such as clocktest.FakeClock, to test
+	// time-dependent behavior deterministically. It cannot be changed by UpdateOptions.
+	Clock Clock
+
+	// OnStoreError, if set, is called whenever a datastore Request or RegisterDone call fails.
+	// A failed RegisterDone may leak a concurrency slot in distributed mode, so it is worth alerting on.
+	OnStoreError func(err error)
+
+	// OnGoError, if set, is called with the error of each job started with Go, which has
+	// no caller to return it to. It runs on the scheduler or the job's goroutine, so it
+	// must not block.
+	OnGoError func(err error)
+
+	// OnQueueWait, if set, is called with the time a job spent queued before it started or was dropped.
+	OnQueueWait func(wait time.Duration, priority, weight int)
+
+	// Hooks, if set, are called on job lifecycle events. See Hooks.
+	Hooks *Hooks
+}
+
+// Validate reports settings that are out of range or contradict each other, such as
+// negative limits or durations, a MinPriority above MaxPriority, or a WindowLimit
+// without a WindowDuration. The error wraps ErrInvalidOptions and names the field,
+// except for invalid Tiers, which return ErrInvalidTier. NewLimiter and UpdateOptions
+// call it, so it is only needed to check options ahead of time.
+func (o Options) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"MaxConcurrent", o.MaxConcurrent},
+		{"LocalMaxConcurrent", o.LocalMaxConcurrent},
+		{"MaxDispatchGoroutines", o.MaxDispatchGoroutines},
+		{"HighWater", o.HighWater},
+		{"WindowLimit", o.WindowLimit},
+		{"Burst", o.Burst},
+		{"DatastoreMaxRetries", o.DatastoreMaxRetries},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%w: %s must not be negative", ErrInvalidOptions, f.name)
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"MinTime", o.MinTime},
+		{"PriorityAging", o.PriorityAging},
+		{"MaxQueueTime", o.MaxQueueTime},
+		{"WindowDuration", o.WindowDuration},
+		{"Jitter", o.Jitter},
+		{"MinTimeJitter", o.MinTimeJitter},
+		{"PollInterval", o.PollInterval},
+		{"DatastoreRetryBackoff", o.DatastoreRetryBackoff},
+		{"DoneFlushInterval", o.DoneFlushInterval},
+		{"KeyTTL", o.KeyTTL},
+		{"StaleTimeout", o.StaleTimeout},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%w: %s must not be negative", ErrInvalidOptions, f.name)
+		}
+	}
+
+	if o.MaxPriority != 0 && o.MinPriority > o.MaxPriority {
+		return fmt.Errorf("%w: MinPriority %d exceeds MaxPriority %d", ErrInvalidOptions, o.MinPriority, o.MaxPriority)
+	}
+	if (o.WindowLimit > 0) != (o.WindowDuration > 0) {
+		return fmt.Errorf("%w: WindowLimit and WindowDuration must be set together", ErrInvalidOptions)
+	}
+	if o.KeyTTL > 0 && o.KeyTTL <= o.MinTime {
+		return fmt.Errorf("%w: KeyTTL must exceed MinTime", ErrInvalidOptions)
+	}
+	if o.Strategy < StrategyBlock || o.Strategy > StrategyDropOldest {
+		return fmt.Errorf("%w: unknown Strategy %d", ErrInvalidOptions, o.Strategy)
+	}
+	if o.Algorithm < AlgorithmMinTime || o.Algorithm > AlgorithmGCRA {
+		return fmt.Errorf("%w: unknown Algorithm %d", ErrInvalidOptions, o.Algorithm)
+	}
+	if a := o.Adaptive; a != nil {
+		if a.MinLimit < 0 || a.MaxLimit < 0 {
+			return fmt.Errorf("%w: Adaptive limits must not be negative", ErrInvalidOptions)
+		}
+		if a.MaxLimit > 0 && a.MinLimit > a.MaxLimit {
+			return fmt.Errorf("%w: Adaptive.MinLimit %d exceeds MaxLimit %d", ErrInvalidOptions, a.MinLimit, a.MaxLimit)
+		}
+		if a.Backoff < 0 || a.Backoff >= 1 {
+			return fmt.Errorf("%w: Adaptive.Backoff must be between 0 and 1", ErrInvalidOptions)
+		}
+	}
+	if b := o.CircuitBreaker; b != nil {
+		if b.FailureThreshold < 0 || b.OpenDuration < 0 || b.HalfOpenProbes < 0 {
+			return fmt.Errorf("%w: CircuitBreaker settings must not be negative", ErrInvalidOptions)
+		}
+	}
+	for priority, share := range o.PriorityShares {
+		if share <= 0 {
+			return fmt.Errorf("%w: PriorityShares of priority %d must be positive", ErrInvalidOptions, priority)
+		}
+	}
+	if len(o.PriorityShares) > 0 && len(o.Tiers) > 0 {
+		return fmt.Errorf("%w: PriorityShares and Tiers cannot be combined", ErrInvalidOptions)
+	}
+	return validateTiers(o.Tiers)
 }
+
+// keyTTL returns KeyTTL, or if it is not set DefaultKeyTTL, raised to twice MinTime
+// so that the state outlives the gap between jobs.
+func (o *Options) keyTTL() time.Duration {
+	if o.KeyTTL > 0 {
+		return o.KeyTTL
+	}
+	if 2*o.MinTime > DefaultKeyTTL {
+		return 2 * o.MinTime
+	}
+	return DefaultKeyTTL
+}
+
+// pollInterval returns PollInterval, or defaultPollInterval if it is not set.
+func (o *Options) pollInterval() time.Duration {
+	if o.PollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return o.PollInterval
+}
+
+// clock returns Clock, or the system clock if it is not set.
+func (o *Options) clock() Clock {
+	if o.Clock == nil {
+		return realClock{}
+	}
+	return o.Clock
+}
+
+// reserves reports whether job may reserve capacity once it is denied, see ReservePriority.
+func (o *Options) reserves(job *Job) bool {
+	return o.ReservePriority != 0 && job.Weight > 1 && job.Priority >= o.ReservePriority
+}
+
+// weightFits reports whether a job of the given weight can ever run under
+// MaxConcurrent and LocalMaxConcurrent.
+func (o *Options) weightFits(weight int) bool {
+	if o.MaxConcurrent > 0 && weight > o.MaxConcurrent {
+		return false
+	}
+	return o.LocalMaxConcurrent <= 0 || weight <= o.LocalMaxConcurrent
+}
+
+// priorityInRange reports whether priority is within MinPriority and MaxPriority.
+func (o *Options) priorityInRange(priority int) bool {
+	if o.MinPriority == 0 && o.MaxPriority == 0 {
+		return true
+	}
+	if priority < o.MinPriority {
+		return false
+	}
+	return o.MaxPriority == 0 || priority <= o.MaxPriority
+}
+
+// storeID returns the limiter ID under which job is tracked in the datastore.
+// Jobs submitted with ScheduleKeyed get their own ID per key.
+func (o *Options) storeID(job *Job) string {
+	if job.key == "" {
+		return o.ID
+	}
+	return o.ID + ":" + job.key
+}
+
+// hasTier reports whether Tiers contains a tier with the given name.
+func (o *Options) hasTier(name string) bool {
+	for _, tier := range o.Tiers {
+		if tier.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// semaphore reports whether jobs are only limited by how many run at once, so that
+// a free slot can be handed out without going through the scheduler.
+func (o *Options) semaphore() bool {
+	return o.MinTime <= 0 && (o.WindowLimit <= 0 || o.WindowDuration <= 0) && len(o.Tiers) == 0 && len(o.PriorityShares) == 0
+}
+
+// gcra reports whether MinTime is enforced with the generic cell rate algorithm.
+func (o *Options) gcra() bool {
+	return o.Algorithm == AlgorithmGCRA && o.MinTime > 0
+}
+
+// burstTolerance returns how far ahead of the theoretical arrival time a job of the
+// given weight may start under AlgorithmGCRA, so that at most Burst weight units
+// start at once. A job heavier than Burst has to wait until the limiter is idle.
+func (o *Options) burstTolerance(weight int) time.Duration {
+	if o.Burst <= weight {
+		return 0
+	}
+	return time.Duration(o.Burst-weight) * o.MinTime
+}
+
+// Algorithm selects how a datastore spaces jobs by MinTime.
+type Algorithm int
+
+const (
+	// AlgorithmMinTime starts a job no sooner than MinTime after the previous one started.
+	// A job that starts late pushes back all the jobs after it.
+	AlgorithmMinTime Algorithm = iota
+	// AlgorithmGCRA uses the generic cell rate algorithm: each job moves a theoretical
+	// arrival time (TAT) ahead by MinTime per unit of weight, and a job may start once the
+	// TAT is no more than the Burst tolerance ahead. Late starts do not move the TAT, so the
+	// sustained rate is exactly one weight unit per MinTime. Supported by LocalStore and RedisStore.
+	AlgorithmGCRA
+)
+
+// Strategy decides what happens to a new job when the queue is at HighWater.
+type Strategy int
+
+const (
+	// StrategyBlock makes the submitter wait until there is room in the queue.
+	StrategyBlock Strategy = iota
+	// StrategyReject fails the new job with ErrQueueFull.
+	StrategyReject
+	// StrategyDropOldest evicts the lowest priority queued job, the oldest among
+	// equal priorities, failing it with ErrDropped.
+	StrategyDropOldest
+)
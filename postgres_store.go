@@ -0,0 +1,220 @@
+// FILENAME: postgres_store.go
+package gothrottle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultPostgresTable is the table used by PostgresStore when no name is given.
+const DefaultPostgresTable = "throttle_state"
+
+// tableNamePattern matches plain and schema-qualified SQL identifiers.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PostgresStore is a PostgreSQL-based implementation of Datastore. Each limiter ID is
+// a single row holding its running weight and last start time, locked with
+// SELECT ... FOR UPDATE while a Request checks and updates it. Requests for the same
+// ID are therefore serialized by PostgreSQL, while different IDs don't contend.
+// RegisterDone is a single UPDATE, which runs in its own transaction.
+// Only MaxConcurrent, MinTime and Jitter are supported; Request fails with an error
+// wrapping ErrInvalidOptions if WindowLimit, AlgorithmGCRA or StaleTimeout is set.
+type PostgresStore struct {
+	db     *sql.DB
+	table  string
+	mu     sync.RWMutex // guards closed
+	closed bool
+
+	// Statements built once from the table name
+	insertQuery string
+	selectQuery string
+	updateQuery string
+	doneQuery   string
+	usageQuery  string
+	resetQuery  string
+}
+
+// NewPostgresStore creates a new PostgresStore using db, which must be a PostgreSQL
+// connection pool. tableName may be schema-qualified and defaults to
+// DefaultPostgresTable if empty. The table must exist; see CreateTable.
+func NewPostgresStore(db *sql.DB, tableName string) (*PostgresStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("postgres store requires a database")
+	}
+	if tableName == "" {
+		tableName = DefaultPostgresTable
+	}
+	if !tableNamePattern.MatchString(tableName) {
+		return nil, fmt.Errorf("invalid postgres table name %q", tableName)
+	}
+
+	return &PostgresStore{
+		db:          db,
+		table:       tableName,
+		insertQuery: fmt.Sprintf("INSERT INTO %s (id) VALUES ($1) ON CONFLICT (id) DO NOTHING", tableName),
+		selectQuery: fmt.Sprintf("SELECT running, last_start FROM %s WHERE id = $1 FOR UPDATE", tableName),
+		updateQuery: fmt.Sprintf("UPDATE %s SET running = running + $2, last_start = $3 WHERE id = $1", tableName),
+		doneQuery:   fmt.Sprintf("UPDATE %s SET running = GREATEST(running - $2, 0) WHERE id = $1", tableName),
+		usageQuery:  fmt.Sprintf("SELECT running FROM %s WHERE id = $1", tableName),
+		resetQuery:  fmt.Sprintf("DELETE FROM %s WHERE id = $1", tableName),
+	}, nil
+}
+
+// CreateTable creates the store's table if it does not exist yet.
+func (ps *PostgresStore) CreateTable() error {
+	_, err := ps.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id         TEXT PRIMARY KEY,
+	running    INTEGER NOT NULL DEFAULT 0,
+	last_start BIGINT  NOT NULL DEFAULT 0 -- Unix microseconds
+)`, ps.table))
+	if err != nil {
+		return fmt.Errorf("postgres create table error: %w", err)
+	}
+	return nil
+}
+
+// isClosed reports whether Disconnect has been called.
+func (ps *PostgresStore) isClosed() bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.closed
+}
+
+// Request checks if a job can run according to the limiter's rules.
+func (ps *PostgresStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	if ps.isClosed() {
+		return false, 0, ErrStoreClosed
+	}
+
+	if err := postgresSupports(&opts); err != nil {
+		return false, 0, err
+	}
+
+	// A job heavier than the limit would be denied forever
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	ctx := context.Background()
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("postgres begin error: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }() // No-op after a successful commit
+
+	if _, err := tx.ExecContext(ctx, ps.insertQuery, limiterID); err != nil {
+		return false, 0, fmt.Errorf("postgres insert error: %w", err)
+	}
+
+	var running int
+	var lastStartUs int64
+	if err := tx.QueryRowContext(ctx, ps.selectQuery, limiterID).Scan(&running, &lastStartUs); err != nil {
+		return false, 0, fmt.Errorf("postgres select error: %w", err)
+	}
+
+	// Check max concurrent limit
+	if opts.MaxConcurrent > 0 && running+weight > opts.MaxConcurrent {
+		return false, 0, nil
+	}
+
+	// Check min time between jobs, in microseconds so that jobs can't start early by
+	// up to a millisecond
+	nowUs := time.Now().UnixMicro()
+	if elapsed := time.Duration(nowUs-lastStartUs) * time.Microsecond; opts.MinTime > 0 && elapsed < opts.MinTime {
+		return false, opts.MinTime - elapsed + jitter(opts.Jitter), nil
+	}
+
+	// Job can run - update state
+	if _, err := tx.ExecContext(ctx, ps.updateQuery, limiterID, weight, nowUs); err != nil {
+		return false, 0, fmt.Errorf("postgres update error: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("postgres commit error: %w", err)
+	}
+
+	return true, 0, nil
+}
+
+// postgresSupports returns an error wrapping ErrInvalidOptions if opts use a feature
+// PostgresStore does not implement, rather than silently ignoring it.
+func postgresSupports(opts *Options) error {
+	switch {
+	case opts.WindowLimit > 0 || opts.WindowDuration > 0:
+		return fmt.Errorf("%w: PostgresStore does not support WindowLimit", ErrInvalidOptions)
+	case opts.gcra():
+		return fmt.Errorf("%w: PostgresStore does not support AlgorithmGCRA", ErrInvalidOptions)
+	case opts.StaleTimeout > 0:
+		return fmt.Errorf("%w: PostgresStore does not support StaleTimeout", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// RegisterDone informs the store that a job has finished.
+func (ps *PostgresStore) RegisterDone(limiterID string, weight int) error {
+	if ps.isClosed() {
+		return ErrStoreClosed
+	}
+
+	if _, err := ps.db.Exec(ps.doneQuery, limiterID, weight); err != nil {
+		return fmt.Errorf("postgres update error: %w", err)
+	}
+	return nil
+}
+
+// RegisterDonePartial releases part of the weight of a job that keeps running.
+// PostgresStore only counts running weight, so this is the same as RegisterDone.
+func (ps *PostgresStore) RegisterDonePartial(limiterID string, weight int) error {
+	return ps.RegisterDone(limiterID, weight)
+}
+
+// CurrentUsage reads the running weight from the limiter's row.
+func (ps *PostgresStore) CurrentUsage(limiterID string) (int, error) {
+	if ps.isClosed() {
+		return 0, ErrStoreClosed
+	}
+
+	var running int
+	err := ps.db.QueryRow(ps.usageQuery, limiterID).Scan(&running)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("postgres select error: %w", err)
+	}
+	return running, nil
+}
+
+// Reset deletes the limiter's row.
+func (ps *PostgresStore) Reset(limiterID string) error {
+	if ps.isClosed() {
+		return ErrStoreClosed
+	}
+
+	if _, err := ps.db.Exec(ps.resetQuery, limiterID); err != nil {
+		return fmt.Errorf("postgres delete error: %w", err)
+	}
+	return nil
+}
+
+// Ping checks the connection to PostgreSQL.
+func (ps *PostgresStore) Ping(ctx context.Context) error {
+	if ps.isClosed() {
+		return ErrStoreClosed
+	}
+	return ps.db.PingContext(ctx)
+}
+
+// Disconnect marks the store as closed. The *sql.DB is owned by the caller and
+// is not closed.
+func (ps *PostgresStore) Disconnect() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.closed = true
+	return nil
+}
@@ -4,7 +4,13 @@ package gothrottle
 import (
 	"context"
 	"crypto/sha1" // #nosec G505 - SHA1 is used for Redis script hashing, not cryptographic security
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,48 +18,307 @@ import (
 
 // RedisStore is a Redis-based implementation of Datastore.
 type RedisStore struct {
-	client     *redis.Client
-	scriptSHA  string
+	mu         sync.RWMutex      // guards scriptSHAs
+	scriptSHAs map[string]string // Lua script source -> SHA
 	ctx        context.Context
 	cancelFunc context.CancelFunc
+
+	connMu    sync.RWMutex // guards the fields below
+	client    redis.UniversalClient
+	newClient func() redis.UniversalClient // nil unless created by NewRedisStoreFunc
+	state     RedisConnState
+	backoff   time.Duration // wait after the last failed reconnect
+	retryAt   time.Time     // earliest next reconnect
+
+	reconnecting bool // set while a caller dials a new client, without holding connMu
+}
+
+// RedisConnState is the state of a RedisStore's connection, as last observed.
+type RedisConnState int
+
+const (
+	// RedisConnected means the last command reached Redis.
+	RedisConnected RedisConnState = iota
+	// RedisDisconnected means the last command failed with a connection error.
+	RedisDisconnected
+	// RedisClosed means the store was disconnected with Disconnect.
+	RedisClosed
+)
+
+// String returns the name of the state.
+func (s RedisConnState) String() string {
+	switch s {
+	case RedisConnected:
+		return "connected"
+	case RedisDisconnected:
+		return "disconnected"
+	case RedisClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("RedisConnState(%d)", int(s))
+	}
 }
 
+// Bounds of the exponential backoff between reconnects of a store created by
+// NewRedisStoreFunc.
+const (
+	redisMinReconnectBackoff = 100 * time.Millisecond
+	redisMaxReconnectBackoff = 10 * time.Second
+)
+
 // NewRedisStore creates a new RedisStore instance.
 func NewRedisStore(client *redis.Client) (*RedisStore, error) {
+	return newRedisStore(client)
+}
+
+// NewRedisClusterStore creates a RedisStore backed by a Redis Cluster. All keys of a
+// limiter share a hash tag, so they live in the same slot and the Lua script can
+// access them atomically.
+func NewRedisClusterStore(client *redis.ClusterClient) (*RedisStore, error) {
+	return newRedisStore(client)
+}
+
+// NewRedisUniversalStore creates a RedisStore on any go-redis client, including a
+// failover client for Redis Sentinel. After a failover the new primary may not have
+// the Lua script cached; it is reloaded transparently on the next Request.
+func NewRedisUniversalStore(client redis.UniversalClient) (*RedisStore, error) {
+	return newRedisStore(client)
+}
+
+// NewRedisStoreFunc creates a RedisStore on a client made by newClient. go-redis
+// redials dropped connections on its own, but a client that was closed or points at
+// a stale address never recovers. When a command fails with a connection error, the
+// store calls newClient again on the next command, with exponential backoff between
+// failed attempts from 100ms up to 10s, and replaces its client once the new one
+// answers a PING and has the Lua scripts loaded within 1s. Meanwhile other commands
+// fail fast on the old client. The old client is closed.
+func NewRedisStoreFunc(newClient func() redis.UniversalClient) (*RedisStore, error) {
+	rs, err := newRedisStore(newClient())
+	if err != nil {
+		return nil, err
+	}
+	rs.newClient = newClient
+	return rs, nil
+}
+
+// newRedisStore creates a RedisStore on any kind of Redis client.
+func newRedisStore(client redis.UniversalClient) (*RedisStore, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	rs := &RedisStore{
 		client:     client,
+		scriptSHAs: make(map[string]string),
 		ctx:        ctx,
 		cancelFunc: cancel,
 	}
 
-	// Load the Lua script
-	if err := rs.loadScript(); err != nil {
+	if err := rs.loadScripts(ctx, client); err != nil {
 		cancel()
-		return nil, fmt.Errorf("failed to load Lua script: %w", err)
+		return nil, err
 	}
 
 	return rs, nil
 }
 
-// The Lua script MUST be this exact script:
+// loadScripts loads every Lua script of the store into Redis through client.
+func (rs *RedisStore) loadScripts(ctx context.Context, client redis.UniversalClient) error {
+	for _, script := range []string{redisScript, redisPeekScript, redisDoneScript, redisCooldownScript} {
+		if err := rs.loadScript(ctx, client, script); err != nil {
+			return fmt.Errorf("failed to load Lua script: %w", err)
+		}
+	}
+	return nil
+}
+
+// State returns the connection state as observed by the last command.
+func (rs *RedisStore) State() RedisConnState {
+	rs.connMu.RLock()
+	defer rs.connMu.RUnlock()
+	return rs.state
+}
+
+// conn returns the client to run a command on, or nil once the store is closed. A
+// store created by NewRedisStoreFunc that lost its connection reconnects first when
+// its backoff has passed.
+func (rs *RedisStore) conn() redis.UniversalClient {
+	rs.connMu.RLock()
+	client := rs.client
+	reconnect := rs.state == RedisDisconnected && rs.newClient != nil && !time.Now().Before(rs.retryAt)
+	rs.connMu.RUnlock()
+
+	if reconnect {
+		return rs.reconnect()
+	}
+	return client
+}
+
+// reconnect replaces the client with a new one from newClient. The new client is
+// pinged and loaded with the scripts within healthCheckTimeout and without holding
+// connMu, so that other commands fail fast on the old client meanwhile rather than
+// wait for a hung connection. If the new client fails, it is closed, the old client
+// is kept and the next attempt is backed off.
+func (rs *RedisStore) reconnect() redis.UniversalClient {
+	rs.connMu.Lock()
+	// Another caller may be reconnecting, have reconnected or closed the store
+	if rs.state != RedisDisconnected || rs.reconnecting || time.Now().Before(rs.retryAt) {
+		client := rs.client
+		rs.connMu.Unlock()
+		return client
+	}
+	rs.reconnecting = true
+	rs.connMu.Unlock()
+
+	client := rs.newClient()
+	ctx, cancel := context.WithTimeout(rs.ctx, healthCheckTimeout)
+	err := client.Ping(ctx).Err()
+	if err == nil {
+		err = rs.loadScripts(ctx, client)
+	}
+	cancel()
+
+	rs.connMu.Lock()
+	defer rs.connMu.Unlock()
+	rs.reconnecting = false
+
+	// Disconnect closed the store while the new client was dialed
+	if rs.state == RedisClosed {
+		_ = client.Close() // Best effort, the client is discarded
+		return nil
+	}
+	if err != nil {
+		_ = client.Close() // Best effort, the client is discarded
+		rs.backoff *= 2
+		if rs.backoff < redisMinReconnectBackoff {
+			rs.backoff = redisMinReconnectBackoff
+		}
+		if rs.backoff > redisMaxReconnectBackoff {
+			rs.backoff = redisMaxReconnectBackoff
+		}
+		rs.retryAt = time.Now().Add(rs.backoff)
+		return rs.client
+	}
+
+	_ = rs.client.Close() // Best effort, the connection is already broken
+	rs.client = client
+	rs.state = RedisConnected
+	rs.backoff = 0
+	return client
+}
+
+// observe records the connection state seen by a command that returned err.
+func (rs *RedisStore) observe(err error) {
+	// A caller giving up says nothing about the connection
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	state := RedisConnected
+	if isConnErr(err) {
+		state = RedisDisconnected
+	}
+
+	rs.connMu.RLock()
+	unchanged := rs.state == state || rs.state == RedisClosed
+	rs.connMu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	rs.connMu.Lock()
+	if rs.state != RedisClosed && rs.state != state {
+		rs.state = state
+		rs.retryAt = time.Now() // The first reconnect is not delayed
+	}
+	rs.connMu.Unlock()
+}
+
+// isConnErr reports whether err means Redis could not be reached, rather than
+// Redis answering with an error.
+func isConnErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, redis.ErrClosed) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// DefaultKeyTTL is how long a limiter's Redis key lives after the last granted job
+// when Options.KeyTTL is not set, unless twice MinTime is longer.
+const DefaultKeyTTL = 30 * time.Second
+
+// redisScript atomically checks and acquires a slot for a job.
+//
+// KEYS: [1] the limiter's hash (running, last_start, last_start_us, tat,
+// blocked_until and bookkeeping fields), [2] the sorted set of start times within
+// the sliding window, [3] the sorted set of slots tracked for StaleTimeout.
+//
+// ARGV: [1] MaxConcurrent, [2] MinTime in ms, [3] job weight, [4] current time in ms,
+// [5] key TTL in ms, [6] WindowLimit, [7] WindowDuration in ms, [8] jitter in ms added
+// to suggested waits, [9] StaleTimeout in ms, [10] GCRA emission interval in µs, 0
+// unless AlgorithmGCRA, [11] GCRA burst tolerance in µs, [12] current time in µs.
+//
+// It returns {1, 0} when the job may start, {0, wait_ms} when it must wait that long,
+// and {0, -1} when it has to wait for running jobs to finish.
 const redisScript = `
 local key = KEYS[1]
+local window_key = KEYS[2]
+local slots_key = KEYS[3]
 local max_concurrent = tonumber(ARGV[1])
 local min_time_ms = tonumber(ARGV[2])
 local weight = tonumber(ARGV[3])
 local current_time_ms = tonumber(ARGV[4])
+local key_ttl_ms = tonumber(ARGV[5])
+local window_limit = tonumber(ARGV[6])
+local window_ms = tonumber(ARGV[7])
+local jitter_ms = tonumber(ARGV[8])
+local stale_ms = tonumber(ARGV[9])
+local emission_us = tonumber(ARGV[10])
+local tolerance_us = tonumber(ARGV[11])
+local current_time_us = tonumber(ARGV[12])
 
 local state = redis.call("HGETALL", key)
 local running = 0
 local last_start = 0
+local last_start_us = nil
+local tat = 0
+local blocked_until = 0
 
 for i = 1, #state, 2 do
     if state[i] == "running" then
         running = tonumber(state[i+1])
     elseif state[i] == "last_start" then
         last_start = tonumber(state[i+1])
+    elseif state[i] == "last_start_us" then
+        last_start_us = tonumber(state[i+1])
+    elseif state[i] == "tat" then
+        tat = tonumber(state[i+1])
+    elseif state[i] == "blocked_until" then
+        blocked_until = tonumber(state[i+1])
+    end
+end
+
+-- Deny everything during a cooldown
+if blocked_until > current_time_ms then
+    return {0, blocked_until - current_time_ms}
+end
+
+-- Release slots held longer than the stale timeout, presumably by a crashed instance
+if stale_ms > 0 then
+    local stale = redis.call("ZRANGEBYSCORE", slots_key, "-inf", current_time_ms - stale_ms)
+    if #stale > 0 then
+        for _, slot in ipairs(stale) do
+            running = running - tonumber(string.match(slot, "^(%d+):"))
+        end
+        if running < 0 then
+            running = 0
+        end
+        redis.call("ZREMRANGEBYSCORE", slots_key, "-inf", current_time_ms - stale_ms)
+        redis.call("HSET", key, "running", running)
     end
 end
 
@@ -61,59 +326,346 @@ if max_concurrent > 0 and running + weight > max_concurrent then
     return {0, -1}
 end
 
-local elapsed = current_time_ms - last_start
-if min_time_ms > 0 and elapsed < min_time_ms then
-    local wait = min_time_ms - elapsed
-    return {0, wait}
+-- Measure MinTime in microseconds, so that jobs granted in different but adjacent
+-- milliseconds cannot start up to a millisecond early. Keys written before
+-- last_start_us existed only have the start in milliseconds.
+if min_time_ms > 0 then
+    local elapsed_us = current_time_us - (last_start_us or last_start * 1000)
+    if elapsed_us < min_time_ms * 1000 then
+        return {0, math.ceil((min_time_ms * 1000 - elapsed_us) / 1000) + jitter_ms}
+    end
+end
+
+-- GCRA: the theoretical arrival time (TAT) advances by the emission interval per
+-- unit of weight and a job may start once now is within the burst tolerance of it
+if emission_us > 0 then
+    tat = math.max(tat, current_time_us)
+    local allow_at_us = tat - tolerance_us
+    if current_time_us < allow_at_us then
+        return {0, math.ceil((allow_at_us - current_time_us) / 1000) + jitter_ms}
+    end
+end
+
+local windowed = window_limit > 0 and window_ms > 0
+if windowed then
+    redis.call("ZREMRANGEBYSCORE", window_key, "-inf", current_time_ms - window_ms)
+    if redis.call("ZCARD", window_key) >= window_limit then
+        local oldest = redis.call("ZRANGE", window_key, 0, 0, "WITHSCORES")
+        local wait = tonumber(oldest[2]) + window_ms - current_time_ms
+        if wait < 1 then
+            wait = 1
+        end
+        return {0, wait + jitter_ms}
+    end
 end
 
 redis.call("HINCRBY", key, "running", weight)
-redis.call("HSET", key, "last_start", current_time_ms)
-redis.call("PEXPIRE", key, 30000)
+-- last_start_us is stored from the raw argument to keep every digit
+redis.call("HSET", key, "last_start", current_time_ms, "last_start_us", ARGV[12], "key_ttl_ms", key_ttl_ms, "stale_ms", stale_ms)
+redis.call("PEXPIRE", key, key_ttl_ms)
+
+if emission_us > 0 then
+    redis.call("HSET", key, "tat", tat + emission_us * weight)
+end
+
+if stale_ms > 0 then
+    local seq = redis.call("HINCRBY", key, "slot_seq", 1)
+    redis.call("ZADD", slots_key, current_time_ms, weight .. ":" .. seq)
+    redis.call("PEXPIRE", slots_key, key_ttl_ms)
+end
+
+if windowed then
+    local seq = redis.call("HINCRBY", key, "window_seq", 1)
+    redis.call("ZADD", window_key, current_time_ms, current_time_ms .. "-" .. seq)
+    redis.call("PEXPIRE", window_key, math.max(window_ms, key_ttl_ms))
+end
+
+return {1, 0}
+`
+
+// redisPeekScript applies the same rules as redisScript with the same keys and
+// arguments, but only reads: stale slots and expired window entries are left for the
+// next Request to remove and are merely discounted here.
+const redisPeekScript = `
+local key = KEYS[1]
+local window_key = KEYS[2]
+local slots_key = KEYS[3]
+local max_concurrent = tonumber(ARGV[1])
+local min_time_ms = tonumber(ARGV[2])
+local weight = tonumber(ARGV[3])
+local current_time_ms = tonumber(ARGV[4])
+local window_limit = tonumber(ARGV[6])
+local window_ms = tonumber(ARGV[7])
+local jitter_ms = tonumber(ARGV[8])
+local stale_ms = tonumber(ARGV[9])
+local emission_us = tonumber(ARGV[10])
+local tolerance_us = tonumber(ARGV[11])
+local current_time_us = tonumber(ARGV[12])
+
+local running = tonumber(redis.call("HGET", key, "running") or "0")
+local last_start = tonumber(redis.call("HGET", key, "last_start") or "0")
+local last_start_us = tonumber(redis.call("HGET", key, "last_start_us") or (last_start * 1000))
+local tat = tonumber(redis.call("HGET", key, "tat") or "0")
+local blocked_until = tonumber(redis.call("HGET", key, "blocked_until") or "0")
+
+if blocked_until > current_time_ms then
+    return {0, blocked_until - current_time_ms}
+end
+
+if stale_ms > 0 then
+    for _, slot in ipairs(redis.call("ZRANGEBYSCORE", slots_key, "-inf", current_time_ms - stale_ms)) do
+        running = running - tonumber(string.match(slot, "^(%d+):"))
+    end
+    if running < 0 then
+        running = 0
+    end
+end
+
+if max_concurrent > 0 and running + weight > max_concurrent then
+    return {0, -1}
+end
+
+if min_time_ms > 0 then
+    local elapsed_us = current_time_us - last_start_us
+    if elapsed_us < min_time_ms * 1000 then
+        return {0, math.ceil((min_time_ms * 1000 - elapsed_us) / 1000) + jitter_ms}
+    end
+end
+
+if emission_us > 0 then
+    tat = math.max(tat, current_time_us)
+    local allow_at_us = tat - tolerance_us
+    if current_time_us < allow_at_us then
+        return {0, math.ceil((allow_at_us - current_time_us) / 1000) + jitter_ms}
+    end
+end
+
+if window_limit > 0 and window_ms > 0 then
+    local cutoff = "(" .. (current_time_ms - window_ms)
+    if redis.call("ZCOUNT", window_key, cutoff, "+inf") >= window_limit then
+        local oldest = redis.call("ZRANGEBYSCORE", window_key, cutoff, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+        local wait = tonumber(oldest[2]) + window_ms - current_time_ms
+        if wait < 1 then
+            wait = 1
+        end
+        return {0, wait + jitter_ms}
+    end
+end
 
 return {1, 0}
 `
 
-// loadScript loads the Lua script into Redis and stores its SHA.
-func (rs *RedisStore) loadScript() error {
-	sha := fmt.Sprintf("%x", sha1.Sum([]byte(redisScript))) // #nosec G401 - SHA1 is used for Redis script hashing, not cryptographic security
+// redisDoneScript releases a job's weight, never letting running drop below zero
+// even if RegisterDone is called more often than Request, and refreshes the key TTL.
+// With a stale timeout it also removes the job's slot, unless the slot was already
+// released as stale. Slots are only told apart by weight, so a partial release could
+// shrink another job's slot; with a stale timeout it is refused and returns -1.
+const redisDoneScript = `
+local key = KEYS[1]
+local slots_key = KEYS[2]
+local weight = tonumber(ARGV[1])
+local default_ttl_ms = tonumber(ARGV[2])
+local partial = ARGV[3] == "1"
+
+-- An expired key has no running jobs left to release
+if redis.call("EXISTS", key) == 0 then
+    return 0
+end
+
+local running = tonumber(redis.call("HGET", key, "running") or "0")
+
+if tonumber(redis.call("HGET", key, "stale_ms") or "0") > 0 then
+    if partial then
+        return -1
+    end
+
+    -- Slots of equal weight are interchangeable, so release the oldest one
+    local released = false
+    for _, slot in ipairs(redis.call("ZRANGE", slots_key, 0, -1)) do
+        local slot_weight = tonumber(string.match(slot, "^(%d+):"))
+        if slot_weight == weight then
+            redis.call("ZREM", slots_key, slot)
+            released = true
+            break
+        end
+    end
+    if not released then
+        return running
+    end
+end
+
+running = running - weight
+if running < 0 then
+    running = 0
+end
+
+-- Keep the TTL the limiter's last Request set, unless a cooldown needs the key longer
+local key_ttl_ms = tonumber(redis.call("HGET", key, "key_ttl_ms") or default_ttl_ms)
+
+redis.call("HSET", key, "running", running)
+if redis.call("PTTL", key) < key_ttl_ms then
+    redis.call("PEXPIRE", key, key_ttl_ms)
+end
+return running
+`
+
+// redisCooldownScript records the end of a cooldown in the limiter's hash, keeping the
+// key alive at least until then, or removes it if the cooldown has already ended.
+const redisCooldownScript = `
+local key = KEYS[1]
+local blocked_until = tonumber(ARGV[1])
+local current_time_ms = tonumber(ARGV[2])
+
+if blocked_until <= current_time_ms then
+    redis.call("HDEL", key, "blocked_until")
+    return 0
+end
+
+redis.call("HSET", key, "blocked_until", blocked_until)
+if redis.call("PTTL", key) < blocked_until - current_time_ms then
+    redis.call("PEXPIRE", key, blocked_until - current_time_ms)
+end
+return 1
+`
+
+// loadScript loads a Lua script into Redis and stores its SHA.
+func (rs *RedisStore) loadScript(ctx context.Context, client redis.UniversalClient, script string) error {
+	sha := scriptSHA(script)
 
 	// Check if script already exists
-	exists, err := rs.client.ScriptExists(rs.ctx, sha).Result()
+	exists, err := client.ScriptExists(ctx, sha).Result()
 	if err != nil {
 		return err
 	}
 
 	if len(exists) > 0 && exists[0] {
-		rs.scriptSHA = sha
+		rs.setScriptSHA(script, sha)
 		return nil
 	}
 
 	// Load the script
-	loadedSHA, err := rs.client.ScriptLoad(rs.ctx, redisScript).Result()
+	loadedSHA, err := client.ScriptLoad(ctx, script).Result()
 	if err != nil {
 		return err
 	}
 
-	rs.scriptSHA = loadedSHA
+	rs.setScriptSHA(script, loadedSHA)
 	return nil
 }
 
+// scriptSHA returns the SHA1 under which Redis caches a Lua script.
+func scriptSHA(script string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(script))) // #nosec G401 - SHA1 is used for Redis script hashing, not cryptographic security
+}
+
+// setScriptSHA stores the SHA of a loaded Lua script.
+func (rs *RedisStore) setScriptSHA(script, sha string) {
+	rs.mu.Lock()
+	rs.scriptSHAs[script] = sha
+	rs.mu.Unlock()
+}
+
+// evalScript runs a Lua script by its SHA. If Redis no longer has the script
+// cached, e.g. after a restart or SCRIPT FLUSH, it falls back to a full EVAL,
+// which caches the script again for the following calls.
+func (rs *RedisStore) evalScript(client redis.UniversalClient, script string, keys []string, args ...interface{}) (interface{}, error) {
+	rs.mu.RLock()
+	sha, ok := rs.scriptSHAs[script]
+	rs.mu.RUnlock()
+
+	if ok {
+		result, err := client.EvalSha(rs.ctx, sha, keys, args...).Result()
+		if err == nil || !isNoScriptErr(err) {
+			rs.observe(err)
+			return result, err
+		}
+	}
+
+	result, err := client.Eval(rs.ctx, script, keys, args...).Result()
+	rs.observe(err)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.setScriptSHA(script, scriptSHA(script))
+	return result, nil
+}
+
+// isNoScriptErr reports whether err is the Redis error for a missing cached script.
+func isNoScriptErr(err error) bool {
+	return strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+// ceilMillis converts d to whole milliseconds, rounding up so that the script never
+// spaces jobs closer than LocalStore would. Truncating would turn a sub-millisecond
+// MinTime into no limit at all.
+func ceilMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// redisKey returns the key holding a limiter's state. The ID is a hash tag so that
+// every key derived from it maps to the same Redis Cluster slot.
+func redisKey(limiterID string) string {
+	return fmt.Sprintf("gothrottle:{%s}", limiterID)
+}
+
 // Request checks if a job can run according to the limiter's rules.
 func (rs *RedisStore) Request(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
-	if rs.client == nil {
+	client := rs.conn()
+	if client == nil {
+		return false, 0, ErrStoreClosed
+	}
+
+	// A job heavier than the limit would be denied forever
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	return rs.evalRequest(client, redisScript, limiterID, weight, opts)
+}
+
+// Peek reports whether a job could run now, and if not how long to wait, by running
+// a read-only variant of the request script that acquires no slot.
+func (rs *RedisStore) Peek(limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	client := rs.conn()
+	if client == nil {
 		return false, 0, ErrStoreClosed
 	}
 
-	key := fmt.Sprintf("gothrottle:%s", limiterID)
-	currentTimeMs := time.Now().UnixMilli()
+	if opts.MaxConcurrent > 0 && weight > opts.MaxConcurrent {
+		return false, 0, ErrWeightExceedsLimit
+	}
+
+	return rs.evalRequest(client, redisPeekScript, limiterID, weight, opts)
+}
 
-	result, err := rs.client.EvalSha(rs.ctx, rs.scriptSHA, []string{key},
+// evalRequest runs redisScript or redisPeekScript for a job and parses the result.
+func (rs *RedisStore) evalRequest(client redis.UniversalClient, script, limiterID string, weight int, opts Options) (canRun bool, waitTime time.Duration, err error) {
+	key := redisKey(limiterID)
+	now := time.Now()
+	currentTimeMs := now.UnixMilli()
+
+	// MinTime is either the minimum gap between starts or the GCRA emission interval
+	minTimeMs, emissionUs := ceilMillis(opts.MinTime), int64(0)
+	if opts.gcra() {
+		minTimeMs, emissionUs = 0, opts.MinTime.Microseconds()
+	}
+
+	result, err := rs.evalScript(client, script, []string{key, key + ":window", key + ":slots"},
 		opts.MaxConcurrent,
-		opts.MinTime.Milliseconds(),
+		minTimeMs,
 		weight,
 		currentTimeMs,
-	).Result()
+		opts.keyTTL().Milliseconds(),
+		opts.WindowLimit,
+		ceilMillis(opts.WindowDuration),
+		// Drawn here rather than with math.random so each instance gets its own value
+		jitter(opts.Jitter).Milliseconds(),
+		ceilMillis(opts.StaleTimeout),
+		emissionUs,
+		opts.burstTolerance(weight).Microseconds(),
+		now.UnixMicro(),
+	)
 
 	if err != nil {
 		return false, 0, fmt.Errorf("redis eval error: %w", err)
@@ -145,26 +697,212 @@ func (rs *RedisStore) Request(limiterID string, weight int, opts Options) (canRu
 
 // RegisterDone informs the store that a job has finished.
 func (rs *RedisStore) RegisterDone(limiterID string, weight int) error {
-	if rs.client == nil {
+	client := rs.conn()
+	if client == nil {
 		return ErrStoreClosed
 	}
 
-	key := fmt.Sprintf("gothrottle:%s", limiterID)
+	key := redisKey(limiterID)
 
-	err := rs.client.HIncrBy(rs.ctx, key, "running", int64(-weight)).Err()
+	if _, err := rs.evalScript(client, redisDoneScript, []string{key, key + ":slots"}, weight, DefaultKeyTTL.Milliseconds(), 0); err != nil {
+		return fmt.Errorf("redis eval error: %w", err)
+	}
+
+	return nil
+}
+
+// RegisterDonePartial releases part of the weight of a job that keeps running. With
+// Options.StaleTimeout it returns ErrPartialReleaseUnsupported without releasing
+// anything: slots are told apart only by weight, so the one held by the job cannot be
+// found, and shrinking another job's slot would keep that job from releasing it.
+func (rs *RedisStore) RegisterDonePartial(limiterID string, weight int) error {
+	client := rs.conn()
+	if client == nil {
+		return ErrStoreClosed
+	}
+
+	key := redisKey(limiterID)
+
+	result, err := rs.evalScript(client, redisDoneScript, []string{key, key + ":slots"}, weight, DefaultKeyTTL.Milliseconds(), 1)
 	if err != nil {
-		return fmt.Errorf("redis hincrby error: %w", err)
+		return fmt.Errorf("redis eval error: %w", err)
+	}
+	if running, ok := result.(int64); ok && running < 0 {
+		return ErrPartialReleaseUnsupported
 	}
 
 	return nil
 }
 
+// SetCooldown makes every instance using the limiter ID deny jobs until until by
+// storing it in the limiter's hash. A zero until lifts the cooldown.
+func (rs *RedisStore) SetCooldown(limiterID string, until time.Time) error {
+	client := rs.conn()
+	if client == nil {
+		return ErrStoreClosed
+	}
+
+	var untilMs int64
+	if !until.IsZero() {
+		untilMs = until.UnixMilli()
+	}
+
+	if _, err := rs.evalScript(client, redisCooldownScript, []string{redisKey(limiterID)}, untilMs, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("redis eval error: %w", err)
+	}
+	return nil
+}
+
+// CurrentUsage reads the running weight from the limiter's hash. Slots past
+// Options.StaleTimeout are still counted until the next Request releases them.
+func (rs *RedisStore) CurrentUsage(limiterID string) (int, error) {
+	client := rs.conn()
+	if client == nil {
+		return 0, ErrStoreClosed
+	}
+
+	running, err := client.HGet(context.Background(), redisKey(limiterID), "running").Int()
+	rs.observe(err)
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("redis hget error: %w", err)
+	}
+	return running, nil
+}
+
+// Snapshot returns the running weight and last start of every limiter ID with state
+// in Redis, found by SCAN over the limiters' hash keys. On a Redis Cluster every
+// primary is scanned. The keys are read in batches, so the snapshot is consistent
+// per ID but not across IDs.
+func (rs *RedisStore) Snapshot(ctx context.Context) (map[string]StateSnapshot, error) {
+	client := rs.conn()
+	if client == nil {
+		return nil, ErrStoreClosed
+	}
+
+	var mu sync.Mutex
+	states := make(map[string]StateSnapshot)
+	var err error
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanStates(ctx, node, &mu, states)
+		})
+	} else {
+		err = scanStates(ctx, client, &mu, states)
+	}
+	rs.observe(err)
+	if err != nil {
+		return nil, fmt.Errorf("redis scan error: %w", err)
+	}
+	return states, nil
+}
+
+// scanStates adds the state of every limiter hash on one Redis node to states,
+// which is guarded by mu.
+func scanStates(ctx context.Context, client redis.UniversalClient, mu *sync.Mutex, states map[string]StateSnapshot) error {
+	// The pattern ends with the closing brace of the hash tag, so the :window and
+	// :slots keys of each limiter are skipped
+	iter := client.Scan(ctx, 0, "gothrottle:{*}", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 100 {
+			if err := readStates(ctx, client, keys, mu, states); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return readStates(ctx, client, keys, mu, states)
+}
+
+// readStates reads the running and last_start fields of limiter hashes in one
+// pipelined round trip. Keys that expired since the scan are skipped.
+func readStates(ctx context.Context, client redis.UniversalClient, keys []string, mu *sync.Mutex, states map[string]StateSnapshot) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HMGet(ctx, key, "running", "last_start")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, key := range keys {
+		values := cmds[i].Val()
+		if len(values) != 2 || (values[0] == nil && values[1] == nil) {
+			continue
+		}
+		var state StateSnapshot
+		if running, ok := values[0].(string); ok {
+			state.Running, _ = strconv.Atoi(running)
+		}
+		if lastStart, ok := values[1].(string); ok {
+			if ms, _ := strconv.ParseInt(lastStart, 10, 64); ms > 0 {
+				state.LastStart = time.UnixMilli(ms)
+			}
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(key, "gothrottle:{"), "}")
+		states[id] = state
+	}
+	return nil
+}
+
+// Reset deletes the limiter's hash, sliding window and stale slot keys, for every
+// instance using the limiter ID.
+func (rs *RedisStore) Reset(limiterID string) error {
+	client := rs.conn()
+	if client == nil {
+		return ErrStoreClosed
+	}
+
+	key := redisKey(limiterID)
+	err := client.Del(context.Background(), key, key+":window", key+":slots").Err()
+	rs.observe(err)
+	if err != nil {
+		return fmt.Errorf("redis del error: %w", err)
+	}
+	return nil
+}
+
+// BatchRegisterDone releases the combined weight of several finished jobs of a
+// limiter in one round trip. It must not be used with Options.StaleTimeout, which
+// tracks and releases each job's slot separately.
+func (rs *RedisStore) BatchRegisterDone(limiterID string, totalWeight int) error {
+	return rs.RegisterDone(limiterID, totalWeight)
+}
+
+// Ping checks the connection to Redis.
+func (rs *RedisStore) Ping(ctx context.Context) error {
+	client := rs.conn()
+	if client == nil {
+		return ErrStoreClosed
+	}
+	err := client.Ping(ctx).Err()
+	rs.observe(err)
+	return err
+}
+
 // Disconnect cleans up any connections.
 func (rs *RedisStore) Disconnect() error {
 	if rs.cancelFunc != nil {
 		rs.cancelFunc()
 	}
 
+	rs.connMu.Lock()
+	defer rs.connMu.Unlock()
+	rs.state = RedisClosed
 	if rs.client != nil {
 		err := rs.client.Close()
 		rs.client = nil
@@ -0,0 +1,65 @@
+// FILENAME: registry.go
+package gothrottle
+
+import (
+	"context"
+	"sync"
+)
+
+// Registry holds named limiters, creating each one on first use. It is safe for
+// concurrent use. The zero value is ready to use.
+type Registry struct {
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// GetOrCreate returns the limiter registered under id, or creates one from opts,
+// with opts.ID set to id, and registers it. The options of an existing limiter are
+// left unchanged; use UpdateOptions to change them.
+func (r *Registry) GetOrCreate(id string, opts Options) (*Limiter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limiter, ok := r.limiters[id]; ok {
+		return limiter, nil
+	}
+
+	opts.ID = id
+	limiter, err := NewLimiter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.limiters == nil {
+		r.limiters = make(map[string]*Limiter)
+	}
+	r.limiters[id] = limiter
+	return limiter, nil
+}
+
+// StopAll removes every limiter from the registry and stops it, returning the first
+// error. It first waits for each limiter to run its queued jobs, then stops them all,
+// so that limiters sharing a datastore don't lose it while another still has jobs.
+func (r *Registry) StopAll() error {
+	r.mu.Lock()
+	limiters := r.limiters
+	r.limiters = nil
+	r.mu.Unlock()
+
+	for _, limiter := range limiters {
+		_ = limiter.WaitUntilIdle(context.Background()) // Never fails without a deadline
+	}
+
+	var firstErr error
+	for _, limiter := range limiters {
+		if err := limiter.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,70 @@
+// FILENAME: retry.go
+package gothrottle
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryPolicy controls how ScheduleWithRetry retries a failing task.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values below 1 mean a single attempt.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// Multiplier scales the backoff after each retry. Values below 1 keep it constant.
+	Multiplier float64
+
+	// MaxBackoff caps the delay between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+
+	// RetryIf, if set, reports whether an error is worth retrying.
+	// If nil, every task error is retried.
+	RetryIf func(err error) bool
+}
+
+// ScheduleWithRetry schedules task with default priority (5) and weight (1), retrying
+// failed attempts according to policy. Every attempt is scheduled through the limiter
+// again, so retries count against its limits, and backoffs are measured on
+// Options.Clock. Errors from the limiter itself that would fail again, such as
+// ErrStoreClosed, ErrInvalidPriority or ErrCircuitOpen, are not retried. It returns
+// the last error if all attempts fail.
+func (l *Limiter) ScheduleWithRetry(task func() (interface{}, error), policy RetryPolicy) (interface{}, error) {
+	backoff := policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		result, err := l.Schedule(task)
+		if err == nil {
+			return result, nil
+		}
+
+		if attempt >= policy.MaxAttempts || isLimiterErr(err) {
+			return nil, err
+		}
+		if policy.RetryIf != nil && !policy.RetryIf(err) {
+			return nil, err
+		}
+
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+		<-l.options().clock().After(backoff)
+		if policy.Multiplier > 1 {
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		}
+	}
+}
+
+// isLimiterErr reports whether err was returned by the limiter rather than a task,
+// and would therefore fail again on retry.
+func isLimiterErr(err error) bool {
+	return errors.Is(err, ErrStoreClosed) ||
+		errors.Is(err, ErrInvalidWeight) ||
+		errors.Is(err, ErrWeightExceedsLimit) ||
+		errors.Is(err, ErrInvalidPriority) ||
+		errors.Is(err, ErrUnknownTier) ||
+		errors.Is(err, ErrCircuitOpen)
+}
@@ -0,0 +1,78 @@
+// FILENAME: stats.go
+package gothrottle
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of a Limiter's activity.
+type Stats struct {
+	// ID is the limiter ID the stats belong to.
+	ID string
+
+	// Queued is the number of jobs waiting to start.
+	Queued int
+
+	// Running is the number of jobs currently executing in this instance.
+	Running int
+
+	// StoreRunning is the weight the datastore counts as running for the limiter ID,
+	// including jobs of other instances sharing it but not ScheduleKeyed keys. It is
+	// -1 if the datastore does not implement UsageReporter or the call failed.
+	StoreRunning int
+
+	// Limit is the concurrency limit in effect: MaxConcurrent, or the current
+	// adaptive limit with Options.Adaptive.
+	Limit int
+
+	// Completed counts jobs whose task returned without error.
+	Completed uint64
+
+	// Failed counts jobs whose task returned an error or panicked.
+	Failed uint64
+
+	// Rejected counts jobs that never ran: refused at submission, cancelled
+	// while queued, failed by the datastore or dropped on Stop.
+	Rejected uint64
+}
+
+// limiterStats holds the counters behind Stats.
+type limiterStats struct {
+	running   atomic.Int64
+	completed atomic.Uint64
+	failed    atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+// Stats returns a snapshot of the limiter's queue and job counters. If the datastore
+// implements UsageReporter, it is asked for StoreRunning, which for a remote datastore
+// costs a round trip.
+func (l *Limiter) Stats() Stats {
+	l.mu.RLock()
+	queued := l.queuedLen()
+	l.mu.RUnlock()
+
+	opts := l.options()
+	return Stats{
+		ID:           opts.ID,
+		Queued:       queued,
+		Running:      int(l.stats.running.Load()),
+		StoreRunning: l.storeRunning(opts.ID),
+		Limit:        l.aimd.maxConcurrent(opts, 0),
+		Completed:    l.stats.completed.Load(),
+		Failed:       l.stats.failed.Load(),
+		Rejected:     l.stats.rejected.Load(),
+	}
+}
+
+// storeRunning returns the datastore's running weight for limiterID, or -1 if it
+// cannot tell.
+func (l *Limiter) storeRunning(limiterID string) int {
+	reporter, ok := l.datastore.(UsageReporter)
+	if !ok {
+		return -1
+	}
+	running, err := reporter.CurrentUsage(limiterID)
+	if err != nil {
+		return -1
+	}
+	return running
+}
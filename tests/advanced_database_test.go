@@ -3,10 +3,13 @@ package gothrottle_test
 
 import (
 	"database/sql"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -33,20 +36,147 @@ func (dt *WeightedDatabaseThrottler) Close() {
 	_ = dt.limiter.Stop() // Ignore error in test cleanup
 }
 
+// Operation weights: a write takes every slot, so it never overlaps other operations
+const (
+	readWeight  = 1
+	writeWeight = 5
+)
+
+// Query runs a read with readWeight.
+func (dt *WeightedDatabaseThrottler) Query(query string, args ...interface{}) (int, error) {
+	result, err := dt.limiter.ScheduleWithOptions(func() (interface{}, error) {
+		var count int
+		err := dt.db.QueryRow(query, args...).Scan(&count)
+		return count, err
+	}, 5, readWeight)
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+// Exec runs a write with writeWeight.
+func (dt *WeightedDatabaseThrottler) Exec(query string, args ...interface{}) error {
+	_, err := dt.limiter.ScheduleWithOptions(func() (interface{}, error) {
+		return dt.db.Exec(query, args...)
+	}, 5, writeWeight)
+	return err
+}
+
+// openTestDB opens an in-memory SQLite database. Every connection to ":memory:" gets
+// a database of its own, so the pool is limited to one connection.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
 // TestWeightedDatabaseOperations demonstrates different weights for different database operations
 func TestWeightedDatabaseOperations(t *testing.T) {
-	t.Skip("Skipping weighted database test - demonstrates patterns but has table creation timing issues")
+	throttler, err := NewWeightedDatabaseThrottler(openTestDB(t), gothrottle.Options{MaxConcurrent: writeWeight})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer throttler.Close()
+
+	if err := throttler.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := throttler.Exec(`INSERT INTO users (name) VALUES (?)`, fmt.Sprintf("user-%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := throttler.Query(`SELECT COUNT(*) FROM users`); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
 
-	// This test demonstrates weighted database operation patterns but is skipped due to
-	// test environment timing issues. In real applications, this pattern works well.
+	count, err := throttler.Query(`SELECT COUNT(*) FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Errorf("Expected 10 users, got %d", count)
+	}
+	if stats := throttler.limiter.Stats(); stats.Failed != 0 {
+		t.Errorf("Expected no failed operations, got %d", stats.Failed)
+	}
 }
 
 // TestBatchProcessingWithThrottling shows how to process large datasets with rate limiting
 func TestBatchProcessingWithThrottling(t *testing.T) {
-	t.Skip("Skipping batch processing test - demonstrates patterns but has test environment timing issues")
+	db := openTestDB(t)
+	if _, err := db.Exec(`CREATE TABLE records (id INTEGER PRIMARY KEY, value TEXT)`); err != nil {
+		t.Fatal(err)
+	}
 
-	// This test demonstrates batch processing patterns but is skipped due to
-	// test environment timing issues. In real applications, this pattern works well.
+	// One batch per second, on a fake clock so the test does not sleep
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1, MinTime: time.Second, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	const records, batchSize = 25, 10
+	var handles []*gothrottle.JobHandle
+	for start := 0; start < records; start += batchSize {
+		start := start
+		handles = append(handles, limiter.Submit(func() (interface{}, error) {
+			tx, err := db.Begin()
+			if err != nil {
+				return nil, err
+			}
+			for i := start; i < start+batchSize && i < records; i++ {
+				if _, err := tx.Exec(`INSERT INTO records (value) VALUES (?)`, fmt.Sprintf("record-%d", i)); err != nil {
+					_ = tx.Rollback()
+					return nil, err
+				}
+			}
+			return clock.Now(), tx.Commit()
+		}))
+	}
+
+	var last time.Time
+	for i, handle := range handles {
+		if i > 0 {
+			// The scheduler sleeps on the fake clock until the next batch may start
+			clock.BlockUntil(1)
+			clock.Advance(time.Second)
+		}
+		started, err := handle.Wait()
+		if err != nil {
+			t.Fatalf("Batch %d failed: %v", i, err)
+		}
+		if i > 0 && started.(time.Time).Sub(last) != time.Second {
+			t.Errorf("Expected batch %d to start 1s after the previous one, got %v", i, started.(time.Time).Sub(last))
+		}
+		last = started.(time.Time)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM records`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != records {
+		t.Errorf("Expected %d records, got %d", records, count)
+	}
 }
 
 // BenchmarkThrottledDatabaseOperations measures performance with throttling
@@ -0,0 +1,83 @@
+// FILENAME: cache_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestLimiter_ScheduleCached(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	calls := 0
+	lookup := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+	ttl := 50 * time.Millisecond
+
+	// Miss: the task runs and its result is cached
+	result, err := limiter.ScheduleCached("user:42", ttl, lookup)
+	if err != nil || result != 1 {
+		t.Fatalf("Expected 1, got %v, %v", result, err)
+	}
+
+	// Hit: the cached result is returned without taking a slot
+	result, err = limiter.ScheduleCached("user:42", ttl, lookup)
+	if err != nil || result != 1 {
+		t.Fatalf("Expected cached 1, got %v, %v", result, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the task to run once, ran %d times", calls)
+	}
+	if completed := limiter.Stats().Completed; completed != 1 {
+		t.Errorf("Expected a cache hit not to schedule a job, got %d completed", completed)
+	}
+
+	// Expiry: the task runs again
+	time.Sleep(ttl + 10*time.Millisecond)
+	result, err = limiter.ScheduleCached("user:42", ttl, lookup)
+	if err != nil || result != 2 {
+		t.Fatalf("Expected 2 after expiry, got %v, %v", result, err)
+	}
+
+	// Errors are not cached
+	errLookup := errors.New("lookup failed")
+	if _, err := limiter.ScheduleCached("user:7", ttl, func() (interface{}, error) { return nil, errLookup }); err != errLookup {
+		t.Fatalf("Expected %v, got %v", errLookup, err)
+	}
+	result, err = limiter.ScheduleCached("user:7", ttl, lookup)
+	if err != nil || result != 3 {
+		t.Errorf("Expected a failed result not to be cached, got %v, %v", result, err)
+	}
+}
+
+func TestMemoryCache_Eviction(t *testing.T) {
+	cache := gothrottle.NewMemoryCache()
+	ttl := 20 * time.Millisecond
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, key, ttl)
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Expected a fresh entry to be found")
+	}
+
+	time.Sleep(ttl + 10*time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expected an expired entry not to be found")
+	}
+
+	// Entries that are never read again are swept on Set
+	cache.Set("d", "d", ttl)
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected expired entries to be evicted, got %d entries", n)
+	}
+}
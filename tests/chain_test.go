@@ -0,0 +1,81 @@
+// FILENAME: chain_test.go
+package gothrottle_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestChain(t *testing.T) {
+	perUser, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = perUser.Stop() }() // Ignore error in test cleanup
+	global, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = global.Stop() }() // Ignore error in test cleanup
+
+	chain := gothrottle.NewChain(perUser, global)
+
+	// The per-user limit of 1 applies even though the global limit is 2
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := chain.Schedule(func() (interface{}, error) {
+				mu.Lock()
+				concurrent++
+				if concurrent > maxConcurrent {
+					maxConcurrent = concurrent
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				concurrent--
+				mu.Unlock()
+				return "ok", nil
+			})
+			if err != nil || result != "ok" {
+				t.Errorf("Expected 'ok', got %v, %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxConcurrent != 1 {
+		t.Errorf("Expected the tighter limit to cap concurrency at 1, got %d", maxConcurrent)
+	}
+
+	// Stopping an inner limiter fails the job and releases the outer slot
+	if err := global.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	ran := false
+	if _, err := chain.Schedule(func() (interface{}, error) {
+		ran = true
+		return nil, nil
+	}); err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed, got %v", err)
+	}
+	if ran {
+		t.Error("The task should not run after an inner limiter stopped")
+	}
+	if err := perUser.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := perUser.Stats(); stats.Running != 0 {
+		t.Errorf("Expected the outer slot to be released, got %d running", stats.Running)
+	}
+}
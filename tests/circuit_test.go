@@ -0,0 +1,95 @@
+// FILENAME: circuit_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
+)
+
+func TestLimiter_CircuitBreaker(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 4,
+		Clock:         clock,
+		CircuitBreaker: &gothrottle.CircuitBreaker{
+			FailureThreshold: 2,
+			OpenDuration:     time.Second,
+			HalfOpenProbes:   1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	errBackend := errors.New("backend down")
+	fail := func() (interface{}, error) { return nil, errBackend }
+	succeed := func() (interface{}, error) { return "ok", nil }
+
+	// Closed: a success resets the count of consecutive failures
+	for _, task := range []func() (interface{}, error){fail, succeed, fail} {
+		_, _ = limiter.Schedule(task)
+	}
+	if _, err := limiter.Schedule(succeed); err != nil {
+		t.Fatalf("Expected the circuit to stay closed, got %v", err)
+	}
+
+	// Open: two consecutive failures trip the circuit
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Schedule(fail); err != errBackend {
+			t.Fatalf("Expected the task error, got %v", err)
+		}
+	}
+	ran := false
+	_, err = limiter.Schedule(func() (interface{}, error) { ran = true; return nil, nil })
+	if !errors.Is(err, gothrottle.ErrCircuitOpen) || ran {
+		t.Fatalf("Expected ErrCircuitOpen without running the task, got %v (ran %v)", err, ran)
+	}
+
+	// Half-open: a failed probe opens the circuit again
+	clock.Advance(time.Second)
+	release := make(chan struct{})
+	probe := limiter.Submit(func() (interface{}, error) {
+		<-release
+		return nil, errBackend
+	})
+	waitFor(t, func() bool { return limiter.Stats().Running == 1 })
+	if _, err := limiter.Schedule(succeed); !errors.Is(err, gothrottle.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen while the probe runs, got %v", err)
+	}
+	close(release)
+	if _, err := probe.Wait(); err != errBackend {
+		t.Fatalf("Expected the probe to fail, got %v", err)
+	}
+	if _, err := limiter.Schedule(succeed); !errors.Is(err, gothrottle.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+
+	// Closed again: a successful probe closes the circuit
+	clock.Advance(time.Second)
+	if result, err := limiter.Schedule(succeed); err != nil || result != "ok" {
+		t.Fatalf("Expected the probe to succeed, got %v, %v", result, err)
+	}
+	if _, err := limiter.Schedule(fail); err != errBackend {
+		t.Errorf("Expected the task error, got %v", err)
+	}
+	if _, err := limiter.Schedule(succeed); err != nil {
+		t.Errorf("Expected a single failure to leave the circuit closed, got %v", err)
+	}
+}
+
+// waitFor waits up to a second for cond to hold.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
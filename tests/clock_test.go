@@ -0,0 +1,145 @@
+// FILENAME: clock_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
+)
+
+func TestLimiter_FakeClock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MinTime: time.Second, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	task := func() (interface{}, error) { return clock.Now(), nil }
+	first := limiter.Submit(task)
+	second := limiter.Submit(task)
+
+	started, err := first.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scheduler sleeps on the fake clock until MinTime has passed
+	clock.BlockUntil(1)
+	clock.Advance(time.Second - time.Millisecond)
+	select {
+	case <-second.Done():
+		t.Fatal("Expected second job to wait for MinTime")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	result, err := second.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gap := result.(time.Time).Sub(started.(time.Time)); gap != time.Second {
+		t.Errorf("Expected jobs to start exactly 1s apart, got %v", gap)
+	}
+
+	// The clock cannot be swapped on a running limiter
+	err = limiter.UpdateOptions(gothrottle.Options{MinTime: time.Second, Clock: clocktest.NewFakeClock(time.Unix(0, 0))})
+	if !errors.Is(err, gothrottle.ErrImmutableOption) {
+		t.Errorf("Expected ErrImmutableOption, got %v", err)
+	}
+}
+
+func TestLimiter_PollIntervalFakeClock(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	opts := gothrottle.Options{ID: "poll", Datastore: store, MaxConcurrent: 1, PollInterval: time.Second, Clock: clock}
+	limiter, err := gothrottle.NewLimiter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Another instance sharing the store holds the only slot
+	if canRun, _, err := store.Request("poll", 1, opts); err != nil || !canRun {
+		t.Fatalf("Expected a grant, got canRun=%v err=%v", canRun, err)
+	}
+	handle := limiter.Submit(func() (interface{}, error) { return nil, nil })
+
+	// Its release is only noticed when the scheduler polls again
+	clock.BlockUntil(1)
+	if err := store.RegisterDone("poll", 1); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second - time.Millisecond)
+	select {
+	case <-handle.Done():
+		t.Fatal("Expected the job to wait for the next poll")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	if _, err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalStore_IdleTTLFakeClock(t *testing.T) {
+	store := gothrottle.NewLocalStoreWithTTL(20 * time.Millisecond)
+	defer func() { _ = store.Disconnect() }() // Ignore error in test cleanup
+
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	opts := gothrottle.Options{MinTime: time.Hour, Clock: clock}
+	if canRun, _, err := store.Request("fake", 1, opts); err != nil || !canRun {
+		t.Fatalf("First request should be allowed, got %v, %v", canRun, err)
+	}
+	if err := store.RegisterDone("fake", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Idleness is measured on the fake clock, which has not moved
+	time.Sleep(60 * time.Millisecond)
+	if canRun, _, err := store.Request("fake", 1, opts); err != nil || canRun {
+		t.Fatalf("Expected MinTime to still apply, got %v, %v", canRun, err)
+	}
+
+	clock.Advance(time.Second)
+	time.Sleep(60 * time.Millisecond)
+	if canRun, _, err := store.Request("fake", 1, opts); err != nil || !canRun {
+		t.Errorf("Expected the limiter to be evicted once the fake clock moved, got %v, %v", canRun, err)
+	}
+}
+
+func TestLimiter_RetryFakeClock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	attempts := 0
+	done := make(chan error, 1)
+	go func() {
+		_, err := limiter.ScheduleWithRetry(func() (interface{}, error) {
+			attempts++
+			if attempts < 2 {
+				return nil, errors.New("flaky")
+			}
+			return nil, nil
+		}, gothrottle.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Minute})
+		done <- err
+	}()
+
+	// The backoff sleeps on the fake clock
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
@@ -2,13 +2,16 @@
 package gothrottle_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/AFZidan/gothrottle"
+	"github.com/DATA-DOG/go-sqlmock"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver for example
 )
@@ -34,37 +37,35 @@ func NewDatabaseThrottler(db *sql.DB, opts gothrottle.Options) (*DatabaseThrottl
 
 // Query executes a throttled database query
 func (dt *DatabaseThrottler) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	result, err := dt.limiter.Schedule(func() (interface{}, error) {
+	return gothrottle.Schedule(dt.limiter, func() (*sql.Rows, error) {
 		return dt.db.Query(query, args...)
 	})
+}
 
+// QueryContext executes a throttled database query bound to ctx. Cancelling ctx
+// removes a queued query from the limiter's queue and aborts a running one.
+func (dt *DatabaseThrottler) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	result, err := dt.limiter.ScheduleCtx(ctx, func(ctx context.Context) (interface{}, error) {
+		return dt.db.QueryContext(ctx, query, args...)
+	})
 	if err != nil {
 		return nil, err
 	}
-
 	return result.(*sql.Rows), nil
 }
 
 // QueryRow executes a throttled single-row query
-func (dt *DatabaseThrottler) QueryRow(query string, args ...interface{}) *sql.Row {
-	result, _ := dt.limiter.Schedule(func() (interface{}, error) {
+func (dt *DatabaseThrottler) QueryRow(query string, args ...interface{}) (*sql.Row, error) {
+	return gothrottle.Schedule(dt.limiter, func() (*sql.Row, error) {
 		return dt.db.QueryRow(query, args...), nil
 	})
-
-	return result.(*sql.Row)
 }
 
 // Exec executes a throttled database statement
 func (dt *DatabaseThrottler) Exec(query string, args ...interface{}) (sql.Result, error) {
-	result, err := dt.limiter.Schedule(func() (interface{}, error) {
+	return gothrottle.Schedule(dt.limiter, func() (sql.Result, error) {
 		return dt.db.Exec(query, args...)
 	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	return result.(sql.Result), nil
 }
 
 // Close closes the database connection and stops the limiter
@@ -150,6 +151,40 @@ func TestDatabaseThrottling(t *testing.T) {
 	}
 }
 
+// TestDatabaseQueryContextCancel shows a cancelled request aborting its running query
+func TestDatabaseQueryContextCancel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT id FROM users").
+		WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	throttledDB, err := NewDatabaseThrottler(db, gothrottle.Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer throttledDB.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = throttledDB.QueryContext(ctx, "SELECT id FROM users")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The task saw the cancellation too and gave up its slot
+	if err := throttledDB.limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the query to be aborted, it held its slot for %v", elapsed)
+	}
+}
+
 // TestDatabaseInsertThrottling demonstrates throttling INSERT operations
 func TestDatabaseInsertThrottling(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
@@ -198,7 +233,10 @@ func TestDatabaseInsertThrottling(t *testing.T) {
 	elapsed := time.Since(start)
 
 	// Verify all records were inserted
-	row := throttledDB.QueryRow("SELECT COUNT(*) FROM logs")
+	row, err := throttledDB.QueryRow("SELECT COUNT(*) FROM logs")
+	if err != nil {
+		t.Fatal(err)
+	}
 	var count int
 	if err := row.Scan(&count); err != nil {
 		t.Fatal(err)
@@ -300,7 +338,10 @@ func TestDistributedDatabaseThrottling(t *testing.T) {
 	wg.Wait()
 
 	// Verify all calls were logged
-	row := throttledDB.QueryRow("SELECT COUNT(*) FROM api_calls")
+	row, err := throttledDB.QueryRow("SELECT COUNT(*) FROM api_calls")
+	if err != nil {
+		t.Fatal(err)
+	}
 	var count int
 	if err := row.Scan(&count); err != nil {
 		t.Fatal(err)
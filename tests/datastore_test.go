@@ -0,0 +1,980 @@
+// FILENAME: datastore_test.go
+package gothrottle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
+)
+
+var errStoreUnavailable = errors.New("store unavailable")
+
+// failingStore grants every request but fails to register job completion.
+type failingStore struct {
+	*gothrottle.LocalStore
+}
+
+func (fs *failingStore) RegisterDone(limiterID string, weight int) error {
+	return errStoreUnavailable
+}
+
+func TestLimiter_OnStoreError(t *testing.T) {
+	var mu sync.Mutex
+	var storeErrs []error
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:        "failing",
+		Datastore: &failingStore{LocalStore: gothrottle.NewLocalStore()},
+		OnStoreError: func(err error) {
+			mu.Lock()
+			storeErrs = append(storeErrs, err)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	result, err := limiter.Schedule(func() (interface{}, error) {
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "done" {
+		t.Errorf("Expected 'done', got %v", result)
+	}
+
+	// RegisterDone runs after the result is delivered
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(storeErrs)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(storeErrs) != 1 {
+		t.Fatalf("Expected 1 store error, got %d", len(storeErrs))
+	}
+	if !errors.Is(storeErrs[0], errStoreUnavailable) {
+		t.Errorf("Expected store error to wrap %v, got %v", errStoreUnavailable, storeErrs[0])
+	}
+}
+
+// flakyStore fails its first n requests, where n is failures, before delegating to a LocalStore.
+type flakyStore struct {
+	*gothrottle.LocalStore
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (fs *flakyStore) Request(limiterID string, weight int, opts gothrottle.Options) (bool, time.Duration, error) {
+	fs.mu.Lock()
+	fs.calls++
+	fail := fs.calls <= fs.failures
+	fs.mu.Unlock()
+
+	if fail {
+		return false, 0, errStoreUnavailable
+	}
+	return fs.LocalStore.Request(limiterID, weight, opts)
+}
+
+func TestLimiter_DatastoreRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		maxRetries int
+		wantErr    bool
+	}{
+		{"no retries", 1, 0, true},
+		{"recovers within retries", 2, 3, false},
+		{"exhausts retries", 5, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &flakyStore{LocalStore: gothrottle.NewLocalStore(), failures: tt.failures}
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+				ID:                    "flaky",
+				Datastore:             store,
+				DatastoreMaxRetries:   tt.maxRetries,
+				DatastoreRetryBackoff: 5 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			result, err := limiter.Schedule(func() (interface{}, error) {
+				return "ok", nil
+			})
+			if tt.wantErr {
+				if !errors.Is(err, errStoreUnavailable) {
+					t.Errorf("Expected error wrapping %v, got %v", errStoreUnavailable, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != "ok" {
+				t.Errorf("Expected 'ok', got %v", result)
+			}
+		})
+	}
+}
+
+func TestDatastore_Jitter(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", gothrottle.NewLocalStore()},
+		{"redis", redisStore},
+	}
+
+	minTime := time.Second
+	jitter := 500 * time.Millisecond
+	opts := gothrottle.Options{MinTime: minTime, Jitter: jitter}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			if canRun, _, err := tt.store.Request("jitter", 1, opts); err != nil || !canRun {
+				t.Fatalf("First request should be allowed, got canRun=%v err=%v", canRun, err)
+			}
+			if err := tt.store.RegisterDone("jitter", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			waits := make(map[time.Duration]bool)
+			for i := 0; i < 20; i++ {
+				canRun, waitTime, err := tt.store.Request("jitter", 1, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if canRun {
+					t.Fatal("Request within MinTime should be denied")
+				}
+				if waitTime > minTime+jitter {
+					t.Errorf("Expected wait time at most %v, got %v", minTime+jitter, waitTime)
+				}
+				waits[waitTime.Truncate(10*time.Millisecond)] = true
+			}
+			if len(waits) < 2 {
+				t.Errorf("Expected jittered wait times to differ, got %v", waits)
+			}
+		})
+	}
+}
+
+func TestDatastore_GCRA(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", gothrottle.NewLocalStore()},
+		{"redis", redisStore},
+	}
+
+	interval := 100 * time.Millisecond
+	opts := gothrottle.Options{MinTime: interval, Algorithm: gothrottle.AlgorithmGCRA, Burst: 3}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			// A burst of up to Burst jobs is allowed after an idle period
+			for i := 0; i < 3; i++ {
+				if canRun, _, err := tt.store.Request("gcra", 1, opts); err != nil || !canRun {
+					t.Fatalf("Request %d should be allowed within the burst, got canRun=%v err=%v", i+1, canRun, err)
+				}
+			}
+
+			// The next job has to wait until one emission interval after the burst began
+			canRun, waitTime, err := tt.store.Request("gcra", 1, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canRun {
+				t.Fatal("Request beyond the burst should be denied")
+			}
+			if waitTime <= 0 || waitTime > interval+time.Millisecond {
+				t.Errorf("Expected wait time in (0, %v], got %v", interval, waitTime)
+			}
+
+			time.Sleep(waitTime)
+			if canRun, _, err := tt.store.Request("gcra", 1, opts); err != nil || !canRun {
+				t.Fatalf("Request after the wait should be allowed, got canRun=%v err=%v", canRun, err)
+			}
+
+			// A job of weight 2 uses two intervals, so the next one waits twice as long
+			time.Sleep(3 * interval)
+			for i := 0; i < 2; i++ {
+				if canRun, _, err := tt.store.Request("gcra", 1, opts); err != nil || !canRun {
+					t.Fatalf("Request %d should be allowed, got canRun=%v err=%v", i+1, canRun, err)
+				}
+			}
+			if canRun, _, err := tt.store.Request("gcra", 2, opts); err != nil || canRun {
+				t.Fatalf("Weight-2 request should be denied with one unit of burst left, got canRun=%v err=%v", canRun, err)
+			}
+		})
+	}
+}
+
+// grantingStore grants every request, like a shared datastore with spare global capacity.
+type grantingStore struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (gs *grantingStore) Request(limiterID string, weight int, opts gothrottle.Options) (bool, time.Duration, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.requests++
+	return true, 0, nil
+}
+
+func (gs *grantingStore) RegisterDone(limiterID string, weight int) error { return nil }
+func (gs *grantingStore) Disconnect() error                               { return nil }
+
+func TestLimiter_LocalMaxConcurrent(t *testing.T) {
+	store := &grantingStore{}
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:                 "local-cap",
+		Datastore:          store,
+		MaxConcurrent:      100,
+		LocalMaxConcurrent: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	tasks := make([]func() (interface{}, error), 10)
+	for i := range tasks {
+		tasks[i] = func() (interface{}, error) {
+			mu.Lock()
+			concurrent++
+			if concurrent > maxConcurrent {
+				maxConcurrent = concurrent
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			concurrent--
+			mu.Unlock()
+			return nil, nil
+		}
+	}
+
+	_, errs := limiter.BatchSchedule(tasks, 5, 1)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if maxConcurrent != 2 {
+		t.Errorf("Expected the local cap to limit concurrency to 2, got %d", maxConcurrent)
+	}
+	// The datastore is only asked once a local slot is free
+	if store.requests != len(tasks) {
+		t.Errorf("Expected %d datastore requests, got %d", len(tasks), store.requests)
+	}
+
+	if _, err := limiter.ScheduleWithOptions(tasks[0], 5, 3); err != gothrottle.ErrWeightExceedsLimit {
+		t.Errorf("Expected ErrWeightExceedsLimit for a job heavier than the local cap, got %v", err)
+	}
+}
+
+func TestLimiter_MaxDispatchGoroutines(t *testing.T) {
+	// No MaxConcurrent, so only the dispatch cap bounds concurrency
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxDispatchGoroutines: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	task := func() (interface{}, error) {
+		mu.Lock()
+		concurrent++
+		if concurrent > maxConcurrent {
+			maxConcurrent = concurrent
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		concurrent--
+		mu.Unlock()
+		return nil, nil
+	}
+
+	// Submit from many goroutines at once, with weights that don't count
+	// towards the cap
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(weight int) {
+			defer wg.Done()
+			if _, err := limiter.ScheduleWithOptions(task, 5, weight); err != nil {
+				t.Error(err)
+			}
+		}(i%3 + 1)
+	}
+	wg.Wait()
+
+	if maxConcurrent != 3 {
+		t.Errorf("Expected the dispatch cap to limit concurrency to 3, got %d", maxConcurrent)
+	}
+	if stats := limiter.Stats(); stats.Completed != 30 {
+		t.Errorf("Expected 30 completed jobs, got %d", stats.Completed)
+	}
+}
+
+func TestLocalStore_StrictAccounting(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	opts := gothrottle.Options{MaxConcurrent: 2, StrictAccounting: true}
+
+	if canRun, _, err := store.Request("strict", 1, opts); err != nil || !canRun {
+		t.Fatalf("Request should be allowed, got canRun=%v err=%v", canRun, err)
+	}
+	// Releasing more weight than was acquired is reported instead of clamped
+	if err := store.RegisterDone("strict", 2); !errors.Is(err, gothrottle.ErrAccountingMismatch) {
+		t.Errorf("Expected ErrAccountingMismatch for a weight mismatch, got %v", err)
+	}
+
+	if canRun, _, err := store.Request("strict", 1, opts); err != nil || !canRun {
+		t.Fatalf("Request should be allowed, got canRun=%v err=%v", canRun, err)
+	}
+	if err := store.RegisterDone("strict", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RegisterDone("strict", 1); !errors.Is(err, gothrottle.ErrAccountingMismatch) {
+		t.Errorf("Expected ErrAccountingMismatch for a double RegisterDone, got %v", err)
+	}
+
+	// Without strict mode the running count is still clamped silently
+	opts.StrictAccounting = false
+	if _, _, err := store.Request("lenient", 1, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RegisterDone("lenient", 2); err != nil {
+		t.Errorf("Expected no error without StrictAccounting, got %v", err)
+	}
+}
+
+func TestLimiter_StrictAccountingReported(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	var mu sync.Mutex
+	var storeErrs []error
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:               "strict-limiter",
+		Datastore:        store,
+		StrictAccounting: true,
+		OnStoreError: func(err error) {
+			mu.Lock()
+			storeErrs = append(storeErrs, err)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Release the job's slot behind the limiter's back, so its own RegisterDone is a double release
+	_, err = limiter.Schedule(func() (interface{}, error) {
+		return nil, store.RegisterDone("strict-limiter", 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(storeErrs) != 1 || !errors.Is(storeErrs[0], gothrottle.ErrAccountingMismatch) {
+		t.Errorf("Expected one ErrAccountingMismatch store error, got %v", storeErrs)
+	}
+}
+
+func TestChainedStore(t *testing.T) {
+	first := gothrottle.NewLocalStore()
+	second := gothrottle.NewLocalStore()
+	store := gothrottle.NewChainedStore(
+		gothrottle.NewOverrideStore(first, func(opts gothrottle.Options) gothrottle.Options {
+			opts.MaxConcurrent = 3
+			return opts
+		}),
+		second,
+	)
+	opts := gothrottle.Options{MaxConcurrent: 2}
+
+	for i := 0; i < 2; i++ {
+		if canRun, _, err := store.Request("chained", 1, opts); err != nil || !canRun {
+			t.Fatalf("Request %d should be allowed, got canRun=%v err=%v", i+1, canRun, err)
+		}
+	}
+
+	// The second store denies, so the first store's grant is rolled back
+	if canRun, _, err := store.Request("chained", 1, opts); err != nil || canRun {
+		t.Fatalf("Request beyond the second store's limit should be denied, got canRun=%v err=%v", canRun, err)
+	}
+	firstOpts := gothrottle.Options{MaxConcurrent: 3}
+	if canRun, _, err := first.Peek("chained", 1, firstOpts); err != nil || !canRun {
+		t.Errorf("Expected the denied request to release its grant in the first store, got canRun=%v err=%v", canRun, err)
+	}
+	if canRun, _, err := first.Peek("chained", 2, firstOpts); err != nil || canRun {
+		t.Errorf("Expected the first store to hold exactly 2 slots, got canRun=%v err=%v", canRun, err)
+	}
+
+	if canRun, _, err := store.Peek("chained", 1, opts); err != nil || canRun {
+		t.Errorf("Expected Peek to report the second store's denial, got canRun=%v err=%v", canRun, err)
+	}
+
+	// RegisterDone releases the job from every store
+	if err := store.RegisterDone("chained", 1); err != nil {
+		t.Fatal(err)
+	}
+	if canRun, _, err := second.Peek("chained", 1, opts); err != nil || !canRun {
+		t.Errorf("Expected RegisterDone to free a slot in the second store, got canRun=%v err=%v", canRun, err)
+	}
+	if canRun, _, err := first.Peek("chained", 2, firstOpts); err != nil || !canRun {
+		t.Errorf("Expected RegisterDone to free a slot in the first store, got canRun=%v err=%v", canRun, err)
+	}
+
+	// A failing store fails the chain and releases the grants before it
+	failing := gothrottle.NewChainedStore(first, &flakyStore{LocalStore: gothrottle.NewLocalStore(), failures: 1})
+	if _, _, err := failing.Request("chained", 2, firstOpts); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Expected error wrapping %v, got %v", errStoreUnavailable, err)
+	}
+	if canRun, _, err := first.Peek("chained", 2, firstOpts); err != nil || !canRun {
+		t.Errorf("Expected the failed request to release its grant in the first store, got canRun=%v err=%v", canRun, err)
+	}
+}
+
+func TestChainedStore_DenialKeepsEarlierState(t *testing.T) {
+	first := gothrottle.NewLocalStore()
+	second := gothrottle.NewLocalStore()
+	store := gothrottle.NewChainedStore(
+		gothrottle.NewOverrideStore(first, func(opts gothrottle.Options) gothrottle.Options {
+			opts.MaxConcurrent = 0
+			opts.WindowLimit = 1
+			opts.WindowDuration = time.Hour
+			return opts
+		}),
+		second,
+	)
+	opts := gothrottle.Options{MaxConcurrent: 1}
+
+	// Saturate the second store from outside the chain
+	if canRun, _, err := second.Request("windowed", 1, opts); err != nil || !canRun {
+		t.Fatalf("Expected the second store to grant, got canRun=%v err=%v", canRun, err)
+	}
+
+	// Retried denials must not use up the first store's window
+	for i := 0; i < 3; i++ {
+		if canRun, _, err := store.Request("windowed", 1, opts); err != nil || canRun {
+			t.Fatalf("Expected the saturated second store to deny, got canRun=%v err=%v", canRun, err)
+		}
+	}
+
+	if err := second.RegisterDone("windowed", 1); err != nil {
+		t.Fatal(err)
+	}
+	if canRun, _, err := store.Request("windowed", 1, opts); err != nil || !canRun {
+		t.Errorf("Expected the first store's window to be unused, got canRun=%v err=%v", canRun, err)
+	}
+}
+
+func TestDatastore_Peek(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", gothrottle.NewLocalStore()},
+		{"redis", redisStore},
+	}
+
+	opts := gothrottle.Options{MaxConcurrent: 1, MinTime: time.Second, WindowLimit: 1, WindowDuration: time.Minute}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			peeker, ok := tt.store.(gothrottle.Peeker)
+			if !ok {
+				t.Fatal("Expected the store to implement Peeker")
+			}
+
+			// Peeking repeatedly acquires nothing
+			for i := 0; i < 3; i++ {
+				if canRun, _, err := peeker.Peek("peek", 1, opts); err != nil || !canRun {
+					t.Fatalf("Peek %d should report a free slot, got canRun=%v err=%v", i+1, canRun, err)
+				}
+			}
+			if canRun, _, err := tt.store.Request("peek", 1, opts); err != nil || !canRun {
+				t.Fatalf("Request after peeking should be allowed, got canRun=%v err=%v", canRun, err)
+			}
+
+			if canRun, _, err := peeker.Peek("peek", 1, opts); err != nil || canRun {
+				t.Errorf("Peek should report the held slot, got canRun=%v err=%v", canRun, err)
+			}
+			if err := tt.store.RegisterDone("peek", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			// The slot is free but MinTime has not passed
+			canRun, waitTime, err := peeker.Peek("peek", 1, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canRun || waitTime <= 0 || waitTime > time.Second {
+				t.Errorf("Expected Peek to wait up to MinTime, got canRun=%v waitTime=%v", canRun, waitTime)
+			}
+
+			// Without MinTime the window is full until the start expires
+			windowOpts := opts
+			windowOpts.MinTime = 0
+			canRun, waitTime, err = peeker.Peek("peek", 1, windowOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canRun || waitTime <= time.Second || waitTime > time.Minute {
+				t.Errorf("Expected Peek to wait for the window, got canRun=%v waitTime=%v", canRun, waitTime)
+			}
+		})
+	}
+}
+
+func TestDatastore_SetCooldown(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", gothrottle.NewLocalStore()},
+		{"redis", redisStore},
+	}
+
+	opts := gothrottle.Options{MaxConcurrent: 2}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			setter, ok := tt.store.(gothrottle.CooldownSetter)
+			if !ok {
+				t.Fatal("Expected the store to implement CooldownSetter")
+			}
+
+			if canRun, _, err := tt.store.Request("cooldown", 1, opts); err != nil || !canRun {
+				t.Fatalf("Expected a free slot, got canRun=%v err=%v", canRun, err)
+			}
+
+			// Every job is denied until the cooldown ends, even with a slot free
+			if err := setter.SetCooldown("cooldown", time.Now().Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			canRun, waitTime, err := tt.store.Request("cooldown", 1, opts)
+			if err != nil || canRun || waitTime <= 50*time.Second || waitTime > time.Minute {
+				t.Errorf("Expected a denial for the rest of the cooldown, got canRun=%v waitTime=%v err=%v", canRun, waitTime, err)
+			}
+			canRun, waitTime, err = tt.store.(gothrottle.Peeker).Peek("cooldown", 1, opts)
+			if err != nil || canRun || waitTime <= 50*time.Second {
+				t.Errorf("Expected Peek to report the cooldown, got canRun=%v waitTime=%v err=%v", canRun, waitTime, err)
+			}
+
+			// Finishing a job during the cooldown does not end it
+			if err := tt.store.RegisterDone("cooldown", 1); err != nil {
+				t.Fatal(err)
+			}
+			if canRun, _, err := tt.store.Request("cooldown", 1, opts); err != nil || canRun {
+				t.Errorf("Expected the cooldown to outlast RegisterDone, got canRun=%v err=%v", canRun, err)
+			}
+
+			// A zero time lifts the cooldown
+			if err := setter.SetCooldown("cooldown", time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+			if canRun, _, err := tt.store.Request("cooldown", 1, opts); err != nil || !canRun {
+				t.Errorf("Expected a grant after lifting the cooldown, got canRun=%v err=%v", canRun, err)
+			}
+		})
+	}
+}
+
+func TestLimiter_SetCooldownShared(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{ID: "shared-cooldown", Datastore: store, MaxConcurrent: 2}
+
+	first, err := gothrottle.NewLimiter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Stop() }() // Ignore error in test cleanup
+	second, err := gothrottle.NewLimiter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Stop() }() // Ignore error in test cleanup
+
+	// A cooldown set by one instance pauses the other, and keeps the key alive
+	first.SetCooldown(time.Minute)
+	if canRun, waitTime, err := second.CheckAvailable(); err != nil || canRun || waitTime <= 50*time.Second {
+		t.Errorf("Expected the other instance to see the cooldown, got canRun=%v waitTime=%v err=%v", canRun, waitTime, err)
+	}
+	if ttl := mr.TTL("gothrottle:{shared-cooldown}"); ttl < 50*time.Second {
+		t.Errorf("Expected the key to live for the cooldown, got TTL %v", ttl)
+	}
+
+	first.SetCooldown(0)
+	if result, err := second.Schedule(func() (interface{}, error) { return "ok", nil }); err != nil || result != "ok" {
+		t.Errorf("Expected the job to run after lifting the cooldown, got %v, %v", result, err)
+	}
+}
+
+func TestDatastore_Reset(t *testing.T) {
+	redisStore, mr := newTestRedisStore(t)
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", gothrottle.NewLocalStore()},
+		{"redis", redisStore},
+	}
+
+	opts := gothrottle.Options{MaxConcurrent: 2, MinTime: time.Minute, WindowLimit: 5, WindowDuration: time.Hour, StaleTimeout: time.Hour}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			resetter, ok := tt.store.(gothrottle.Resetter)
+			if !ok {
+				t.Fatal("Expected the store to implement Resetter")
+			}
+
+			// A crashed instance leaked its slot and MinTime blocks the next job
+			if canRun, _, err := tt.store.Request("reset", 2, opts); err != nil || !canRun {
+				t.Fatalf("Expected a free slot, got canRun=%v err=%v", canRun, err)
+			}
+			if canRun, _, err := tt.store.Request("reset", 1, opts); err != nil || canRun {
+				t.Fatalf("Expected a denial, got canRun=%v err=%v", canRun, err)
+			}
+
+			if err := resetter.Reset("reset"); err != nil {
+				t.Fatal(err)
+			}
+			if canRun, _, err := tt.store.Request("reset", 2, opts); err != nil || !canRun {
+				t.Errorf("Expected a grant after Reset, got canRun=%v err=%v", canRun, err)
+			}
+
+			// Resetting a limiter without state is not an error
+			if err := resetter.Reset("unknown"); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	if err := redisStore.Reset("reset"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"gothrottle:{reset}", "gothrottle:{reset}:window", "gothrottle:{reset}:slots"} {
+		if mr.Exists(key) {
+			t.Errorf("Expected Reset to delete %s", key)
+		}
+	}
+}
+
+func TestDatastore_CurrentUsage(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	local := gothrottle.NewLocalStore()
+	stores := []struct {
+		name  string
+		store gothrottle.Datastore
+	}{
+		{"local", local},
+		{"redis", redisStore},
+		{"chained", gothrottle.NewChainedStore(gothrottle.NewLocalStore(), gothrottle.NewLocalStore())},
+	}
+
+	opts := gothrottle.Options{MaxConcurrent: 5}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter, ok := tt.store.(gothrottle.UsageReporter)
+			if !ok {
+				t.Fatal("Expected the store to implement UsageReporter")
+			}
+
+			// A limiter without state has nothing running
+			if running, err := reporter.CurrentUsage("usage"); err != nil || running != 0 {
+				t.Fatalf("Expected 0 running, got %d, %v", running, err)
+			}
+
+			for _, weight := range []int{2, 1} {
+				if canRun, _, err := tt.store.Request("usage", weight, opts); err != nil || !canRun {
+					t.Fatalf("Expected a grant, got canRun=%v err=%v", canRun, err)
+				}
+			}
+			if running, err := reporter.CurrentUsage("usage"); err != nil || running != 3 {
+				t.Errorf("Expected 3 running, got %d, %v", running, err)
+			}
+
+			if err := tt.store.RegisterDone("usage", 2); err != nil {
+				t.Fatal(err)
+			}
+			if running, err := reporter.CurrentUsage("usage"); err != nil || running != 1 {
+				t.Errorf("Expected 1 running, got %d, %v", running, err)
+			}
+		})
+	}
+
+	chained := gothrottle.NewChainedStore(gothrottle.NewLocalStore(), plainStore{gothrottle.NewLocalStore()})
+	if _, err := chained.CurrentUsage("usage"); err != gothrottle.ErrUsageUnsupported {
+		t.Errorf("Expected ErrUsageUnsupported, got %v", err)
+	}
+}
+
+func TestLimiter_StatsStoreRunning(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	opts := gothrottle.Options{ID: "shared", Datastore: store, MaxConcurrent: 5}
+	limiter, err := gothrottle.NewLimiter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Another instance sharing the ID holds weight 2
+	if canRun, _, err := store.Request("shared", 2, opts); err != nil || !canRun {
+		t.Fatalf("Expected a grant, got canRun=%v err=%v", canRun, err)
+	}
+
+	release := make(chan struct{})
+	handle := limiter.Submit(func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	waitForRunning(t, limiter, 1)
+
+	if stats := limiter.Stats(); stats.Running != 1 || stats.StoreRunning != 3 {
+		t.Errorf("Expected 1 running locally and 3 in the store, got %d and %d", stats.Running, stats.StoreRunning)
+	}
+	close(release)
+	if _, err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := gothrottle.NewLimiter(gothrottle.Options{ID: "plain", Datastore: plainStore{gothrottle.NewLocalStore()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = plain.Stop() }() // Ignore error in test cleanup
+	if stats := plain.Stats(); stats.StoreRunning != -1 {
+		t.Errorf("Expected StoreRunning -1 without UsageReporter, got %d", stats.StoreRunning)
+	}
+}
+
+func TestLocalStore_Snapshot(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	clock := clocktest.NewFakeClock(time.Unix(100, 0))
+	opts := gothrottle.Options{Clock: clock}
+
+	for _, id := range []string{"a", "a", "b"} {
+		if _, _, err := store.Request(id, 1, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	states := store.Snapshot()
+	want := map[string]gothrottle.StateSnapshot{
+		"a": {Running: 2, LastStart: time.Unix(100, 0)},
+		"b": {Running: 1, LastStart: time.Unix(100, 0)},
+	}
+	if len(states) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, states)
+	}
+	for id, state := range want {
+		if got := states[id]; got.Running != state.Running || !got.LastStart.Equal(state.LastStart) {
+			t.Errorf("Expected %q to be %+v, got %+v", id, state, got)
+		}
+	}
+
+	// The snapshot is a copy
+	states["a"] = gothrottle.StateSnapshot{}
+	delete(states, "b")
+	if running, _ := store.CurrentUsage("a"); running != 2 {
+		t.Errorf("Expected the store to be unaffected, got %d running", running)
+	}
+	if again := store.Snapshot(); len(again) != 2 || again["a"].Running != 2 {
+		t.Errorf("Expected a fresh snapshot to be unaffected, got %v", again)
+	}
+
+	_ = store.Disconnect()
+	if states := store.Snapshot(); states != nil {
+		t.Errorf("Expected nil from a closed store, got %v", states)
+	}
+}
+
+func TestLimiter_HealthCheck(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "health", Datastore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := limiter.HealthCheck(ctx); err != nil {
+		t.Errorf("Expected a healthy limiter, got %v", err)
+	}
+
+	if err := store.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Ping(ctx); !errors.Is(err, gothrottle.ErrStoreClosed) {
+		t.Errorf("Expected ErrStoreClosed from a closed LocalStore, got %v", err)
+	}
+	if err := limiter.HealthCheck(ctx); !errors.Is(err, gothrottle.ErrStoreClosed) {
+		t.Errorf("Expected ErrStoreClosed with the store closed, got %v", err)
+	}
+	if limiter.Healthy() {
+		t.Error("Expected Healthy to be false with the store closed")
+	}
+
+	_ = limiter.Stop() // The store is already closed
+	if err := limiter.HealthCheck(ctx); !errors.Is(err, gothrottle.ErrStoreClosed) {
+		t.Errorf("Expected ErrStoreClosed from a stopped limiter, got %v", err)
+	}
+}
+
+// plainStore hides the optional interfaces of the Datastore it wraps.
+type plainStore struct {
+	gothrottle.Datastore
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	opts := gothrottle.Options{ID: "reset", Datastore: store, MaxConcurrent: 1}
+	limiter, err := gothrottle.NewLimiter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Simulate a slot leaked by a crashed instance sharing the ID
+	if canRun, _, err := store.Request("reset", 1, opts); err != nil || !canRun {
+		t.Fatalf("Expected a free slot, got canRun=%v err=%v", canRun, err)
+	}
+	handle := limiter.Submit(func() (interface{}, error) { return "ok", nil })
+	select {
+	case <-handle.Done():
+		t.Fatal("Expected the job to wait for the leaked slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := limiter.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := handle.Wait(); err != nil || result != "ok" {
+		t.Errorf("Expected the queued job to run after Reset, got %v, %v", result, err)
+	}
+
+	plain, err := gothrottle.NewLimiter(gothrottle.Options{ID: "plain", Datastore: plainStore{gothrottle.NewLocalStore()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = plain.Stop() }() // Ignore error in test cleanup
+	if err := plain.Reset(); err != gothrottle.ErrResetUnsupported {
+		t.Errorf("Expected ErrResetUnsupported, got %v", err)
+	}
+}
+
+// batchingStore counts how a LocalStore is told about finished jobs.
+type batchingStore struct {
+	*gothrottle.LocalStore
+	mu          sync.Mutex
+	doneCalls   int
+	batchCalls  int
+	batchWeight int
+}
+
+func (bs *batchingStore) RegisterDone(limiterID string, weight int) error {
+	bs.mu.Lock()
+	bs.doneCalls++
+	bs.mu.Unlock()
+	return bs.LocalStore.RegisterDone(limiterID, weight)
+}
+
+func (bs *batchingStore) BatchRegisterDone(limiterID string, totalWeight int) error {
+	bs.mu.Lock()
+	bs.batchCalls++
+	bs.batchWeight += totalWeight
+	bs.mu.Unlock()
+	return bs.LocalStore.RegisterDone(limiterID, totalWeight)
+}
+
+func TestLimiter_DoneFlushInterval(t *testing.T) {
+	store := &batchingStore{LocalStore: gothrottle.NewLocalStore()}
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:                "batched",
+		Datastore:         store,
+		MaxConcurrent:     10,
+		DoneFlushInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	const jobs = 20
+	tasks := make([]func() (interface{}, error), jobs)
+	for i := range tasks {
+		tasks[i] = func() (interface{}, error) { return nil, nil }
+	}
+	_, errs := limiter.BatchSchedule(tasks, 5, 1)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Idle means every batched completion has been flushed
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.doneCalls != 0 {
+		t.Errorf("Expected no single RegisterDone calls, got %d", store.doneCalls)
+	}
+	if store.batchWeight != jobs {
+		t.Errorf("Expected a released weight of %d, got %d", jobs, store.batchWeight)
+	}
+	if store.batchCalls == 0 || store.batchCalls >= jobs {
+		t.Errorf("Expected completions to be coalesced into fewer than %d calls, got %d", jobs, store.batchCalls)
+	}
+	if canRun, _, err := store.Peek("batched", 10, gothrottle.Options{MaxConcurrent: 10}); err != nil || !canRun {
+		t.Errorf("Expected every slot to be free after the flush, got canRun=%v err=%v", canRun, err)
+	}
+}
@@ -0,0 +1,170 @@
+// FILENAME: generic_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func TestSchedule_Typed(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	u, err := gothrottle.Schedule(limiter, func() (*user, error) {
+		return &user{ID: 1, Name: "alice"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "alice" {
+		t.Errorf("Expected 'alice', got %q", u.Name)
+	}
+
+	// Errors return the zero value instead of panicking on a type assertion
+	errLookup := errors.New("lookup failed")
+	u, err = gothrottle.Schedule(limiter, func() (*user, error) {
+		return nil, errLookup
+	})
+	if err != errLookup {
+		t.Errorf("Expected %v, got %v", errLookup, err)
+	}
+	if u != nil {
+		t.Errorf("Expected nil user on error, got %v", u)
+	}
+
+	n, err := gothrottle.Schedule(limiter, func() (int, error) {
+		return 42, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Errorf("Expected 42, got %d", n)
+	}
+}
+
+func TestWrapFunc1(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	errNotFound := errors.New("user not found")
+	findUser := gothrottle.WrapFunc1(limiter, func(id int) (*user, error) {
+		if id != 1 {
+			return nil, errNotFound
+		}
+		return &user{ID: id, Name: "alice"}, nil
+	})
+
+	u, err := findUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 1 || u.Name != "alice" {
+		t.Errorf("Expected user 1 'alice', got %+v", u)
+	}
+
+	if _, err := findUser(2); err != errNotFound {
+		t.Errorf("Expected %v, got %v", errNotFound, err)
+	}
+}
+
+func TestWrapTyped(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	calls := 0
+	count := gothrottle.WrapTyped(limiter, func() (int, error) {
+		calls++
+		return calls, nil
+	})
+
+	for want := 1; want <= 3; want++ {
+		got, err := count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 1; i <= 10; i++ {
+			in <- i
+		}
+	}()
+
+	var mu sync.Mutex
+	var running, peak int
+	var sum atomic.Int64
+	errOdd := errors.New("odd")
+	errs := gothrottle.Process(limiter, in, func(n int) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		sum.Add(int64(n))
+		if n%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+
+	failed := 0
+	for err := range errs {
+		if !errors.Is(err, errOdd) {
+			t.Errorf("Expected errOdd, got %v", err)
+		}
+		failed++
+	}
+	if failed != 5 {
+		t.Errorf("Expected 5 failed items, got %d", failed)
+	}
+	if sum.Load() != 55 {
+		t.Errorf("Expected every item to be processed once, got sum %d", sum.Load())
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 items processed at once, got %d", peak)
+	}
+}
@@ -0,0 +1,143 @@
+// FILENAME: group_test.go
+package gothrottle_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestGroup_AggregateLimit(t *testing.T) {
+	group, err := gothrottle.NewGroup(gothrottle.Options{
+		ID:            "api",
+		MaxConcurrent: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	track := func(name string, delta int) {
+		mu.Lock()
+		defer mu.Unlock()
+		running[name] += delta
+		running["total"] += delta
+		for _, key := range []string{name, "total"} {
+			if running[key] > maxRunning[key] {
+				maxRunning[key] = running[key]
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"users", "orders"} {
+		limiter, err := group.NewLimiter(gothrottle.Options{
+			ID:            name,
+			MaxConcurrent: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				_, err := limiter.Schedule(func() (interface{}, error) {
+					track(name, 1)
+					time.Sleep(30 * time.Millisecond)
+					track(name, -1)
+					return nil, nil
+				})
+				if err != nil {
+					t.Errorf("Job failed: %v", err)
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning["total"] > 3 {
+		t.Errorf("Expected at most 3 jobs across the group, got %d", maxRunning["total"])
+	}
+	for _, name := range []string{"users", "orders"} {
+		if maxRunning[name] > 2 {
+			t.Errorf("Expected at most 2 %s jobs, got %d", name, maxRunning[name])
+		}
+	}
+}
+
+func TestGroup_DenialKeepsChildWindow(t *testing.T) {
+	group, err := gothrottle.NewGroup(gothrottle.Options{
+		ID:            "api",
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }() // Ignore error in test cleanup
+
+	other, err := group.NewLimiter(gothrottle.Options{ID: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = other.Stop() }() // Ignore error in test cleanup
+	windowed, err := group.NewLimiter(gothrottle.Options{
+		ID:             "windowed",
+		WindowLimit:    1,
+		WindowDuration: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = windowed.Stop() }() // Ignore error in test cleanup
+
+	// Hold the group's only slot while the windowed child's job is retried
+	release := make(chan struct{})
+	started := make(chan struct{})
+	holder := other.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	job := windowed.Submit(func() (interface{}, error) { return nil, nil })
+	time.Sleep(50 * time.Millisecond)
+
+	// The denials did not use up the child's window, so the job runs once the
+	// group has room
+	close(release)
+	if _, err := holder.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the windowed job to start once the group had room")
+	}
+}
+
+func TestGroup_Validation(t *testing.T) {
+	if _, err := gothrottle.NewGroup(gothrottle.Options{}); err != gothrottle.ErrMissingID {
+		t.Errorf("Expected ErrMissingID for group, got %v", err)
+	}
+
+	group, err := gothrottle.NewGroup(gothrottle.Options{ID: "group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := group.NewLimiter(gothrottle.Options{}); err != gothrottle.ErrMissingID {
+		t.Errorf("Expected ErrMissingID for child, got %v", err)
+	}
+	if _, err := group.NewLimiter(gothrottle.Options{ID: "child", Datastore: gothrottle.NewLocalStore()}); err != gothrottle.ErrGroupDatastore {
+		t.Errorf("Expected ErrGroupDatastore, got %v", err)
+	}
+}
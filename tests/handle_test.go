@@ -0,0 +1,557 @@
+// FILENAME: handle_test.go
+package gothrottle_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestLimiter_Submit(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Fan out without blocking the caller
+	handles := make([]*gothrottle.JobHandle, 10)
+	for i := range handles {
+		i := i
+		handles[i] = limiter.Submit(func() (interface{}, error) {
+			time.Sleep(10 * time.Millisecond)
+			return fmt.Sprintf("job-%d", i), nil
+		})
+	}
+
+	// Collect results later
+	for i, h := range handles {
+		select {
+		case <-h.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Job %d did not finish", i)
+		}
+
+		result, err := h.Wait()
+		if err != nil {
+			t.Errorf("Job %d failed: %v", i, err)
+		}
+		if expected := fmt.Sprintf("job-%d", i); result != expected {
+			t.Errorf("Job %d: expected %s, got %v", i, expected, result)
+		}
+
+		// Wait can be called again
+		if again, _ := h.Wait(); again != result {
+			t.Errorf("Job %d: second Wait returned %v, expected %v", i, again, result)
+		}
+	}
+}
+
+func TestLimiter_SubmitAfterStop(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := limiter.Submit(func() (interface{}, error) {
+		return nil, nil
+	})
+	<-h.Done()
+	if _, err := h.Wait(); err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed, got %v", err)
+	}
+}
+
+func TestLimiter_Go(t *testing.T) {
+	var mu sync.Mutex
+	var goErrors []error
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 2,
+		OnGoError: func(err error) {
+			mu.Lock()
+			goErrors = append(goErrors, err)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ran atomic.Int32
+	taskErr := errors.New("telemetry upload failed")
+	for i := 0; i < 5; i++ {
+		i := i
+		limiter.Go(func() (interface{}, error) {
+			ran.Add(1)
+			if i == 0 {
+				return nil, taskErr
+			}
+			return i, nil
+		})
+	}
+
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran.Load(); got != 5 {
+		t.Errorf("Expected 5 jobs to run, got %d", got)
+	}
+
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	limiter.Go(func() (interface{}, error) { return nil, nil })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(goErrors) != 2 || goErrors[0] != taskErr || !errors.Is(goErrors[1], gothrottle.ErrStoreClosed) {
+		t.Errorf("Expected the task error and ErrStoreClosed, got %v", goErrors)
+	}
+}
+
+func TestLimiter_ScheduleCallback(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MinTime: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var results []interface{}
+	var errs []error
+	var wg sync.WaitGroup
+	cb := func(result interface{}, err error) {
+		mu.Lock()
+		results = append(results, result)
+		errs = append(errs, err)
+		mu.Unlock()
+		wg.Done()
+	}
+
+	// The first job runs, the second is still queued behind MinTime when the limiter stops
+	wg.Add(2)
+	limiter.ScheduleCallback(func() (interface{}, error) { return "first", nil }, cb)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(results) == 1
+	})
+	limiter.ScheduleCallback(func() (interface{}, error) { return "second", nil }, cb)
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// A job submitted after Stop is reported right away
+	wg.Add(1)
+	limiter.ScheduleCallback(func() (interface{}, error) { return "third", nil }, cb)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 3 || results[0] != "first" || errs[0] != nil {
+		t.Fatalf("Expected the first job to succeed, got %v, %v", results, errs)
+	}
+	for i := 1; i < 3; i++ {
+		if !errors.Is(errs[i], gothrottle.ErrStoreClosed) {
+			t.Errorf("Expected callback %d to get ErrStoreClosed, got %v", i, errs[i])
+		}
+	}
+}
+
+func TestLimiter_ScheduleAsync(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	futures := make([]*gothrottle.Future, 100)
+	for i := range futures {
+		i := i
+		futures[i] = limiter.ScheduleAsync(func() (interface{}, error) {
+			return i * 2, nil
+		})
+	}
+
+	for i, f := range futures {
+		result, err := f.Wait()
+		if err != nil {
+			t.Errorf("Job %d failed: %v", i, err)
+		}
+		if result != i*2 {
+			t.Errorf("Job %d: expected %d, got %v", i, i*2, result)
+		}
+	}
+}
+
+func TestJobHandle_Cancel(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	running := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	ran := false
+	queued := limiter.Submit(func() (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+
+	if !queued.Cancel() {
+		t.Error("Expected Cancel to remove the queued job")
+	}
+	if queued.Cancel() {
+		t.Error("Expected a second Cancel to return false")
+	}
+	if running.Cancel() {
+		t.Error("Expected Cancel of a running job to return false")
+	}
+	if _, err := queued.Wait(); err != gothrottle.ErrCanceled {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+
+	close(release)
+	if _, err := running.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("Cancelled job should not run")
+	}
+}
+
+func TestJobHandle_CancelAmongQueued(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocker := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	// Queue jobs of mixed priorities and cancel a low priority one from the middle
+	// of the heap
+	var mu sync.Mutex
+	var order []int
+	futures := make([]*gothrottle.Future, 6)
+	for i := range futures {
+		priority := i
+		futures[i] = limiter.SubmitWithOptions(func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			return nil, nil
+		}, priority, 1)
+	}
+	if !futures[2].Cancel() {
+		t.Fatal("Expected Cancel to remove the queued job")
+	}
+	if queued := limiter.Stats().Queued; queued != 5 {
+		t.Errorf("Expected 5 queued jobs after Cancel, got %d", queued)
+	}
+
+	close(release)
+	if _, err := blocker.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		_, err := f.Wait()
+		if i == 2 && err != gothrottle.ErrCanceled {
+			t.Errorf("Expected ErrCanceled for the cancelled job, got %v", err)
+		} else if i != 2 && err != nil {
+			t.Errorf("Job %d failed: %v", i, err)
+		}
+	}
+
+	// The rest still run in priority order
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int{5, 4, 3, 1, 0}
+	if len(order) != len(want) {
+		t.Fatalf("Expected order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, order)
+		}
+	}
+}
+
+func TestJobHandle_Release(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	finish := make(chan struct{})
+	started := make(chan struct{})
+	heavy := limiter.SubmitWithOptions(func() (interface{}, error) {
+		close(started)
+		<-finish
+		return nil, nil
+	}, 5, 5)
+	<-started
+
+	light := limiter.SubmitWithOptions(func() (interface{}, error) {
+		return "light", nil
+	}, 5, 3)
+	select {
+	case <-light.Done():
+		t.Fatal("Expected the light job to wait for the heavy job's weight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := heavy.Release(3); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result, err := light.Wait(); err != nil || result != "light" {
+		t.Errorf("Expected the light job to run after Release, got %v, %v", result, err)
+	}
+	if err := heavy.Release(3); !errors.Is(err, gothrottle.ErrAccountingMismatch) {
+		t.Errorf("Expected ErrAccountingMismatch releasing more than is held, got %v", err)
+	}
+	if err := heavy.Release(0); err != gothrottle.ErrInvalidWeight {
+		t.Errorf("Expected ErrInvalidWeight, got %v", err)
+	}
+
+	close(finish)
+	if _, err := heavy.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := heavy.Release(1); err != gothrottle.ErrJobNotRunning {
+		t.Errorf("Expected ErrJobNotRunning after the job finished, got %v", err)
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the weight still held was released when the heavy job finished
+	acquired, release, err := limiter.TryAcquire(5)
+	if err != nil || !acquired {
+		t.Fatalf("Expected the full weight to be free, got %v, %v", acquired, err)
+	}
+	release()
+	acquired, release, err = limiter.TryAcquire(1)
+	if err != nil || !acquired {
+		t.Fatalf("Expected a slot to be free, got %v, %v", acquired, err)
+	}
+	defer release()
+	if acquired, _, _ := limiter.TryAcquire(5); acquired {
+		t.Error("Expected the running count not to have dropped below the held weight")
+	}
+}
+
+func TestLimiter_ScheduleWithKey(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var executions int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	task := func() (interface{}, error) {
+		if atomic.AddInt32(&executions, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "warm", nil
+	}
+
+	const callers = 10
+	results := make(chan interface{}, callers)
+	var wg sync.WaitGroup
+	call := func() {
+		defer wg.Done()
+		result, err := limiter.ScheduleWithKey("cache:users", task)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}
+
+	wg.Add(callers)
+	go call()
+	<-started
+	for i := 1; i < callers; i++ {
+		go call()
+	}
+	time.Sleep(20 * time.Millisecond) // Let the other callers attach
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := atomic.LoadInt32(&executions); n != 1 {
+		t.Errorf("Expected the task to run once, ran %d times", n)
+	}
+	for result := range results {
+		if result != "warm" {
+			t.Errorf("Expected every caller to get 'warm', got %v", result)
+		}
+	}
+
+	// Once the job has finished, the key is free again
+	if _, err := limiter.ScheduleWithKey("cache:users", task); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&executions); n != 2 {
+		t.Errorf("Expected a new job after the first one finished, got %d executions", n)
+	}
+}
+
+func TestLimiter_ScheduleDedup(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var executions int32
+	release := make(chan struct{})
+	errLookup := errors.New("lookup failed")
+	task := func() (interface{}, error) {
+		atomic.AddInt32(&executions, 1)
+		<-release
+		return nil, errLookup
+	}
+
+	const callers = 50
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := limiter.ScheduleDedup("cache:config", task)
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond) // Let every caller attach
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if n := atomic.LoadInt32(&executions); n != 1 {
+		t.Errorf("Expected the task to run once, ran %d times", n)
+	}
+	for err := range errs {
+		if err != errLookup {
+			t.Errorf("Expected every caller to get the task's error, got %v", err)
+		}
+	}
+}
+
+// slotStore tracks each granted slot by weight and only releases a slot of the exact
+// weight it was granted with, like etcdstore.
+type slotStore struct {
+	mu    sync.Mutex
+	slots []int
+}
+
+func (ss *slotStore) Request(limiterID string, weight int, opts gothrottle.Options) (bool, time.Duration, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.slots = append(ss.slots, weight)
+	return true, 0, nil
+}
+
+func (ss *slotStore) RegisterDone(limiterID string, weight int) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for i, w := range ss.slots {
+		if w == weight {
+			ss.slots = append(ss.slots[:i], ss.slots[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no slot of weight %d", weight)
+}
+
+func (ss *slotStore) Disconnect() error { return nil }
+
+func (ss *slotStore) held() []int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]int(nil), ss.slots...)
+}
+
+func TestJobHandle_ReleaseUnsupported(t *testing.T) {
+	store := &slotStore{}
+	chained := gothrottle.NewChainedStore(gothrottle.NewLocalStore(), store)
+
+	for name, datastore := range map[string]gothrottle.Datastore{"slots": store, "chained": chained} {
+		t.Run(name, func(t *testing.T) {
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "slots", Datastore: datastore, MaxConcurrent: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			finish := make(chan struct{})
+			started := make(chan struct{})
+			heavy := limiter.SubmitWithOptions(func() (interface{}, error) {
+				close(started)
+				<-finish
+				return nil, nil
+			}, 5, 5)
+			<-started
+
+			if err := heavy.Release(2); err != gothrottle.ErrPartialReleaseUnsupported {
+				t.Errorf("Expected ErrPartialReleaseUnsupported, got %v", err)
+			}
+			if held := store.held(); fmt.Sprint(held) != "[5]" {
+				t.Errorf("Expected the weight-5 slot to be untouched, got %v", held)
+			}
+
+			// The job still releases its whole slot when it finishes
+			close(finish)
+			if _, err := heavy.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if held := store.held(); len(held) != 0 {
+				t.Errorf("Expected every slot to be released, got %v", held)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// FILENAME: hooks_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestLimiter_Hooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	var durations []time.Duration
+	var errs []error
+	var waits []time.Duration
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MinTime: 50 * time.Millisecond,
+		Hooks: &gothrottle.Hooks{
+			OnQueued: func(priority, weight int) { record("queued") },
+			OnStart:  func(priority, weight int) { record("start") },
+			OnDone: func(duration time.Duration, err error) {
+				record("done")
+				mu.Lock()
+				durations = append(durations, duration)
+				errs = append(errs, err)
+				mu.Unlock()
+			},
+			OnReject: func(waitTime time.Duration, priority, weight int) {
+				record("reject")
+				mu.Lock()
+				waits = append(waits, waitTime)
+				mu.Unlock()
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	errTask := errors.New("task failed")
+	first := limiter.Submit(func() (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	})
+	second := limiter.Submit(func() (interface{}, error) { return nil, errTask })
+	if _, err := first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Wait(); !errors.Is(err, errTask) {
+		t.Fatalf("Expected errTask, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	count := map[string]int{}
+	for _, event := range events {
+		count[event]++
+	}
+	if count["queued"] != 2 || count["start"] != 2 || count["done"] != 2 {
+		t.Errorf("Expected 2 queued, started and done events, got %v", events)
+	}
+	// The second job is denied by MinTime at least once
+	if count["reject"] == 0 {
+		t.Errorf("Expected a reject event, got %v", events)
+	}
+	for _, wait := range waits {
+		if wait <= 0 || wait > 50*time.Millisecond {
+			t.Errorf("Expected a suggested wait within MinTime, got %v", wait)
+		}
+	}
+	if durations[0] < 10*time.Millisecond {
+		t.Errorf("Expected the first job to run for at least 10ms, got %v", durations[0])
+	}
+	if errs[0] != nil || !errors.Is(errs[1], errTask) {
+		t.Errorf("Expected OnDone errors [nil errTask], got %v", errs)
+	}
+}
@@ -0,0 +1,232 @@
+// FILENAME: http_test.go
+package gothrottle_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
+)
+
+func TestLimiter_WrapHandler(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		HighWater:     1,
+		Strategy:      gothrottle.StrategyReject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	handler := limiter.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(ctx context.Context) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			done <- rec
+		}()
+		return done
+	}
+
+	running := serve(context.Background())
+	<-started
+
+	// A queued request whose client disconnects leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := serve(ctx)
+	waitForQueued(t, limiter, 1)
+	cancel()
+	<-abandoned
+	waitForQueued(t, limiter, 0)
+
+	queued := serve(context.Background())
+	waitForQueued(t, limiter, 1)
+
+	// The queue is full, so the next request is turned away
+	rec := <-serve(context.Background())
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Expected Retry-After of 1 second, got %q", retryAfter)
+	}
+
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{running, queued} {
+		if rec := <-done; rec.Code != http.StatusNoContent {
+			t.Errorf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		MinTime:       2500 * time.Millisecond,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{}, 1)
+	handler := gothrottle.Middleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func() <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			done <- rec
+		}()
+		return done
+	}
+	expect := func(rec *httptest.ResponseRecorder, code int, retryAfter string) {
+		t.Helper()
+		if rec.Code != code {
+			t.Errorf("Expected status %d, got %d", code, rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != retryAfter {
+			t.Errorf("Expected Retry-After %q, got %q", retryAfter, got)
+		}
+	}
+
+	running := serve()
+	deadline := time.Now().Add(time.Second)
+	for limiter.Stats().Running != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the first request to be running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The only slot is taken
+	expect(<-serve(), http.StatusServiceUnavailable, "1")
+
+	release <- struct{}{}
+	expect(<-running, http.StatusNoContent, "")
+
+	// The slot is free again but MinTime has not passed, so the wait is rounded up
+	expect(<-serve(), http.StatusServiceUnavailable, "3")
+
+	clock.Advance(2500 * time.Millisecond)
+	release <- struct{}{}
+	expect(<-serve(), http.StatusNoContent, "")
+}
+
+// waitForQueued waits until the limiter has exactly n queued jobs.
+func waitForQueued(t *testing.T, limiter *gothrottle.Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for limiter.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued jobs, got %d", n, limiter.Stats().Queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestThrottledTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MinTime: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	client := &http.Client{Transport: gothrottle.NewThrottledTransport(nil, limiter)}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "pong" {
+			t.Errorf("Expected body %q, got %q", "pong", body)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected requests to be spaced by MinTime, took %v", elapsed)
+	}
+
+	// A request whose context ends while it waits for MinTime fails with the context error
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLimiter_CooldownFromResponse(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(1700000000, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"no headers", http.Header{}, 0},
+		{"retry after seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"retry after date", http.Header{"Retry-After": {clock.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat)}}, 5 * time.Second},
+		{"retry after in the past", http.Header{"Retry-After": {clock.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)}}, 0},
+		{"remaining requests", http.Header{"X-Ratelimit-Remaining": {"7"}, "X-Ratelimit-Reset": {"30"}}, 0},
+		{"reset in seconds", http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"30"}}, 30 * time.Second},
+		{"reset timestamp", http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"1700000060"}}, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter.SetCooldown(0)
+			if got := limiter.CooldownFromResponse(&http.Response{Header: tt.header}); got != tt.want {
+				t.Errorf("Expected cooldown %v, got %v", tt.want, got)
+			}
+			if _, wait, err := limiter.CheckAvailable(); err != nil || wait != tt.want {
+				t.Errorf("Expected CheckAvailable to report %v, got %v (%v)", tt.want, wait, err)
+			}
+		})
+	}
+
+	// A shorter cooldown does not cut a longer one short
+	limiter.SetCooldown(time.Minute)
+	limiter.CooldownFromResponse(&http.Response{Header: http.Header{"Retry-After": {"1"}}})
+	if _, wait, _ := limiter.CheckAvailable(); wait != time.Minute {
+		t.Errorf("Expected the cooldown to stay at 1m, got %v", wait)
+	}
+}
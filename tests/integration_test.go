@@ -2,8 +2,10 @@
 package gothrottle_test
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -158,3 +160,81 @@ func BenchmarkLimiter(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkLimiter_Semaphore measures scheduling latency when only MaxConcurrent is
+// set, so the limiter acts as a pure semaphore, with more callers than slots.
+// Schedule takes a free slot directly, while a cancellable context always goes
+// through the queue and the scheduler.
+func BenchmarkLimiter_Semaphore(b *testing.B) {
+	modes := []struct {
+		name     string
+		schedule func(l *gothrottle.Limiter, task func() (interface{}, error)) (interface{}, error)
+	}{
+		{"direct", func(l *gothrottle.Limiter, task func() (interface{}, error)) (interface{}, error) {
+			return l.Schedule(task)
+		}},
+		{"queued", func(l *gothrottle.Limiter, task func() (interface{}, error)) (interface{}, error) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			return l.ScheduleContext(ctx, task)
+		}},
+	}
+
+	for _, tt := range modes {
+		b.Run(tt.name, func(b *testing.B) {
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 4})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := tt.schedule(limiter, func() (interface{}, error) {
+						return nil, nil
+					})
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkLocalStore_Parallel measures Request and RegisterDone from parallel
+// goroutines on one limiter ID and on one ID per goroutine. LocalStore stripes
+// its state by ID, so distinct IDs mostly take different locks and scale with
+// GOMAXPROCS, while a single ID serializes on one lock.
+func BenchmarkLocalStore_Parallel(b *testing.B) {
+	for _, shared := range []bool{true, false} {
+		name := "distinct-ids"
+		if shared {
+			name = "one-id"
+		}
+		b.Run(name, func(b *testing.B) {
+			store := gothrottle.NewLocalStore()
+			defer func() { _ = store.Disconnect() }() // Ignore error in test cleanup
+			opts := gothrottle.Options{MaxConcurrent: 1 << 20}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := "bench"
+				if !shared {
+					id = fmt.Sprintf("bench-%d", next.Add(1))
+				}
+				for pb.Next() {
+					if _, _, err := store.Request(id, 1, opts); err != nil {
+						b.Error(err)
+					}
+					if err := store.RegisterDone(id, 1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -2,12 +2,17 @@
 package gothrottle_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/clocktest"
 )
 
 func TestLimiter_MaxConcurrent(t *testing.T) {
@@ -98,6 +103,125 @@ func TestLimiter_MinTime(t *testing.T) {
 	}
 }
 
+func TestLimiter_MinTimeQueued(t *testing.T) {
+	const jobs = 20
+	minTime := 15 * time.Millisecond
+
+	stores := []struct {
+		name     string
+		newStore func(t *testing.T) gothrottle.Datastore
+		// RedisStore stamps the start time before the round trip, so single gaps
+		// seen by the client carry the latency jitter of two calls
+		checkGaps bool
+	}{
+		{"local", func(t *testing.T) gothrottle.Datastore { return gothrottle.NewLocalStore() }, true},
+		{"redis", func(t *testing.T) gothrottle.Datastore {
+			store, _ := newTestRedisStore(t)
+			return store
+		}, false},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var times []time.Time
+
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+				ID:        "min-time-queued",
+				MinTime:   minTime,
+				Datastore: tt.newStore(t),
+				// Called as soon as the store grants a job, before its goroutine starts
+				OnQueueWait: func(wait time.Duration, priority, weight int) {
+					mu.Lock()
+					times = append(times, time.Now())
+					mu.Unlock()
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			tasks := make([]func() (interface{}, error), jobs)
+			for i := range tasks {
+				tasks[i] = func() (interface{}, error) { return nil, nil }
+			}
+
+			// Queue all jobs at once so every gap is decided by the scheduler
+			_, errs := limiter.BatchSchedule(tasks, 5, 1)
+			for _, err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tolerance := 3 * time.Millisecond
+			if tt.checkGaps {
+				for i := 1; i < len(times); i++ {
+					if gap := times[i].Sub(times[i-1]); gap < minTime-tolerance {
+						t.Errorf("Gap %d too short: %v < %v", i, gap, minTime)
+					}
+				}
+			}
+
+			// Spacing must not drift with queue depth
+			mean := times[len(times)-1].Sub(times[0]) / time.Duration(jobs-1)
+			if mean < minTime-tolerance || mean > minTime+minTime/2 {
+				t.Errorf("Mean gap %v over %d jobs, expected about %v", mean, jobs, minTime)
+			}
+		})
+	}
+}
+
+func TestLimiter_MinTimeJitter(t *testing.T) {
+	const jobs = 12
+	minTime := 30 * time.Millisecond
+	minTimeJitter := 20 * time.Millisecond
+
+	var mu sync.Mutex
+	var times []time.Time
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MinTime:       minTime,
+		MinTimeJitter: minTimeJitter,
+		OnQueueWait: func(wait time.Duration, priority, weight int) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	tasks := make([]func() (interface{}, error), jobs)
+	for i := range tasks {
+		tasks[i] = func() (interface{}, error) { return nil, nil }
+	}
+	_, errs := limiter.BatchSchedule(tasks, 5, 1)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Waking early is denied by the store, so gaps never fall below MinTime
+	// and overshoot it by at most the jitter
+	tolerance := 3 * time.Millisecond
+	slack := 15 * time.Millisecond
+	gaps := make(map[time.Duration]bool)
+	for i := 1; i < len(times); i++ {
+		gap := times[i].Sub(times[i-1])
+		if gap < minTime-tolerance || gap > minTime+minTimeJitter+slack {
+			t.Errorf("Gap %d out of bounds: %v not in [%v, %v]", i, gap, minTime, minTime+minTimeJitter)
+		}
+		gaps[gap.Truncate(2*time.Millisecond)] = true
+	}
+	if len(gaps) < 2 {
+		t.Errorf("Expected jittered gaps to differ, got %v", gaps)
+	}
+}
+
 func TestLimiter_Priority(t *testing.T) {
 	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
 		MaxConcurrent: 1, // Force serialization
@@ -169,6 +293,79 @@ func TestLimiter_Weight(t *testing.T) {
 	}
 }
 
+func TestLimiter_ReservePriority(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent:   3,
+		ReservePriority: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var order []string
+	light := func(name string, release <-chan struct{}) *gothrottle.JobHandle {
+		return limiter.SubmitWithOptions(func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			<-release
+			return nil, nil
+		}, 8, 1)
+	}
+
+	// Two higher priority light jobs hold 2 of the 3 slots
+	releaseFirst, releaseSecond := make(chan struct{}), make(chan struct{})
+	first, second := light("first", releaseFirst), light("second", releaseSecond)
+	waitForRunning(t, limiter, 2)
+
+	// The heavy job is denied and reserves the capacity it needs
+	heavy := limiter.SubmitWithOptions(func() (interface{}, error) {
+		mu.Lock()
+		order = append(order, "heavy")
+		mu.Unlock()
+		return nil, nil
+	}, 5, 3)
+	time.Sleep(20 * time.Millisecond)
+
+	// A new light job would fit, but waits behind the reservation
+	released := make(chan struct{})
+	close(released)
+	third := light("third", released)
+	select {
+	case <-third.Done():
+		t.Error("Expected the light job to wait for the reserved heavy job")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	close(releaseFirst)
+	close(releaseSecond)
+	for _, handle := range []*gothrottle.JobHandle{first, second, heavy, third} {
+		if _, err := handle.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(order[2:], ","); got != "heavy,third" {
+		t.Errorf("Expected the heavy job to run before the later light job, got %v", order)
+	}
+}
+
+// waitForRunning waits up to a second until n jobs of limiter are running.
+func waitForRunning(t *testing.T, limiter *gothrottle.Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for limiter.Stats().Running != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d running jobs", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestLimiter_Stop(t *testing.T) {
 	limiter, err := gothrottle.NewLimiter(gothrottle.Options{})
 	if err != nil {
@@ -295,3 +492,1479 @@ func TestLocalStore_MinTime(t *testing.T) {
 		t.Error("Request after waiting should be allowed")
 	}
 }
+
+func TestLocalStore_IdleTTL(t *testing.T) {
+	store := gothrottle.NewLocalStoreWithTTL(30 * time.Millisecond)
+	defer func() { _ = store.Disconnect() }() // Ignore error in test cleanup
+
+	// Neither limiter would be allowed another job for an hour
+	idle := gothrottle.Options{MinTime: time.Hour}
+	busy := gothrottle.Options{MaxConcurrent: 1, MinTime: time.Hour}
+	for id, opts := range map[string]gothrottle.Options{"idle": idle, "busy": busy} {
+		if canRun, _, err := store.Request(id, 1, opts); err != nil || !canRun {
+			t.Fatalf("First request for %q should be allowed, got %v, %v", id, canRun, err)
+		}
+	}
+	if err := store.RegisterDone("idle", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The idle limiter was forgotten, so MinTime starts over
+	canRun, _, err := store.Request("idle", 1, idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("Request for an evicted limiter should be allowed")
+	}
+
+	// A limiter with a running job is kept
+	canRun, _, err = store.Request("busy", 1, busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request for a limiter with a running job should still be denied")
+	}
+}
+
+func TestLimiter_ScheduleContext(t *testing.T) {
+	var mu sync.Mutex
+	var waits []time.Duration
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		OnQueueWait: func(wait time.Duration, priority, weight int) {
+			mu.Lock()
+			waits = append(waits, wait)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Occupy the only slot
+	release := make(chan struct{})
+	go func() {
+		_, _ = limiter.Schedule(func() (interface{}, error) {
+			<-release
+			return nil, nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A queued job whose context expires should be abandoned
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	executed := false
+	_, err = limiter.ScheduleContext(ctx, func() (interface{}, error) {
+		executed = true
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+
+	result, err := limiter.ScheduleContext(context.Background(), func() (interface{}, error) {
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "ok" {
+		t.Errorf("Expected 'ok', got %v", result)
+	}
+	if executed {
+		t.Error("Cancelled job should not have executed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(waits) != 3 {
+		t.Errorf("Expected 3 queue wait reports, got %d", len(waits))
+	}
+}
+
+func TestLimiter_PriorityAging(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		PriorityAging: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		}
+	}
+
+	// Occupy the only slot so the low-priority job has to queue
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = limiter.Schedule(record("blocker"))
+	}()
+	time.Sleep(5 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		_, _ = limiter.ScheduleWithOptions(record("low"), 1, 1)
+	}()
+
+	// Keep the limiter saturated with high-priority jobs
+	stop := time.After(300 * time.Millisecond)
+	ticker := time.NewTicker(2 * time.Millisecond)
+	defer ticker.Stop()
+produce:
+	for {
+		select {
+		case <-stop:
+			break produce
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = limiter.ScheduleWithOptions(record("high"), 10, 1)
+			}()
+		}
+	}
+	wg.Wait()
+
+	lowIndex := -1
+	for i, name := range order {
+		if name == "low" {
+			lowIndex = i
+		}
+	}
+	if lowIndex < 0 {
+		t.Fatal("Low-priority job never executed")
+	}
+	if lowIndex == len(order)-1 {
+		t.Errorf("Low-priority job was starved until all %d high-priority jobs finished", len(order)-1)
+	}
+}
+
+func TestLimiter_PanicRecovery(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	_, err = limiter.Schedule(func() (interface{}, error) {
+		panic("boom")
+	})
+	if err == nil {
+		t.Fatal("Expected error from panicking task")
+	}
+	if !errors.Is(err, gothrottle.ErrTaskPanicked) {
+		t.Errorf("Expected ErrTaskPanicked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected error to contain the panic value, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "goroutine") {
+		t.Errorf("Expected error to contain a stack trace, got %v", err)
+	}
+
+	// The slot must have been released for the limiter to stay usable
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err := limiter.Schedule(func() (interface{}, error) {
+			return "ok", nil
+		})
+		if err != nil || result != "ok" {
+			t.Errorf("Expected 'ok', got %v, %v", result, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Limiter is unusable after a task panicked")
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    gothrottle.Options
+		wantErr error
+	}{
+		{"zero value", gothrottle.Options{}, nil},
+		{"typical", gothrottle.Options{MaxConcurrent: 5, MinTime: 100 * time.Millisecond, HighWater: 10, Strategy: gothrottle.StrategyReject}, nil},
+		{"window", gothrottle.Options{WindowLimit: 10, WindowDuration: time.Second}, nil},
+		{"gcra", gothrottle.Options{MinTime: time.Second, Algorithm: gothrottle.AlgorithmGCRA, Burst: 3}, nil},
+		{"priority bounds", gothrottle.Options{MinPriority: 1, MaxPriority: 10}, nil},
+		{"min priority only", gothrottle.Options{MinPriority: 3}, nil},
+		{"adaptive", gothrottle.Options{Adaptive: &gothrottle.AdaptiveConcurrency{MinLimit: 1, MaxLimit: 8, Backoff: 0.7}}, nil},
+		{"key ttl", gothrottle.Options{MinTime: time.Second, KeyTTL: time.Minute}, nil},
+		{"negative max concurrent", gothrottle.Options{MaxConcurrent: -1}, gothrottle.ErrInvalidOptions},
+		{"negative high water", gothrottle.Options{HighWater: -1}, gothrottle.ErrInvalidOptions},
+		{"negative min time", gothrottle.Options{MinTime: -time.Second}, gothrottle.ErrInvalidOptions},
+		{"negative max queue time", gothrottle.Options{MaxQueueTime: -time.Second}, gothrottle.ErrInvalidOptions},
+		{"negative poll interval", gothrottle.Options{PollInterval: -time.Millisecond}, gothrottle.ErrInvalidOptions},
+		{"negative dispatch goroutines", gothrottle.Options{MaxDispatchGoroutines: -1}, gothrottle.ErrInvalidOptions},
+		{"zero priority share", gothrottle.Options{PriorityShares: map[int]int{5: 0}}, gothrottle.ErrInvalidOptions},
+		{"priority shares with tiers", gothrottle.Options{PriorityShares: map[int]int{5: 1}, Tiers: []gothrottle.Tier{{Name: "a", Share: 1}}}, gothrottle.ErrInvalidOptions},
+		{"inverted priority bounds", gothrottle.Options{MinPriority: 10, MaxPriority: 1}, gothrottle.ErrInvalidOptions},
+		{"window limit without duration", gothrottle.Options{WindowLimit: 10}, gothrottle.ErrInvalidOptions},
+		{"window duration without limit", gothrottle.Options{WindowDuration: time.Second}, gothrottle.ErrInvalidOptions},
+		{"key ttl below min time", gothrottle.Options{MinTime: time.Minute, KeyTTL: time.Second}, gothrottle.ErrInvalidOptions},
+		{"unknown strategy", gothrottle.Options{Strategy: gothrottle.Strategy(7)}, gothrottle.ErrInvalidOptions},
+		{"unknown algorithm", gothrottle.Options{Algorithm: gothrottle.Algorithm(7)}, gothrottle.ErrInvalidOptions},
+		{"inverted adaptive limits", gothrottle.Options{Adaptive: &gothrottle.AdaptiveConcurrency{MinLimit: 8, MaxLimit: 2}}, gothrottle.ErrInvalidOptions},
+		{"negative circuit breaker threshold", gothrottle.Options{CircuitBreaker: &gothrottle.CircuitBreaker{FailureThreshold: -1}}, gothrottle.ErrInvalidOptions},
+		{"adaptive backoff above one", gothrottle.Options{Adaptive: &gothrottle.AdaptiveConcurrency{Backoff: 1.5}}, gothrottle.ErrInvalidOptions},
+		{"duplicate tier", gothrottle.Options{Tiers: []gothrottle.Tier{{Name: "a", Share: 1}, {Name: "a", Share: 1}}}, gothrottle.ErrInvalidTier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Expected options to be valid, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if _, err := gothrottle.NewLimiter(tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected NewLimiter to fail with %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+	if err := limiter.UpdateOptions(gothrottle.Options{MaxConcurrent: -1}); !errors.Is(err, gothrottle.ErrInvalidOptions) {
+		t.Errorf("Expected UpdateOptions to fail with ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestLimiter_UpdateOptions(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	runBatch := func() int {
+		mu.Lock()
+		maxConcurrent = 0
+		mu.Unlock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = limiter.Schedule(func() (interface{}, error) {
+					mu.Lock()
+					concurrent++
+					if concurrent > maxConcurrent {
+						maxConcurrent = concurrent
+					}
+					mu.Unlock()
+
+					time.Sleep(50 * time.Millisecond)
+
+					mu.Lock()
+					concurrent--
+					mu.Unlock()
+					return nil, nil
+				})
+			}()
+		}
+		wg.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		return maxConcurrent
+	}
+
+	if got := runBatch(); got != 4 {
+		t.Errorf("Expected max concurrent 4 before update, got %d", got)
+	}
+
+	if err := limiter.UpdateOptions(gothrottle.Options{MaxConcurrent: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := runBatch(); got != 2 {
+		t.Errorf("Expected max concurrent 2 after update, got %d", got)
+	}
+
+	if err := limiter.UpdateOptions(gothrottle.Options{ID: "other"}); err != gothrottle.ErrImmutableOption {
+		t.Errorf("Expected ErrImmutableOption when changing ID, got %v", err)
+	}
+	if err := limiter.UpdateOptions(gothrottle.Options{Datastore: gothrottle.NewLocalStore()}); err != gothrottle.ErrImmutableOption {
+		t.Errorf("Expected ErrImmutableOption when changing Datastore, got %v", err)
+	}
+}
+
+func TestLimiter_UpdateOptionsMidRun(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var concurrent, maxAfterUpdate int
+	var updated bool
+	started := make(chan struct{}, 20)
+
+	handles := make([]*gothrottle.JobHandle, 0, 20)
+	for i := 0; i < 20; i++ {
+		handles = append(handles, limiter.Submit(func() (interface{}, error) {
+			mu.Lock()
+			concurrent++
+			if updated && concurrent > maxAfterUpdate {
+				maxAfterUpdate = concurrent
+			}
+			mu.Unlock()
+			started <- struct{}{}
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			concurrent--
+			mu.Unlock()
+			return nil, nil
+		}))
+	}
+
+	// Wait until the first wave is running at the original limit
+	for i := 0; i < 5; i++ {
+		<-started
+	}
+
+	opts := limiter.Options()
+	opts.MaxConcurrent = 1
+	mu.Lock()
+	updated = true
+	mu.Unlock()
+	if err := limiter.UpdateOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range handles {
+		if _, err := h.Wait(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if maxAfterUpdate != 1 {
+		t.Errorf("Expected max concurrent 1 for jobs started after the update, got %d", maxAfterUpdate)
+	}
+	if got := limiter.Options().MaxConcurrent; got != 1 {
+		t.Errorf("Expected Options().MaxConcurrent 1, got %d", got)
+	}
+}
+
+func TestLimiter_WeightExceedsLimit(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	_, err = limiter.ScheduleWithOptions(func() (interface{}, error) {
+		return nil, nil
+	}, 5, 5)
+	if err != gothrottle.ErrWeightExceedsLimit {
+		t.Errorf("Expected ErrWeightExceedsLimit, got %v", err)
+	}
+
+	store := gothrottle.NewLocalStore()
+	_, _, err = store.Request("test", 5, gothrottle.Options{MaxConcurrent: 3})
+	if err != gothrottle.ErrWeightExceedsLimit {
+		t.Errorf("Expected ErrWeightExceedsLimit from LocalStore, got %v", err)
+	}
+}
+
+func TestLimiter_BatchSchedule(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	errOdd := fmt.Errorf("odd task")
+	tasks := make([]func() (interface{}, error), 10)
+	for i := range tasks {
+		i := i
+		tasks[i] = func() (interface{}, error) {
+			if i%2 == 1 {
+				return nil, errOdd
+			}
+			return i, nil
+		}
+	}
+
+	results, errs := limiter.BatchSchedule(tasks, 5, 1)
+	if len(results) != len(tasks) || len(errs) != len(tasks) {
+		t.Fatalf("Expected %d results and errors, got %d and %d", len(tasks), len(results), len(errs))
+	}
+	for i := range tasks {
+		if i%2 == 1 {
+			if errs[i] != errOdd {
+				t.Errorf("Task %d: expected error %v, got %v", i, errOdd, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("Task %d failed: %v", i, errs[i])
+		}
+		if results[i] != i {
+			t.Errorf("Task %d: expected result %d, got %v", i, i, results[i])
+		}
+	}
+
+	_, errs = limiter.BatchSchedule(tasks[:2], 5, 0)
+	for i, err := range errs {
+		if err != gothrottle.ErrInvalidWeight {
+			t.Errorf("Task %d: expected ErrInvalidWeight, got %v", i, err)
+		}
+	}
+}
+
+func TestLimiter_DispatchesEligibleJobsTogether(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var starts []time.Time
+	tasks := make([]func() (interface{}, error), 10)
+	for i := range tasks {
+		tasks[i] = func() (interface{}, error) {
+			mu.Lock()
+			starts = append(starts, time.Now())
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			return nil, nil
+		}
+	}
+
+	begin := time.Now()
+	_, errs := limiter.BatchSchedule(tasks, 5, 1)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Task %d failed: %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, start := range starts {
+		if delay := start.Sub(begin); delay > 20*time.Millisecond {
+			t.Errorf("Job %d started %v after submission, expected all jobs to start within a few milliseconds", i, delay)
+		}
+	}
+}
+
+func TestLimiter_FIFOWithinPriority(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Occupy the only slot so all other jobs queue up
+	release := make(chan struct{})
+	blocker := limiter.Submit(func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	var mu sync.Mutex
+	var order []int
+	handles := make([]*gothrottle.JobHandle, 50)
+	for i := range handles {
+		i := i
+		handles[i] = limiter.Submit(func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			return nil, nil
+		})
+	}
+
+	close(release)
+	_, _ = blocker.Wait()
+	for _, h := range handles {
+		_, _ = h.Wait()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, id := range order {
+		if id != i {
+			t.Fatalf("Expected submission order, got %v", order)
+		}
+	}
+}
+
+func TestLimiter_TryAcquire(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	acquired, release, err := limiter.TryAcquire(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Fatal("First acquire should succeed")
+	}
+
+	acquired, _, err = limiter.TryAcquire(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acquired {
+		t.Error("Acquire should fail while all slots are held")
+	}
+
+	// Releasing twice is safe
+	release()
+	release()
+
+	acquired, release, err = limiter.TryAcquire(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Fatal("Acquire after release should succeed")
+	}
+	release()
+
+	if _, _, err := limiter.TryAcquire(0); err != gothrottle.ErrInvalidWeight {
+		t.Errorf("Expected ErrInvalidWeight, got %v", err)
+	}
+}
+
+func TestLimiter_SetCooldown(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 2, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	limiter.SetCooldown(2 * time.Second)
+	if canRun, wait, err := limiter.CheckAvailable(); err != nil || canRun || wait != 2*time.Second {
+		t.Errorf("Expected no slot for 2s, got %v, %v, %v", canRun, wait, err)
+	}
+	if acquired, _, err := limiter.TryAcquire(1); err != nil || acquired {
+		t.Errorf("Expected TryAcquire to fail during the cooldown, got %v, %v", acquired, err)
+	}
+
+	handle := limiter.Submit(func() (interface{}, error) { return clock.Now(), nil })
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	select {
+	case <-handle.Done():
+		t.Fatal("Expected the job to wait for the cooldown")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	started, err := handle.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(2, 0); !started.(time.Time).Equal(want) {
+		t.Errorf("Expected the job to start when the cooldown ended at %v, got %v", want, started)
+	}
+
+	// A zero cooldown lifts it right away
+	limiter.SetCooldown(time.Hour)
+	limiter.SetCooldown(0)
+	if result, err := limiter.Schedule(func() (interface{}, error) { return "ok", nil }); err != nil || result != "ok" {
+		t.Errorf("Expected the job to run after lifting the cooldown, got %v, %v", result, err)
+	}
+}
+
+func TestLimiter_CheckAvailable(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:            "check",
+		Datastore:     store,
+		MaxConcurrent: 1,
+		MinTime:       time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	for i := 0; i < 2; i++ {
+		canRun, _, err := limiter.CheckAvailable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !canRun {
+			t.Fatal("Expected an idle limiter to be available")
+		}
+	}
+
+	acquired, release, err := limiter.TryAcquire(1)
+	if err != nil || !acquired {
+		t.Fatalf("Acquire after checking should succeed, got acquired=%v err=%v", acquired, err)
+	}
+	if canRun, _, err := limiter.CheckAvailable(); err != nil || canRun {
+		t.Errorf("Expected no slot while it is held, got canRun=%v err=%v", canRun, err)
+	}
+	release()
+
+	// The slot is free again but MinTime has not passed
+	canRun, waitTime, err := limiter.CheckAvailable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun || waitTime <= 0 || waitTime > time.Second {
+		t.Errorf("Expected to wait up to MinTime, got canRun=%v waitTime=%v", canRun, waitTime)
+	}
+
+	grantingLimiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "granting", Datastore: &grantingStore{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = grantingLimiter.Stop() }() // Ignore error in test cleanup
+	if _, _, err := grantingLimiter.CheckAvailable(); err != gothrottle.ErrPeekUnsupported {
+		t.Errorf("Expected ErrPeekUnsupported, got %v", err)
+	}
+}
+
+func TestLimiter_NextAvailable(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 3,
+		MinTime:       2 * time.Second,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	if wait, err := limiter.NextAvailable(1); err != nil || wait != 0 {
+		t.Errorf("Expected an idle limiter to be available now, got %v, %v", wait, err)
+	}
+	if _, err := limiter.NextAvailable(0); err != gothrottle.ErrInvalidWeight {
+		t.Errorf("Expected ErrInvalidWeight, got %v", err)
+	}
+	if _, err := limiter.NextAvailable(4); err != gothrottle.ErrWeightExceedsLimit {
+		t.Errorf("Expected ErrWeightExceedsLimit, got %v", err)
+	}
+
+	acquired, release, err := limiter.TryAcquire(2)
+	if err != nil || !acquired {
+		t.Fatalf("Expected to acquire weight 2, got acquired=%v err=%v", acquired, err)
+	}
+	if _, err := limiter.NextAvailable(2); err != gothrottle.ErrAtCapacity {
+		t.Errorf("Expected ErrAtCapacity while the slots are held, got %v", err)
+	}
+	release()
+
+	// The slots are free again, so only MinTime is left
+	clock.Advance(500 * time.Millisecond)
+	if wait, err := limiter.NextAvailable(2); err != nil || wait != 1500*time.Millisecond {
+		t.Errorf("Expected to wait the rest of MinTime, got %v, %v", wait, err)
+	}
+}
+
+func TestLimiter_Stats(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:            "stats",
+		Datastore:     gothrottle.NewLocalStore(),
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocker := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	queued := limiter.Submit(func() (interface{}, error) {
+		return nil, fmt.Errorf("task failed")
+	})
+
+	stats := limiter.Stats()
+	if stats.ID != "stats" || stats.Running != 1 || stats.Queued != 1 {
+		t.Errorf("Expected 1 running and 1 queued job for %q, got %+v", "stats", stats)
+	}
+
+	close(release)
+	_, _ = blocker.Wait()
+	_, _ = queued.Wait()
+	_, _ = limiter.ScheduleWithOptions(func() (interface{}, error) { return nil, nil }, 5, 2)
+
+	stats = limiter.Stats()
+	if stats.Running != 0 || stats.Queued != 0 || stats.Completed != 1 || stats.Failed != 1 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats after completion: %+v", stats)
+	}
+}
+
+func TestLimiter_SlidingWindow(t *testing.T) {
+	window := 200 * time.Millisecond
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		WindowLimit:    3,
+		WindowDuration: window,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	var starts []time.Time
+	task := func() (interface{}, error) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		return nil, nil
+	}
+
+	tasks := make([]func() (interface{}, error), 6)
+	for i := range tasks {
+		tasks[i] = task
+	}
+	_, errs := limiter.BatchSchedule(tasks, 5, 1)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The first three jobs burst; the next three wait for the window to slide
+	if burst := starts[2].Sub(starts[0]); burst >= window/2 {
+		t.Errorf("Expected the first 3 jobs to start together, took %v", burst)
+	}
+	for i := 3; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-3]); gap < window-5*time.Millisecond {
+			t.Errorf("Job %d started %v after job %d, expected at least %v", i, gap, i-3, window)
+		}
+	}
+}
+
+func TestLimiter_WaitUntilIdle(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	var mu sync.Mutex
+	completed := 0
+	for i := 0; i < 10; i++ {
+		limiter.Submit(func() (interface{}, error) {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			completed++
+			mu.Unlock()
+			return nil, nil
+		})
+	}
+
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if completed != 10 {
+		t.Errorf("Expected 10 completed jobs when idle, got %d", completed)
+	}
+	mu.Unlock()
+	if stats := limiter.Stats(); stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("Expected no queued or running jobs when idle, got %+v", stats)
+	}
+
+	// A job that outlives the context keeps the limiter busy
+	release := make(chan struct{})
+	defer close(release)
+	limiter.Submit(func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.WaitUntilIdle(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLimiter_IdleNotify(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs := []<-chan struct{}{limiter.IdleNotify(), limiter.IdleNotify()}
+	expectIdle := func() {
+		t.Helper()
+		for i, sub := range subs {
+			select {
+			case _, ok := <-sub:
+				if !ok {
+					t.Fatalf("Subscriber %d: channel closed early", i)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Subscriber %d: expected an idle notification", i)
+			}
+		}
+	}
+	expectQuiet := func() {
+		t.Helper()
+		for i, sub := range subs {
+			select {
+			case <-sub:
+				t.Errorf("Subscriber %d: unexpected idle notification", i)
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}
+
+	for batch := 0; batch < 2; batch++ {
+		var handles []*gothrottle.JobHandle
+		for i := 0; i < 5; i++ {
+			handles = append(handles, limiter.Submit(func() (interface{}, error) {
+				time.Sleep(5 * time.Millisecond)
+				return nil, nil
+			}))
+		}
+		expectIdle()
+		for _, handle := range handles {
+			if _, err := handle.Wait(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Each drain is reported once
+		expectQuiet()
+	}
+
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for i, sub := range append(subs, limiter.IdleNotify()) {
+		if _, ok := <-sub; ok {
+			t.Errorf("Subscriber %d: expected the channel to be closed after Stop", i)
+		}
+	}
+}
+
+func TestLimiter_HighWaterStrategies(t *testing.T) {
+	// newFullLimiter returns a limiter with one running job blocked on release
+	// and a queue filled up to HighWater with jobs of priority 1 and 9.
+	newFullLimiter := func(t *testing.T, strategy gothrottle.Strategy) (*gothrottle.Limiter, chan struct{}, []*gothrottle.JobHandle) {
+		limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+			MaxConcurrent: 1,
+			HighWater:     2,
+			Strategy:      strategy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = limiter.Stop() }) // Ignore error in test cleanup
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		blocker := limiter.Submit(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		<-started
+
+		noop := func() (interface{}, error) { return nil, nil }
+		handles := []*gothrottle.JobHandle{
+			blocker,
+			limiter.SubmitWithOptions(noop, 1, 1),
+			limiter.SubmitWithOptions(noop, 9, 1),
+		}
+		return limiter, release, handles
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		limiter, release, _ := newFullLimiter(t, gothrottle.StrategyReject)
+		defer close(release)
+
+		if _, err := limiter.Submit(func() (interface{}, error) { return nil, nil }).Wait(); err != gothrottle.ErrQueueFull {
+			t.Errorf("Expected ErrQueueFull, got %v", err)
+		}
+		if stats := limiter.Stats(); stats.Queued != 2 {
+			t.Errorf("Expected queued jobs to be kept, got %d queued", stats.Queued)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		limiter, release, handles := newFullLimiter(t, gothrottle.StrategyDropOldest)
+
+		newest := limiter.SubmitWithOptions(func() (interface{}, error) { return nil, nil }, 5, 1)
+		if _, err := handles[1].Wait(); err != gothrottle.ErrDropped {
+			t.Errorf("Expected the lowest priority job to get ErrDropped, got %v", err)
+		}
+
+		close(release)
+		for _, h := range []*gothrottle.JobHandle{handles[0], handles[2], newest} {
+			if _, err := h.Wait(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		limiter, release, handles := newFullLimiter(t, gothrottle.StrategyBlock)
+
+		submitted := make(chan *gothrottle.JobHandle)
+		go func() {
+			submitted <- limiter.Submit(func() (interface{}, error) { return nil, nil })
+		}()
+
+		select {
+		case <-submitted:
+			t.Fatal("Submit should block while the queue is at HighWater")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		newest := <-submitted
+		for _, h := range append(handles, newest) {
+			if _, err := h.Wait(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+	})
+}
+
+func TestLimiter_MaxQueueTime(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+		MaxQueueTime:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocker := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	ran := false
+	queued := limiter.Submit(func() (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+
+	// The job expires while the slot is still held, not once it frees up
+	start := time.Now()
+	_, err = queued.Wait()
+	if err != gothrottle.ErrQueueTimeout {
+		t.Errorf("Expected ErrQueueTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the job to expire after about 50ms, took %v", elapsed)
+	}
+
+	close(release)
+	if _, err := blocker.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("An expired job should never run")
+	}
+
+	// Jobs that get a slot in time are unaffected
+	if _, err := limiter.Schedule(func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestLimiter_MaxQueueTimeMinTime(t *testing.T) {
+	// A limiter saturated by MinTime spacing rather than MaxConcurrent
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MinTime:      time.Second,
+		MaxQueueTime: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	noop := func() (interface{}, error) { return nil, nil }
+	if _, err := limiter.Schedule(noop); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next start is a second away, so every queued job outlives MaxQueueTime
+	handles := make([]*gothrottle.JobHandle, 3)
+	for i := range handles {
+		handles[i] = limiter.Submit(noop)
+	}
+
+	start := time.Now()
+	for i, handle := range handles {
+		if _, err := handle.Wait(); err != gothrottle.ErrQueueTimeout {
+			t.Errorf("Job %d: expected ErrQueueTimeout, got %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the jobs to expire without waiting for MinTime, took %v", elapsed)
+	}
+	if stats := limiter.Stats(); stats.Queued != 0 {
+		t.Errorf("Expected an empty queue, got %d queued", stats.Queued)
+	}
+}
+
+func TestLimiter_ScheduleAt(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	when := clock.Now().Add(200 * time.Millisecond)
+	type outcome struct {
+		started interface{}
+		err     error
+	}
+	delayed := make(chan outcome, 1)
+	go func() {
+		started, err := limiter.ScheduleAt(when, func() (interface{}, error) { return clock.Now(), nil })
+		delayed <- outcome{started, err}
+	}()
+	waitForQueued(t, limiter, 1)
+
+	// The delayed job does not hold up jobs that are due
+	if _, err := limiter.Schedule(func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(199 * time.Millisecond)
+	select {
+	case <-delayed:
+		t.Fatal("Expected the job not to start before its time")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	result := <-delayed
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if started := result.started.(time.Time); started.Before(when) {
+		t.Errorf("Expected the job to start at %v or later, started at %v", when, started)
+	}
+}
+
+func TestLimiter_ScheduleAtOrder(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Unix(0, 0))
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MaxConcurrent: 1, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Submitted out of time order, plus one that is not due before Stop
+	offsets := []time.Duration{300 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, time.Hour}
+	results := make(chan time.Duration, len(offsets))
+	errs := make(chan error, len(offsets))
+	start := clock.Now()
+	for _, offset := range offsets {
+		go func(offset time.Duration) {
+			_, err := limiter.ScheduleAt(start.Add(offset), func() (interface{}, error) {
+				results <- offset
+				return nil, nil
+			})
+			errs <- err
+		}(offset)
+	}
+	waitForQueued(t, limiter, len(offsets))
+
+	for _, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond} {
+		clock.BlockUntil(1)
+		clock.Advance(start.Add(want).Sub(clock.Now()))
+		if got := <-results; got != want {
+			t.Errorf("Expected the job due at %v to run, got the one due at %v", want, got)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if queued := limiter.Stats().Queued; queued != 1 {
+		t.Errorf("Expected the job not due yet to count as queued, got %d", queued)
+	}
+
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed for the job not due yet, got %v", err)
+	}
+}
+
+func TestLimiter_PriorityBounds(t *testing.T) {
+	noop := func() (interface{}, error) { return nil, nil }
+
+	tests := []struct {
+		name     string
+		min, max int
+		priority int
+		wantErr  error
+	}{
+		{"no bounds by default", 0, 0, -100, nil},
+		{"at lower bound", 1, 10, 1, nil},
+		{"at upper bound", 1, 10, 10, nil},
+		{"below lower bound", 1, 10, 0, gothrottle.ErrInvalidPriority},
+		{"above upper bound", 1, 10, 11, gothrottle.ErrInvalidPriority},
+		{"negative with only an upper bound", 0, 10, -1, gothrottle.ErrInvalidPriority},
+		{"large with only a lower bound", 1, 0, 1000, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+				MinPriority: tt.min,
+				MaxPriority: tt.max,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			if _, err := limiter.ScheduleWithOptions(noop, tt.priority, 1); err != tt.wantErr {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLimiter_StopContext(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	stuck := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return "finished", nil
+	})
+	<-started
+	queued := limiter.Submit(func() (interface{}, error) { return nil, nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.StopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The limiter stays stopped even though a task is still running
+	if _, err := queued.Wait(); err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected queued job to fail with ErrStoreClosed, got %v", err)
+	}
+	if _, err := limiter.Schedule(func() (interface{}, error) { return nil, nil }); err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed after StopContext, got %v", err)
+	}
+
+	// The running task is not interrupted
+	close(release)
+	if result, err := stuck.Wait(); err != nil || result != "finished" {
+		t.Errorf("Expected running task to finish, got %v, %v", result, err)
+	}
+}
+
+func TestLimiter_StopContextDisconnectsLater(t *testing.T) {
+	store := gothrottle.NewLocalStore()
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "stop", Datastore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	stuck := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.StopContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Stopping again returns at once, and the store stays open while the task runs
+	if err := limiter.Stop(); err != nil {
+		t.Errorf("Expected a second Stop to return nil, got %v", err)
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		t.Errorf("Expected the store to stay open while a task runs, got %v", err)
+	}
+
+	close(release)
+	if _, err := stuck.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for store.Ping(context.Background()) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the store to be disconnected once the task finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLimiter_StopConcurrent(t *testing.T) {
+	// A store whose second Disconnect would be reported as an error
+	store := &disconnectCounter{Datastore: gothrottle.NewLocalStore()}
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "stop", Datastore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := limiter.Submit(func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	})
+	waitForRunning(t, limiter, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Stop(); err != nil {
+				t.Errorf("Expected every Stop to succeed, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := handle.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := limiter.Stop(); err != nil {
+		t.Errorf("Expected a repeated Stop to return nil, got %v", err)
+	}
+	if n := store.disconnects.Load(); n != 1 {
+		t.Errorf("Expected the datastore to be disconnected once, got %d", n)
+	}
+}
+
+// disconnectCounter counts Disconnect calls and fails all but the first.
+type disconnectCounter struct {
+	gothrottle.Datastore
+	disconnects atomic.Int32
+}
+
+func (d *disconnectCounter) Disconnect() error {
+	if d.disconnects.Add(1) > 1 {
+		return errors.New("already disconnected")
+	}
+	return d.Datastore.Disconnect()
+}
+
+func TestLimiter_StopWaitsForRunningJobs(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	handle := limiter.Submit(func() (interface{}, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	})
+	<-started
+
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handle.Done():
+	default:
+		t.Error("Stop returned before the running job finished")
+	}
+}
+
+func TestLimiter_ScheduleKeyed(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:        "tenants",
+		MinTime:   200 * time.Millisecond,
+		Datastore: store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	task := func() (interface{}, error) { return nil, nil }
+	if _, err := limiter.ScheduleKeyed("alice", task, 5, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice's key has to wait for MinTime, but Bob's key has its own bucket and must not wait behind it
+	start := time.Now()
+	var wg sync.WaitGroup
+	elapsed := make(map[string]time.Duration)
+	var mu sync.Mutex
+	for _, key := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if _, err := limiter.ScheduleKeyed(key, task, 5, 1); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			elapsed[key] = time.Since(start)
+			mu.Unlock()
+		}(key)
+		time.Sleep(10 * time.Millisecond) // Queue Alice's job first
+	}
+	wg.Wait()
+
+	if elapsed["bob"] > 100*time.Millisecond {
+		t.Errorf("Expected Bob's job to run without waiting for Alice, took %v", elapsed["bob"])
+	}
+	if elapsed["alice"] < 150*time.Millisecond {
+		t.Errorf("Expected Alice's second job to wait for MinTime, took %v", elapsed["alice"])
+	}
+
+	for _, key := range []string{"gothrottle:{tenants:alice}", "gothrottle:{tenants:bob}"} {
+		if !mr.Exists(key) {
+			t.Errorf("Expected datastore key %q", key)
+		}
+	}
+}
+
+func TestLimiter_AdaptiveConcurrency(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 4,
+		Adaptive:      &gothrottle.AdaptiveConcurrency{MinLimit: 1, MaxLimit: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	if limit := limiter.Stats().Limit; limit != 4 {
+		t.Fatalf("Expected the limit to start at MaxConcurrent 4, got %d", limit)
+	}
+
+	// Failures halve the limit down to MinLimit
+	errDownstream := fmt.Errorf("downstream overloaded")
+	for _, want := range []int{2, 1, 1} {
+		_, _ = limiter.Schedule(func() (interface{}, error) { return nil, errDownstream })
+		if limit := limiter.Stats().Limit; limit != want {
+			t.Fatalf("Expected limit %d after a failure, got %d", want, limit)
+		}
+	}
+
+	// The reduced limit is enforced, growing by one per limit's worth of successes
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int
+	tasks := make([]func() (interface{}, error), 4)
+	for i := range tasks {
+		tasks[i] = func() (interface{}, error) {
+			mu.Lock()
+			concurrent++
+			if concurrent > maxConcurrent {
+				maxConcurrent = concurrent
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			concurrent--
+			mu.Unlock()
+			return nil, nil
+		}
+	}
+	limiter.BatchSchedule(tasks, 5, 1)
+	if maxConcurrent > 2 {
+		t.Errorf("Expected at most 2 concurrent jobs while the limit recovers, got %d", maxConcurrent)
+	}
+
+	// Successes raise the limit up to MaxLimit
+	for i := 0; i < 100; i++ {
+		_, _ = limiter.Schedule(func() (interface{}, error) { return nil, nil })
+	}
+	if limit := limiter.Stats().Limit; limit != 8 {
+		t.Errorf("Expected the limit to grow to MaxLimit 8, got %d", limit)
+	}
+}
@@ -0,0 +1,96 @@
+// FILENAME: logger_test.go
+package gothrottle_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+// recordingLogger keeps every message logged at any level.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *recordingLogger) record(level, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.record("DEBUG", format, args...)
+}
+func (r *recordingLogger) Infof(format string, args ...interface{}) {
+	r.record("INFO", format, args...)
+}
+func (r *recordingLogger) Warnf(format string, args ...interface{}) {
+	r.record("WARN", format, args...)
+}
+func (r *recordingLogger) Errorf(format string, args ...interface{}) {
+	r.record("ERROR", format, args...)
+}
+
+// contains reports whether any message starts with level and contains substr.
+func (r *recordingLogger) contains(level, substr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range r.messages {
+		if strings.HasPrefix(msg, level+" ") && strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLimiter_Logging(t *testing.T) {
+	logger := &recordingLogger{}
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:            "logged",
+		Datastore:     gothrottle.NewLocalStore(),
+		MaxConcurrent: 1,
+		MinTime:       20 * time.Millisecond,
+		Logger:        logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []func() (interface{}, error){
+		func() (interface{}, error) { return nil, nil },
+		func() (interface{}, error) { return nil, nil },
+	}
+	limiter.BatchSchedule(tasks, 5, 1)
+	if err := limiter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct{ level, substr string }{
+		{"DEBUG", `limiter "logged" queued job`},
+		{"DEBUG", `limiter "logged" started job`},
+		{"DEBUG", `limiter "logged" denied job`},
+		{"INFO", `limiter "logged" stopping`},
+		{"INFO", `limiter "logged" stopped`},
+	} {
+		if !logger.contains(want.level, want.substr) {
+			t.Errorf("Expected %s message containing %q, got %v", want.level, want.substr, logger.messages)
+		}
+	}
+}
+
+func TestNewStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := gothrottle.NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Warnf("slot leaked for %q", "api")
+	if got := buf.String(); got != "WARN slot leaked for \"api\"\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
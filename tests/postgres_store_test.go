@@ -0,0 +1,212 @@
+// FILENAME: postgres_store_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestPostgresStore returns a store backed by a mock database.
+func newTestPostgresStore(t *testing.T) (*gothrottle.PostgresStore, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	store, err := gothrottle.NewPostgresStore(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, mock
+}
+
+// expectLockedRow expects the row for id to be created if missing and locked.
+func expectLockedRow(mock sqlmock.Sqlmock, id string, running int, lastStartUs int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO throttle_state (id) VALUES ($1) ON CONFLICT (id) DO NOTHING")).
+		WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT running, last_start FROM throttle_state WHERE id = $1 FOR UPDATE")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"running", "last_start"}).AddRow(running, lastStartUs))
+}
+
+func TestPostgresStore_Request(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 2, MinTime: time.Minute}
+
+	// Granted: the row is updated and the transaction committed
+	expectLockedRow(mock, "api", 0, 0)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE throttle_state SET running = running + $2, last_start = $3 WHERE id = $1")).
+		WithArgs("api", 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	canRun, _, err := store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("First request should be allowed")
+	}
+
+	// Denied by MaxConcurrent: the transaction is rolled back
+	expectLockedRow(mock, "api", 2, 0)
+	mock.ExpectRollback()
+
+	canRun, _, err = store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request over MaxConcurrent should be denied")
+	}
+
+	// Denied by MinTime with the remaining wait
+	expectLockedRow(mock, "api", 1, time.Now().UnixMicro())
+	mock.ExpectRollback()
+
+	canRun, waitTime, err := store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request within MinTime should be denied")
+	}
+	if waitTime <= 0 || waitTime > time.Minute {
+		t.Errorf("Expected wait time in (0, 1m], got %v", waitTime)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresStore_MinTimeMicroseconds(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+	opts := gothrottle.Options{MinTime: 10 * time.Millisecond}
+
+	// 9.5ms after the last start, which whole milliseconds could round up to 10ms
+	expectLockedRow(mock, "api", 0, time.Now().Add(-9500*time.Microsecond).UnixMicro())
+	mock.ExpectRollback()
+
+	canRun, waitTime, err := store.Request("api", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request within MinTime should be denied")
+	}
+	if waitTime <= 0 || waitTime > time.Millisecond {
+		t.Errorf("Expected wait time in (0, 1ms], got %v", waitTime)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresStore_UnsupportedOptions(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+
+	tests := []struct {
+		name string
+		opts gothrottle.Options
+	}{
+		{"window", gothrottle.Options{WindowLimit: 5, WindowDuration: time.Second}},
+		{"gcra", gothrottle.Options{Algorithm: gothrottle.AlgorithmGCRA, MinTime: time.Second, Burst: 3}},
+		{"stale timeout", gothrottle.Options{StaleTimeout: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.Request("api", 1, tt.opts); !errors.Is(err, gothrottle.ErrInvalidOptions) {
+				t.Errorf("Expected an error wrapping ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+
+	// Nothing reached the database
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresStore_RegisterDone(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE throttle_state SET running = GREATEST(running - $2, 0) WHERE id = $1")).
+		WithArgs("api", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.RegisterDone("api", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if err := store.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Request("api", 1, gothrottle.Options{}); err != gothrottle.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed after Disconnect, got %v", err)
+	}
+}
+
+func TestPostgresStore_CurrentUsage(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT running FROM throttle_state WHERE id = $1")).
+		WithArgs("api").
+		WillReturnRows(sqlmock.NewRows([]string{"running"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT running FROM throttle_state WHERE id = $1")).
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"running"}))
+
+	if running, err := store.CurrentUsage("api"); err != nil || running != 3 {
+		t.Errorf("Expected 3 running, got %d, %v", running, err)
+	}
+	if running, err := store.CurrentUsage("unknown"); err != nil || running != 0 {
+		t.Errorf("Expected 0 running for a limiter without a row, got %d, %v", running, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewPostgresStore_InvalidTableName(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := gothrottle.NewPostgresStore(db, "state; DROP TABLE users"); err == nil {
+		t.Error("Expected an error for an invalid table name")
+	}
+	if _, err := gothrottle.NewPostgresStore(db, "throttle.state"); err != nil {
+		t.Errorf("Expected a schema-qualified table name to be accepted, got %v", err)
+	}
+}
+
+func TestPostgresStore_CreateTable(t *testing.T) {
+	store, mock := newTestPostgresStore(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS throttle_state (")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
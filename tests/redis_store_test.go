@@ -0,0 +1,739 @@
+// FILENAME: redis_store_test.go
+package gothrottle_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestRedisStore starts an in-memory Redis server and returns a store connected to it.
+func newTestRedisStore(t *testing.T) (*gothrottle.RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	store, err := gothrottle.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Disconnect() })
+
+	return store, mr
+}
+
+func TestRedisStore_Basic(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 1}
+
+	canRun, _, err := store.Request("test", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("First request should be allowed")
+	}
+
+	canRun, _, err = store.Request("test", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Second request should be denied")
+	}
+
+	if err := store.RegisterDone("test", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	canRun, _, err = store.Request("test", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("Request after RegisterDone should be allowed")
+	}
+}
+
+func TestRedisStore_KeyTTL(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+
+	if _, _, err := store.Request("default-ttl", 1, gothrottle.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("gothrottle:{default-ttl}"); ttl != gothrottle.DefaultKeyTTL {
+		t.Errorf("Expected default TTL %v, got %v", gothrottle.DefaultKeyTTL, ttl)
+	}
+
+	opts := gothrottle.Options{MinTime: time.Minute, KeyTTL: 2 * time.Minute}
+	if _, _, err := store.Request("custom-ttl", 1, opts); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("gothrottle:{custom-ttl}"); ttl != 2*time.Minute {
+		t.Errorf("Expected TTL %v, got %v", 2*time.Minute, ttl)
+	}
+}
+
+func TestRedisStore_DefaultKeyTTLLongMinTime(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+
+	// The default TTL is shorter than MinTime, so it is raised rather than letting
+	// the state expire between jobs
+	opts := gothrottle.Options{MinTime: 45 * time.Second}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if canRun, _, err := store.Request("long-min-time", 1, opts); err != nil || !canRun {
+		t.Fatalf("Expected the first job to be granted, got %v, %v", canRun, err)
+	}
+	if ttl := mr.TTL("gothrottle:{long-min-time}"); ttl != 90*time.Second {
+		t.Errorf("Expected TTL %v, got %v", 90*time.Second, ttl)
+	}
+
+	// Past the default TTL the state is still there and MinTime still applies
+	mr.FastForward(40 * time.Second)
+	if !mr.Exists("gothrottle:{long-min-time}") {
+		t.Fatal("Expected the key to outlive the default TTL")
+	}
+	if canRun, _, err := store.Request("long-min-time", 1, opts); err != nil || canRun {
+		t.Errorf("Expected MinTime to deny the second job, got %v, %v", canRun, err)
+	}
+}
+
+func TestRedisStore_ReloadsFlushedScript(t *testing.T) {
+	constructors := []struct {
+		name     string
+		newStore func(addr string) (*gothrottle.RedisStore, error)
+	}{
+		{"client", func(addr string) (*gothrottle.RedisStore, error) {
+			return gothrottle.NewRedisStore(redis.NewClient(&redis.Options{Addr: addr}))
+		}},
+		{"cluster", func(addr string) (*gothrottle.RedisStore, error) {
+			return gothrottle.NewRedisClusterStore(redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{addr}}))
+		}},
+		{"universal", func(addr string) (*gothrottle.RedisStore, error) {
+			return gothrottle.NewRedisUniversalStore(redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{addr}}))
+		}},
+	}
+
+	for _, tt := range constructors {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			store, err := tt.newStore(mr.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = store.Disconnect() })
+
+			opts := gothrottle.Options{MaxConcurrent: 2}
+			if _, _, err := store.Request("test", 1, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			// Simulate a Redis restart or failover that drops the script cache
+			admin := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			defer admin.Close()
+			if err := admin.ScriptFlush(context.Background()).Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			canRun, _, err := store.Request("test", 1, opts)
+			if err != nil {
+				t.Fatalf("Expected the script to be reloaded, got %v", err)
+			}
+			if !canRun {
+				t.Error("Request after script flush should be allowed")
+			}
+		})
+	}
+}
+
+func TestRedisStore_SlidingWindow(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	opts := gothrottle.Options{WindowLimit: 3, WindowDuration: time.Second}
+
+	for i := 0; i < 3; i++ {
+		canRun, _, err := store.Request("window", 1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !canRun {
+			t.Fatalf("Request %d should be allowed within the window limit", i+1)
+		}
+	}
+
+	canRun, waitTime, err := store.Request("window", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Request beyond the window limit should be denied")
+	}
+	if waitTime <= 0 || waitTime > time.Second {
+		t.Errorf("Expected wait time in (0, 1s], got %v", waitTime)
+	}
+}
+
+func TestRedisClusterStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := gothrottle.NewRedisClusterStore(redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Disconnect() })
+
+	opts := gothrottle.Options{MaxConcurrent: 1, WindowLimit: 5, WindowDuration: time.Minute}
+	canRun, _, err := store.Request("cluster", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Error("First request should be allowed")
+	}
+
+	canRun, _, err = store.Request("cluster", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Second request should be denied")
+	}
+
+	if err := store.RegisterDone("cluster", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both keys share the limiter ID as hash tag, so they map to the same cluster slot
+	for _, key := range []string{"gothrottle:{cluster}", "gothrottle:{cluster}:window"} {
+		if !mr.Exists(key) {
+			t.Errorf("Expected key %q to exist", key)
+		}
+	}
+}
+
+func TestRedisStore_ScriptFlushMidRun(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		ID:            "flush-mid-run",
+		MaxConcurrent: 2,
+		Datastore:     store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	task := func() (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	}
+
+	admin := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer admin.Close()
+
+	for round := 0; round < 3; round++ {
+		handles := make([]*gothrottle.JobHandle, 5)
+		for i := range handles {
+			handles[i] = limiter.Submit(task)
+		}
+
+		// Drop the script cache while jobs are still queued
+		if err := admin.ScriptFlush(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, handle := range handles {
+			if _, err := handle.Wait(); err != nil {
+				t.Fatalf("Round %d: expected job to succeed after script flush, got %v", round, err)
+			}
+		}
+	}
+}
+
+func TestRedisStore_RegisterDoneClampsAtZero(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 1, KeyTTL: time.Minute}
+	key := "gothrottle:{double-done}"
+
+	if _, _, err := store.Request("double-done", 1, opts); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.RegisterDone("double-done", 1); err != nil {
+			t.Fatal(err)
+		}
+		if running := mr.HGet(key, "running"); running != "0" {
+			t.Fatalf("Expected running to stay at 0, got %s", running)
+		}
+	}
+	if ttl := mr.TTL(key); ttl != time.Minute {
+		t.Errorf("Expected RegisterDone to keep the TTL at %v, got %v", time.Minute, ttl)
+	}
+
+	// A negative count would let a second job through
+	canRun, _, err := store.Request("double-done", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Fatal("First request after RegisterDone should be allowed")
+	}
+	canRun, _, err = store.Request("double-done", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Error("Second request should be denied")
+	}
+
+	// Releasing a job of an expired limiter must not recreate its key without a TTL
+	if err := store.RegisterDone("expired", 1); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("gothrottle:{expired}") {
+		t.Error("RegisterDone should not create a key for an unknown limiter")
+	}
+}
+
+func TestRedisStore_StaleTimeout(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 2, StaleTimeout: 50 * time.Millisecond}
+	key := "gothrottle:{stale}"
+
+	// The first instance takes a slot and crashes without RegisterDone
+	if _, _, err := store.Request("stale", 1, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	canRun, _, err := store.Request("stale", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Fatal("Second request should be allowed")
+	}
+	if canRun, _, _ = store.Request("stale", 1, opts); canRun {
+		t.Fatal("Third request should be denied while both slots are held")
+	}
+
+	// Once the crashed slot is stale, it is released but the live one is kept
+	time.Sleep(30 * time.Millisecond)
+	canRun, _, err = store.Request("stale", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canRun {
+		t.Fatal("Request should be allowed after the stale slot was released")
+	}
+	if lastStart := mr.HGet(key, "last_start"); lastStart == "" {
+		t.Error("Releasing a stale slot should keep the rest of the limiter state")
+	}
+
+	// Finishing the live jobs frees their slots, leaving none running
+	for i := 0; i < 2; i++ {
+		if err := store.RegisterDone("stale", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if running := mr.HGet(key, "running"); running != "0" {
+		t.Errorf("Expected running 0, got %s", running)
+	}
+}
+
+func TestRedisStore_Healthy(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("Expected Ping to succeed, got %v", err)
+	}
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "health", Datastore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !limiter.Healthy() {
+		t.Error("Expected limiter to be healthy while Redis is up")
+	}
+
+	mr.Close()
+	if store.Ping(context.Background()) == nil {
+		t.Error("Expected Ping to fail once Redis is down")
+	}
+	if limiter.Healthy() {
+		t.Error("Expected limiter to be unhealthy while Redis is down")
+	}
+
+	_ = limiter.Stop() // Disconnect may fail with Redis down
+	if limiter.Healthy() {
+		t.Error("Expected a stopped limiter to be unhealthy")
+	}
+}
+
+func TestRedisStore_Reconnect(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	var clients []*redis.Client
+	store, err := gothrottle.NewRedisStoreFunc(func() redis.UniversalClient {
+		client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+		clients = append(clients, client)
+		return client
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := gothrottle.Options{ID: "reconnect"}
+
+	if _, _, err := store.Request("reconnect", 1, opts); err != nil {
+		t.Fatal(err)
+	}
+	if state := store.State(); state != gothrottle.RedisConnected {
+		t.Errorf("Expected connected, got %v", state)
+	}
+
+	// A closed client never recovers on its own; the store replaces it right away
+	_ = clients[0].Close()
+	if _, _, err := store.Request("reconnect", 1, opts); err == nil {
+		t.Fatal("Expected an error from a closed client")
+	}
+	if state := store.State(); state != gothrottle.RedisDisconnected {
+		t.Errorf("Expected disconnected, got %v", state)
+	}
+	if _, _, err := store.Request("reconnect", 1, opts); err != nil {
+		t.Fatalf("Expected the store to reconnect, got %v", err)
+	}
+	if len(clients) != 2 || store.State() != gothrottle.RedisConnected {
+		t.Errorf("Expected a second client and a connected store, got %d clients and %v", len(clients), store.State())
+	}
+
+	// While Redis is down reconnects are backed off, and the store recovers once it is back
+	mr.Close()
+	for i := 0; i < 5; i++ {
+		if _, _, err := store.Request("reconnect", 1, opts); err == nil {
+			t.Fatal("Expected an error while Redis is down")
+		}
+	}
+	if len(clients) != 3 {
+		t.Errorf("Expected one reconnect attempt within the backoff, got %d", len(clients)-2)
+	}
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, _, err = store.Request("reconnect", 1, opts); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected the store to recover, got %v", err)
+	}
+	if state := store.State(); state != gothrottle.RedisConnected {
+		t.Errorf("Expected connected, got %v", state)
+	}
+
+	if err := store.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if state := store.State(); state != gothrottle.RedisClosed {
+		t.Errorf("Expected closed, got %v", state)
+	}
+}
+
+func TestRedisStore_ReconnectHung(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	// A server that accepts connections but never answers
+	hung, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = hung.Close() }() // Ignore error in test cleanup
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := hung.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					_ = conn.Close() // Ignore error in test cleanup
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	var clients []*redis.Client
+	dialing := make(chan struct{})
+	store, err := gothrottle.NewRedisStoreFunc(func() redis.UniversalClient {
+		addr := mr.Addr()
+		if len(clients) > 0 {
+			addr = hung.Addr().String()
+			close(dialing)
+		}
+		client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+		clients = append(clients, client)
+		return client
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Disconnect() }() // Ignore error in test cleanup
+	opts := gothrottle.Options{ID: "hung"}
+
+	_ = clients[0].Close()
+	if _, _, err := store.Request("hung", 1, opts); err == nil {
+		t.Fatal("Expected an error from a closed client")
+	}
+
+	// One caller reconnects to the hung server while others fail fast
+	reconnected := make(chan error, 1)
+	go func() {
+		_, _, err := store.Request("hung", 1, opts)
+		reconnected <- err
+	}()
+	<-dialing
+	start := time.Now()
+	if _, err := store.CurrentUsage("hung"); err == nil {
+		t.Error("Expected an error from the old client")
+	}
+	if state := store.State(); state != gothrottle.RedisDisconnected {
+		t.Errorf("Expected disconnected, got %v", state)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected other calls not to wait for the reconnect, took %v", elapsed)
+	}
+
+	// The reconnect gives up within its timeout
+	select {
+	case err := <-reconnected:
+		if err == nil {
+			t.Error("Expected the request on the hung server to fail")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the reconnect to time out")
+	}
+}
+
+func TestRedisStore_Snapshot(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	opts := gothrottle.Options{MinTime: time.Millisecond, WindowLimit: 10, WindowDuration: time.Minute}
+
+	before := time.Now().Truncate(time.Millisecond)
+	for _, id := range []string{"a", "a", "b", "group/child"} {
+		if _, _, err := store.Request(id, 1, opts); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := store.RegisterDone("b", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := store.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"a": 2, "b": 0, "group/child": 1}
+	if len(states) != len(want) {
+		t.Fatalf("Expected %d limiter IDs without the :window keys, got %v", len(want), states)
+	}
+	for id, running := range want {
+		state := states[id]
+		if state.Running != running {
+			t.Errorf("Expected %q to have %d running, got %d", id, running, state.Running)
+		}
+		if state.LastStart.Before(before) || state.LastStart.After(time.Now()) {
+			t.Errorf("Expected %q to have a recent last start, got %v", id, state.LastStart)
+		}
+	}
+}
+
+func TestRedisStore_MinTimeConcurrentGrants(t *testing.T) {
+	_, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MinTime: 20 * time.Millisecond}
+
+	// Several instances share the limiter, as they would across processes
+	stores := make([]*gothrottle.RedisStore, 4)
+	for i := range stores {
+		store, err := gothrottle.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = store.Disconnect() })
+		stores[i] = store
+	}
+
+	var (
+		mu     sync.Mutex
+		grants []time.Time
+		wg     sync.WaitGroup
+	)
+	start := time.Now()
+	deadline := start.Add(200 * time.Millisecond)
+	for i := 0; i < 8; i++ {
+		store := stores[i%len(stores)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				canRun, _, err := store.Request("burst", 1, opts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if canRun {
+					granted := time.Now()
+					mu.Lock()
+					grants = append(grants, granted)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if limit := int(time.Since(start)/opts.MinTime) + 1; len(grants) > limit {
+		t.Errorf("Expected at most %d grants with MinTime %v, got %d", limit, opts.MinTime, len(grants))
+	}
+	if len(grants) < 2 {
+		t.Fatalf("Expected several grants, got %d", len(grants))
+	}
+
+	// The script stores each start in microseconds, so its spacing can be checked exactly
+	lastUs, err := strconv.ParseInt(mr.HGet("gothrottle:{burst}", "last_start_us"), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected last_start_us to be stored: %v", err)
+	}
+	canRun, wait, err := stores[0].Request("burst", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(time.UnixMicro(lastUs)); canRun && elapsed < opts.MinTime {
+		t.Errorf("Request granted %v after the previous start, before MinTime %v", elapsed, opts.MinTime)
+	} else if !canRun && wait <= 0 {
+		t.Errorf("Expected a positive wait while MinTime has not elapsed, got %v", wait)
+	}
+}
+
+func TestRedisStore_MinTimeLegacyKey(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MinTime: time.Minute}
+
+	// A key written before last_start_us existed only has the start in milliseconds
+	mr.HSet("gothrottle:{legacy}", "running", "0", "last_start", strconv.FormatInt(time.Now().UnixMilli(), 10))
+
+	canRun, wait, err := store.Request("legacy", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canRun {
+		t.Fatal("Expected MinTime to be enforced from the millisecond start")
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("Expected a wait of up to %v, got %v", time.Minute, wait)
+	}
+}
+
+func TestRedisStore_RegisterDonePartial(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 5}
+
+	if canRun, _, err := store.Request("partial", 5, opts); err != nil || !canRun {
+		t.Fatalf("Expected the heavy job to be granted, got %v, %v", canRun, err)
+	}
+
+	if err := store.RegisterDonePartial("partial", 2); err != nil {
+		t.Fatal(err)
+	}
+	if running := mr.HGet("gothrottle:{partial}", "running"); running != "3" {
+		t.Errorf("Expected running 3 after a partial release, got %s", running)
+	}
+	if canRun, _, err := store.Request("partial", 2, opts); err != nil || !canRun {
+		t.Fatalf("Expected the released weight to be granted, got %v, %v", canRun, err)
+	}
+
+	if err := store.RegisterDone("partial", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RegisterDone("partial", 2); err != nil {
+		t.Fatal(err)
+	}
+	if running := mr.HGet("gothrottle:{partial}", "running"); running != "0" {
+		t.Errorf("Expected running 0 after both jobs finished, got %s", running)
+	}
+}
+
+func TestRedisStore_RegisterDonePartialStaleTimeout(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	opts := gothrottle.Options{MaxConcurrent: 10, StaleTimeout: time.Minute}
+
+	// Two jobs of different weights hold slots
+	for _, weight := range []int{5, 3} {
+		if canRun, _, err := store.Request("partial", weight, opts); err != nil || !canRun {
+			t.Fatalf("Expected the weight %d job to be granted, got %v, %v", weight, canRun, err)
+		}
+	}
+
+	// The slot of the weight 3 job can't be told from the other one, so a partial
+	// release is refused rather than shrinking the wrong slot
+	if err := store.RegisterDonePartial("partial", 1); err != gothrottle.ErrPartialReleaseUnsupported {
+		t.Fatalf("Expected ErrPartialReleaseUnsupported, got %v", err)
+	}
+	if usage, err := store.CurrentUsage("partial"); err != nil || usage != 8 {
+		t.Errorf("Expected usage 8 after the refused release, got %d, %v", usage, err)
+	}
+
+	for _, weight := range []int{5, 3} {
+		if err := store.RegisterDone("partial", weight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if usage, err := store.CurrentUsage("partial"); err != nil || usage != 0 {
+		t.Errorf("Expected usage 0 after both jobs finished, got %d, %v", usage, err)
+	}
+	if slots, _ := mr.ZMembers("gothrottle:{partial}:slots"); len(slots) != 0 {
+		t.Errorf("Expected every slot to be released, got %v", slots)
+	}
+
+	// JobHandle.Release refuses up front, leaving the job's weight to its final release
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{ID: "release", Datastore: store, MaxConcurrent: 10, StaleTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	finish := make(chan struct{})
+	started := make(chan struct{})
+	heavy := limiter.SubmitWithOptions(func() (interface{}, error) {
+		close(started)
+		<-finish
+		return nil, nil
+	}, 5, 5)
+	<-started
+	light := limiter.SubmitWithOptions(func() (interface{}, error) { return nil, nil }, 5, 3)
+	if _, err := light.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := heavy.Release(1); err != gothrottle.ErrPartialReleaseUnsupported {
+		t.Errorf("Expected ErrPartialReleaseUnsupported, got %v", err)
+	}
+	close(finish)
+	if _, err := heavy.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.WaitUntilIdle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := store.CurrentUsage("release"); err != nil || usage != 0 {
+		t.Errorf("Expected usage 0 after both jobs finished, got %d, %v", usage, err)
+	}
+}
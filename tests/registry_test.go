@@ -0,0 +1,76 @@
+// FILENAME: registry_test.go
+package gothrottle_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := gothrottle.NewRegistry()
+	store := gothrottle.NewLocalStore()
+	opts := gothrottle.Options{MaxConcurrent: 1, Datastore: store}
+
+	// Concurrent callers get the same limiter for the same ID
+	var wg sync.WaitGroup
+	limiters := make([]*gothrottle.Limiter, 10)
+	for i := range limiters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiter, err := registry.GetOrCreate("users", opts)
+			if err != nil {
+				t.Error(err)
+			}
+			limiters[i] = limiter
+		}(i)
+	}
+	wg.Wait()
+	for _, limiter := range limiters[1:] {
+		if limiter != limiters[0] {
+			t.Fatal("Expected one limiter per ID")
+		}
+	}
+	if id := limiters[0].Options().ID; id != "users" {
+		t.Errorf("Expected limiter ID %q, got %q", "users", id)
+	}
+
+	orders, err := registry.GetOrCreate("orders", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders == limiters[0] {
+		t.Fatal("Expected a separate limiter for another ID")
+	}
+
+	// StopAll lets queued jobs of every limiter run before the shared store is disconnected
+	slow := func() (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	}
+	var handles []*gothrottle.JobHandle
+	for i := 0; i < 3; i++ {
+		handles = append(handles, limiters[0].Submit(slow), orders.Submit(slow))
+	}
+	if err := registry.StopAll(); err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range handles {
+		if _, err := h.Wait(); err != nil {
+			t.Errorf("Job %d: unexpected error: %v", i, err)
+		}
+	}
+
+	// The registry can be reused after StopAll
+	fresh, err := registry.GetOrCreate("users", gothrottle.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = fresh.Stop() }() // Ignore error in test cleanup
+	if fresh == limiters[0] {
+		t.Error("Expected StopAll to remove stopped limiters")
+	}
+}
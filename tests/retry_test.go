@@ -0,0 +1,121 @@
+// FILENAME: retry_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestLimiter_ScheduleWithRetry(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+
+	tests := []struct {
+		name         string
+		failures     int
+		failWith     error
+		policy       gothrottle.RetryPolicy
+		wantErr      error
+		wantAttempts int
+		maxElapsed   time.Duration
+	}{
+		{
+			name:         "succeeds after retries",
+			failures:     2,
+			failWith:     errTransient,
+			policy:       gothrottle.RetryPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond, Multiplier: 2},
+			wantAttempts: 3,
+		},
+		{
+			name:         "returns last error when attempts run out",
+			failures:     5,
+			failWith:     errTransient,
+			policy:       gothrottle.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			wantErr:      errTransient,
+			wantAttempts: 3,
+		},
+		{
+			name:     "stops when RetryIf rejects the error",
+			failures: 5,
+			failWith: errPermanent,
+			policy: gothrottle.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				RetryIf:        func(err error) bool { return err == errTransient },
+			},
+			wantErr:      errPermanent,
+			wantAttempts: 1,
+		},
+		{
+			name:     "caps backoff at MaxBackoff",
+			failures: 3,
+			failWith: errTransient,
+			policy: gothrottle.RetryPolicy{
+				MaxAttempts:    4,
+				InitialBackoff: 5 * time.Millisecond,
+				Multiplier:     100, // uncapped, the retries would wait 5ms, 500ms and 50s
+				MaxBackoff:     10 * time.Millisecond,
+			},
+			wantAttempts: 4,
+			maxElapsed:   200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			start := time.Now()
+			result, err := limiter.ScheduleWithRetry(func() (interface{}, error) {
+				attempts++
+				if attempts <= tt.failures {
+					return nil, tt.failWith
+				}
+				return "ok", nil
+			}, tt.policy)
+
+			if err != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && result != "ok" {
+				t.Errorf("Expected 'ok', got %v", result)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+			if elapsed := time.Since(start); tt.maxElapsed > 0 && elapsed > tt.maxElapsed {
+				t.Errorf("Expected retries to finish within %v, took %v", tt.maxElapsed, elapsed)
+			}
+		})
+	}
+}
+
+func TestLimiter_ScheduleWithRetryInvalidPriority(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{MinPriority: 6, MaxPriority: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// The default priority 5 is rejected, which no retry can fix
+	start := time.Now()
+	_, err = limiter.ScheduleWithRetry(func() (interface{}, error) {
+		return "ok", nil
+	}, gothrottle.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second})
+	if !errors.Is(err, gothrottle.ErrInvalidPriority) {
+		t.Errorf("Expected ErrInvalidPriority, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected no retry, took %v", elapsed)
+	}
+}
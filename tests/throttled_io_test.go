@@ -0,0 +1,62 @@
+// FILENAME: throttled_io_test.go
+package gothrottle_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AFZidan/gothrottle"
+	"github.com/AFZidan/gothrottle/ioutil"
+)
+
+func TestThrottledIO_Throughput(t *testing.T) {
+	const (
+		unit  = 1024                   // bytes per unit of weight
+		rate  = 100 * unit             // bytes per second with 10ms per unit
+		total = 20 * unit              // bytes copied
+		chunk = 4 * unit               // MaxConcurrent caps a job at 4 units
+		slack = 100 * time.Millisecond // scheduling overhead under the race detector
+	)
+
+	copies := map[string]func(l *gothrottle.Limiter, dst io.Writer, src io.Reader) (int64, error){
+		"reader": func(l *gothrottle.Limiter, dst io.Writer, src io.Reader) (int64, error) {
+			return io.Copy(dst, ioutil.NewThrottledReader(src, l, unit))
+		},
+		"writer": func(l *gothrottle.Limiter, dst io.Writer, src io.Reader) (int64, error) {
+			return io.Copy(ioutil.NewThrottledWriter(dst, l, unit), src)
+		},
+	}
+
+	for name, copyFn := range copies {
+		t.Run(name, func(t *testing.T) {
+			limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+				MaxConcurrent: 4,
+				MinTime:       10 * time.Millisecond,
+				Algorithm:     gothrottle.AlgorithmGCRA,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+			var dst bytes.Buffer
+			start := time.Now()
+			n, err := copyFn(limiter, &dst, bytes.NewReader(make([]byte, total)))
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != total || dst.Len() != total {
+				t.Fatalf("Expected %d bytes copied, got %d", total, n)
+			}
+
+			// The first chunk goes through at once, the rest at the configured rate
+			expected := time.Duration(total-chunk) * time.Second / rate
+			if elapsed < expected-10*time.Millisecond || elapsed > expected+slack {
+				t.Errorf("Expected copy to take about %v, took %v (%.0f bytes/s)", expected, elapsed, float64(total)/elapsed.Seconds())
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// FILENAME: tier_test.go
+package gothrottle_test
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/AFZidan/gothrottle"
+)
+
+func TestLimiter_TierShares(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent: 1, // Force serialization
+		Tiers: []gothrottle.Tier{
+			{Name: "interactive", Share: 70},
+			{Name: "batch", Share: 30},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Hold the only slot until both tiers have a backlog
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = limiter.Schedule(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	submit := func(tier string, priority int) {
+		defer wg.Done()
+		_, err := limiter.ScheduleInTier(tier, func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, tier)
+			mu.Unlock()
+			return nil, nil
+		}, priority, 1)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	const perTier = 20
+	wg.Add(2 * perTier)
+	for i := 0; i < perTier; i++ {
+		// Batch jobs have a higher priority, but shares decide between tiers
+		go submit("interactive", 1)
+		go submit("batch", 9)
+	}
+	for limiter.Stats().Queued < 2*perTier {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	interactive := 0
+	for _, tier := range order[:20] {
+		if tier == "interactive" {
+			interactive++
+		}
+	}
+	// 70% of 20 is 14, give or take the job that held the slot
+	if interactive < 13 || interactive > 15 {
+		t.Errorf("Expected about 14 of the first 20 jobs from the 70%% tier, got %d: %v", interactive, order[:20])
+	}
+}
+
+func TestLimiter_PriorityShares(t *testing.T) {
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		MaxConcurrent:  1, // Force serialization
+		PriorityShares: map[int]int{10: 3, 1: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	// Hold the only slot until both priorities have a backlog
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = limiter.Schedule(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	submit := func(priority int) {
+		defer wg.Done()
+		_, err := limiter.ScheduleWithOptions(func() (interface{}, error) {
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			return nil, nil
+		}, priority, 1)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	const perPriority = 20
+	wg.Add(2 * perPriority)
+	for i := 0; i < perPriority; i++ {
+		go submit(10)
+		go submit(1)
+	}
+	for limiter.Stats().Queued < 2*perPriority {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	// Strict priority would run every priority 10 job first; with shares of 3:1
+	// about 15 of the first 20 jobs are priority 10
+	high := 0
+	for _, priority := range order[:20] {
+		if priority == 10 {
+			high++
+		}
+	}
+	if high < 14 || high > 16 {
+		t.Errorf("Expected about 15 of the first 20 jobs at priority 10, got %d: %v", high, order[:20])
+	}
+}
+
+func TestLimiter_TierValidation(t *testing.T) {
+	invalid := [][]gothrottle.Tier{
+		{{Name: "", Share: 1}},
+		{{Name: "a", Share: 0}},
+		{{Name: "a", Share: 1}, {Name: "a", Share: 2}},
+	}
+	for _, tiers := range invalid {
+		if _, err := gothrottle.NewLimiter(gothrottle.Options{Tiers: tiers}); !errors.Is(err, gothrottle.ErrInvalidTier) {
+			t.Errorf("Expected ErrInvalidTier for %v, got %v", tiers, err)
+		}
+	}
+
+	limiter, err := gothrottle.NewLimiter(gothrottle.Options{
+		Tiers: []gothrottle.Tier{{Name: "default", Share: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = limiter.Stop() }() // Ignore error in test cleanup
+
+	task := func() (interface{}, error) { return "ok", nil }
+	if _, err := limiter.ScheduleInTier("missing", task, 5, 1); err != gothrottle.ErrUnknownTier {
+		t.Errorf("Expected ErrUnknownTier, got %v", err)
+	}
+	if result, err := limiter.Schedule(task); err != nil || result != "ok" {
+		t.Errorf("Expected jobs without a tier to run in the first tier, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,179 @@
+// FILENAME: tier.go
+package gothrottle
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tier is a class of jobs that is guaranteed a share of the limiter's capacity.
+// When several tiers have jobs queued, each is granted weight in proportion to its
+// Share, e.g. shares of 70 and 30 give the first tier 70% of the granted weight.
+// Priority only orders jobs within a tier; a tier without queued jobs leaves its
+// share to the others.
+type Tier struct {
+	Name  string
+	Share int
+}
+
+// validateTiers checks that every tier has a unique name and a positive share.
+func validateTiers(tiers []Tier) error {
+	seen := make(map[string]bool, len(tiers))
+	for _, tier := range tiers {
+		if tier.Name == "" || tier.Share <= 0 || seen[tier.Name] {
+			return fmt.Errorf("%w: %q", ErrInvalidTier, tier.Name)
+		}
+		seen[tier.Name] = true
+	}
+	return nil
+}
+
+// fairQueue is start-time fair queuing state over classes of jobs identified by K,
+// granting each class weight in proportion to its share.
+type fairQueue[K comparable] struct {
+	// vtime is the weight granted to each class divided by its share
+	vtime map[K]float64
+	// now is the virtual time of the last granted job. A class returning from idle
+	// starts from here, so it cannot bank its unused share.
+	now float64
+}
+
+// start returns the virtual time at which a class's next job starts.
+func (fq *fairQueue[K]) start(class K) float64 {
+	if v := fq.vtime[class]; v > fq.now {
+		return v
+	}
+	return fq.now
+}
+
+// grant records that a job of the given weight was granted to a class.
+func (fq *fairQueue[K]) grant(class K, weight, share int) {
+	if fq.vtime == nil {
+		fq.vtime = make(map[K]float64)
+	}
+	fq.now = fq.start(class)
+	fq.vtime[class] = fq.now + float64(weight)/float64(share)
+}
+
+// tierScheduler picks jobs across tiers in proportion to their shares, using
+// start-time fair queuing over the weight granted to each tier. Guarded by Limiter.mu.
+type tierScheduler struct {
+	fairQueue[string]
+}
+
+// tierOf returns the tier a job runs in. Jobs without a tier, or whose tier was
+// removed by UpdateOptions, run in the first tier.
+func tierOf(job *Job, tiers []Tier) Tier {
+	for _, tier := range tiers {
+		if tier.Name == job.tier {
+			return tier
+		}
+	}
+	return tiers[0]
+}
+
+// pop removes and returns the highest priority job of the tier furthest behind
+// its share. Ties go to the tier listed first. If eligible is not nil, jobs for
+// which it returns false are skipped.
+func (ts *tierScheduler) pop(pq *PriorityQueue, tiers []Tier, eligible func(job *Job) bool) *Job {
+	heads := make(map[string]*Job, len(tiers))
+	for _, job := range *pq {
+		if eligible != nil && !eligible(job) {
+			continue
+		}
+		name := tierOf(job, tiers).Name
+		if head := heads[name]; head == nil || runsBefore(job, head) {
+			heads[name] = job
+		}
+	}
+
+	var next *Job
+	var nextStart float64
+	for _, tier := range tiers {
+		head := heads[tier.Name]
+		if head == nil {
+			continue
+		}
+		if start := ts.start(tier.Name); next == nil || start < nextStart {
+			next, nextStart = head, start
+		}
+	}
+
+	if next == nil || !pq.RemoveJob(next) {
+		return nil
+	}
+	return next
+}
+
+// charge records that a job of the given weight was granted to its tier.
+func (ts *tierScheduler) charge(job *Job, tiers []Tier) {
+	tier := tierOf(job, tiers)
+	ts.grant(tier.Name, job.Weight, tier.Share)
+}
+
+// bandScheduler picks jobs across priority levels in proportion to the shares of
+// Options.PriorityShares, using the same fair queuing as tierScheduler. Guarded by
+// Limiter.mu.
+type bandScheduler struct {
+	fairQueue[int]
+}
+
+// bandShare returns the share of a priority level, 1 for levels without an entry.
+func bandShare(shares map[int]int, priority int) int {
+	if share, ok := shares[priority]; ok {
+		return share
+	}
+	return 1
+}
+
+// pop removes and returns the oldest job of the priority level furthest behind its
+// share. Ties go to the higher priority. If eligible is not nil, jobs for which it
+// returns false are skipped.
+func (bs *bandScheduler) pop(pq *PriorityQueue, eligible func(job *Job) bool) *Job {
+	heads := make(map[int]*Job)
+	for _, job := range *pq {
+		if eligible != nil && !eligible(job) {
+			continue
+		}
+		if head := heads[job.Priority]; head == nil || runsBefore(job, head) {
+			heads[job.Priority] = job
+		}
+	}
+
+	var next *Job
+	var nextStart float64
+	for priority, head := range heads {
+		start := bs.start(priority)
+		if next == nil || start < nextStart || (start == nextStart && priority > next.Priority) {
+			next, nextStart = head, start
+		}
+	}
+
+	if next == nil || !pq.RemoveJob(next) {
+		return nil
+	}
+	return next
+}
+
+// charge records that a job of the given weight was granted to its priority level.
+func (bs *bandScheduler) charge(job *Job, shares map[int]int) {
+	bs.grant(job.Priority, job.Weight, bandShare(shares, job.Priority))
+}
+
+// ScheduleInTier submits a job to the named tier of Options.Tiers with custom
+// priority and weight, and blocks until completion. It returns ErrUnknownTier if
+// no such tier is configured. Jobs submitted any other way run in the first tier.
+func (l *Limiter) ScheduleInTier(tier string, task func() (interface{}, error), priority, weight int) (interface{}, error) {
+	job := newJob(context.Background(), task, priority, weight)
+	job.tier = tier
+	if err := l.enqueue(weight, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-job.resultChan:
+		return result, nil
+	case err := <-job.errorChan:
+		return nil, err
+	}
+}